@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"fusion/app/database"
 	"fusion/app/handlers"
@@ -11,9 +12,16 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"log"
+	"os"
+	"strings"
+	"time"
 )
 
 func main() {
+	// Сверка остатков со складским журналом выполняется по команде администратора, а не при каждом запуске
+	reconcileStock := flag.Bool("reconcile-stock", false, "recalculate stock from the stock journal and exit")
+	flag.Parse()
+
 	var config utils.AppConfig
 	if err := config.LoadConfig(); err != nil {
 		log.Fatalf("Error loading config: %v", err)
@@ -27,30 +35,260 @@ func main() {
 		config.SmtpSender,
 	)
 
+	var sms utils.SMSService
+	switch config.SmsProvider {
+	case "console", "file":
+		// Отладочные провайдеры не отправляют SMS, а код подтверждения попадает в лог или файл
+		if config.AppEnv == "production" {
+			log.Fatalf("SMS provider %s is not allowed in production", config.SmsProvider)
+		}
+		sms = utils.NewConsoleSMSService()
+		if config.SmsProvider == "file" {
+			sms = utils.NewFileSMSService(config.SmsFile)
+		}
+	case "":
+		// Без провайдера номер телефона подтвердить нельзя: маршруты отправки и подтверждения кода не регистрируются
+		log.Printf("Warning: SMS_PROVIDER is not set, phone verification is disabled")
+	default:
+		log.Fatalf("Unsupported SMS provider: %s", config.SmsProvider)
+	}
+
 	jwt := utils.NewJWTService(config.SessionSecret)
+	if config.JwtAlgorithm != "" && config.JwtAlgorithm != "HS256" {
+		var legacyUntil time.Time
+		if config.JwtLegacyUntil != "" {
+			var err error
+			legacyUntil, err = time.Parse(time.RFC3339, config.JwtLegacyUntil)
+			if err != nil {
+				log.Fatalf("Invalid JWT_LEGACY_UNTIL: %v", err)
+			}
+		}
+
+		var err error
+		jwt, err = utils.NewAsymmetricJWTService(
+			config.SessionSecret,
+			config.JwtAlgorithm,
+			config.JwtKeysDir,
+			config.JwtKeyRotation,
+			config.RefreshExpire,
+			legacyUntil,
+		)
+		if err != nil {
+			log.Fatalf("Error loading signing keys: %v", err)
+		}
+	}
+
+	providers := make(map[string]utils.IdentityProvider)
+	for name, provider := range config.Oidc {
+		providers[name] = utils.NewOIDCProvider(
+			provider.Issuer,
+			provider.ClientID,
+			provider.ClientSecret,
+			config.OidcCallbackURL+"/"+name+"/callback",
+			[]string{"email", "profile"},
+		)
+	}
+
+	if config.ReservationExpire == 0 {
+		config.ReservationExpire = 30 * time.Minute
+	}
+
+	if config.ImpersonateExpire == 0 {
+		config.ImpersonateExpire = 15 * time.Minute
+	}
+
+	if config.IdempotencyExpire == 0 {
+		config.IdempotencyExpire = 24 * time.Hour
+	}
+
+	if config.Currency == "" {
+		config.Currency = "RUB"
+	}
+
+	var payments utils.PaymentProvider
+	switch config.PaymentProvider {
+	case "fake":
+		// Локальный шлюз списывает платежи без оплаты, поэтому в production он запрещен
+		if config.AppEnv == "production" {
+			log.Fatalf("Payment provider fake is not allowed in production")
+		}
+		if config.PaymentWebhookURL == "" {
+			config.PaymentWebhookURL = "http://localhost:" + config.AppPort + "/payments/webhook"
+		}
+		// Без заданного секрета вебхуки подписываются случайным ключом, известным только этому процессу
+		if config.PaymentWebhookSecret == "" {
+			secret, err := utils.GenerateRandomToken()
+			if err != nil {
+				log.Fatalf("Error generating payment webhook secret: %v", err)
+			}
+			config.PaymentWebhookSecret = secret
+		}
+		payments = utils.NewFakePaymentProvider(config.PaymentWebhookSecret, config.PaymentWebhookURL)
+	case "":
+		// Без провайдера магазин работает без оплаты: маршруты /payments не регистрируются
+		log.Printf("Warning: PAYMENT_PROVIDER is not set, payments are disabled")
+	default:
+		log.Fatalf("Unsupported payment provider: %s", config.PaymentProvider)
+	}
+
+	if config.UploadMaxSize == 0 {
+		config.UploadMaxSize = 5 << 20
+	}
+
+	var storage utils.BlobStorage
+	if config.StorageProvider == "s3" {
+		var err error
+		storage, err = utils.NewS3Storage(utils.S3Config{
+			Endpoint:  config.S3Endpoint,
+			Region:    config.S3Region,
+			Bucket:    config.S3Bucket,
+			AccessKey: config.S3AccessKey,
+			SecretKey: config.S3SecretKey,
+			PublicURL: config.StoragePublicURL,
+		})
+		if err != nil {
+			log.Fatalf("Error configuring storage: %v", err)
+		}
+	} else {
+		if config.StorageDir == "" {
+			config.StorageDir = "uploads"
+		}
+		if config.StoragePublicURL == "" {
+			config.StoragePublicURL = "/uploads"
+		}
+		storage = utils.NewLocalStorage(config.StorageDir, config.StoragePublicURL)
+	}
+
 	db, err := database.ConnectDB(config)
 
-	app := fiber.New(fiber.Config{
+	if config.UploadPort == "" {
+		config.UploadPort = "8081"
+	}
+
+	fiberConfig := fiber.Config{
 		JSONEncoder:  json.Marshal,
 		JSONDecoder:  json.Unmarshal,
 		ServerHeader: "Fusion",
 		AppName:      fmt.Sprintf("Fusion App v%s", config.AppVersion),
-	})
+	}
+
+	// Адрес клиента из заголовка прокси принимается только от доверенных прокси,
+	// иначе любой клиент подменит его и обойдет ограничение попыток входа по адресу
+	if config.TrustedProxies != "" {
+		fiberConfig.EnableTrustedProxyCheck = true
+		fiberConfig.EnableIPValidation = true
+		fiberConfig.ProxyHeader = config.ProxyHeader
+		if fiberConfig.ProxyHeader == "" {
+			fiberConfig.ProxyHeader = fiber.HeaderXForwardedFor
+		}
+		for _, proxy := range strings.Split(config.TrustedProxies, ",") {
+			if proxy = strings.TrimSpace(proxy); proxy != "" {
+				fiberConfig.TrustedProxies = append(fiberConfig.TrustedProxies, proxy)
+			}
+		}
+	}
+
+	app := fiber.New(fiberConfig)
+
+	// Загрузки изображений обслуживает отдельный сервер на UPLOAD_PORT: сервер отклоняет тело
+	// больше BodyLimit по Content-Length еще до чтения, поэтому большее ограничение действует
+	// только для маршрутов загрузки, а остальные запросы ограничены стандартными 4 МБ
+	uploadConfig := fiberConfig
+	uploadConfig.BodyLimit = max(fiber.DefaultBodyLimit, config.UploadMaxSize*utils.MaxUploadFiles+1<<20)
+	uploads := fiber.New(uploadConfig)
+
+	corsConfig := cors.ConfigDefault
+	if config.CorsOrigins != "" {
+		// С cookie браузер не принимает ответ для любого источника, а Fiber падает на такой настройке
+		for _, origin := range strings.Split(config.CorsOrigins, ",") {
+			if strings.TrimSpace(origin) == "*" {
+				log.Fatalf("CORS_ORIGINS must list explicit origins, wildcard is not allowed with credentials")
+			}
+		}
+		corsConfig.AllowOrigins = config.CorsOrigins
+		corsConfig.AllowCredentials = true
+		corsConfig.AllowHeaders = "Origin, Content-Type, Accept, Authorization, " + middleware.CSRFHeader + ", " + middleware.TransportHeader + ", " + middleware.IdempotencyHeader
+	}
+
+	for _, server := range []*fiber.App{app, uploads} {
+		server.Use(cors.New(corsConfig))
+		server.Use(recover.New())
+	}
 
-	app.Use(cors.New())
-	app.Use(recover.New())
+	if config.StorageProvider != "s3" {
+		app.Static("/uploads", config.StorageDir)
+	}
 
 	if err != nil {
 		log.Fatalf("Error connecting to database: %v", err)
 	}
 
-	app.Use(middleware.InjectorMiddleware(config, db, jwt, email))
-	handlers.RegisterAuthRoutes(app, db, config, jwt, email)
-	handlers.RegisterUserRoutes(app, db)
-	handlers.RegisterProductRoutes(app, db)
-	handlers.RegisterOrderRoutes(app, db)
+	if *reconcileStock {
+		corrected, err := database.ReconcileStock(db)
+		if err != nil {
+			log.Fatalf("Error reconciling stock: %v", err)
+		}
+		log.Printf("Stock reconciled, %d rows corrected", corrected)
+		return
+	}
+
+	attempts := utils.NewMemoryAttemptStore()
+	if config.LoginThrottleStore == "postgres" {
+		attempts = database.NewPostgresAttemptStore(db)
+	}
+
+	guard := utils.NewLoginGuard(attempts, utils.LoginPolicy{
+		MaxAccountAttempts: config.LoginMaxAttempts,
+		MaxIPAttempts:      config.LoginIPMaxAttempts,
+		Backoff:            config.LoginBackoff,
+		Lockout:            config.LoginLockout,
+	})
+
+	// Без явной настройки сохраняется прежнее требование к длине пароля
+	passwords := utils.PasswordPolicy{
+		MinLength:     config.PasswordMinLength,
+		RequireUpper:  config.PasswordRequireUpper,
+		RequireLower:  config.PasswordRequireLower,
+		RequireDigit:  config.PasswordRequireDigit,
+		RequireSymbol: config.PasswordRequireSymbol,
+		History:       config.PasswordHistory,
+	}
+	if passwords.MinLength == 0 {
+		passwords.MinLength = 8
+	}
+	if config.PasswordBreachFile != "" {
+		if _, err := os.Stat(config.PasswordBreachFile); err != nil {
+			log.Fatalf("Error opening breached passwords dataset: %v", err)
+		}
+		passwords.Breaches = utils.NewBreachChecker(utils.NewFileHashRange(config.PasswordBreachFile))
+	}
+
+	for _, server := range []*fiber.App{app, uploads} {
+		server.Use(middleware.InjectorMiddleware(config, db, jwt, email))
+	}
+	handlers.RegisterAuthRoutes(app, db, config, jwt, email, providers, guard, passwords)
+	handlers.RegisterWellKnownRoutes(app, jwt)
+	handlers.RegisterUserRoutes(app, uploads, db, config, email, sms, storage)
+	handlers.RegisterRoleRoutes(app, db)
+	handlers.RegisterAdminRoutes(app, db, config, jwt, guard)
+	handlers.RegisterCategoryRoutes(app, db)
+	handlers.RegisterProductRoutes(app, uploads, db, config, storage)
+	handlers.RegisterOrderRoutes(app, db, config)
+	if payments != nil {
+		handlers.RegisterPaymentRoutes(app, db, payments)
+		handlers.StartRefundRetry(db, payments, time.Minute)
+	}
 	handlers.RegisterCartRoute(app, db)
 
+	handlers.StartReservationExpiry(db, time.Minute)
+	middleware.StartIdempotencyExpiry(db, time.Hour)
+
+	go func() {
+		if err := uploads.Listen(":" + config.UploadPort); err != nil {
+			log.Fatalf("Error starting upload server: %v", err)
+		}
+	}()
+
 	app.Listen(":" + config.AppPort)
 	defer app.Shutdown()
 }
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"errors"
+	"fusion/app/database/models"
+	"fusion/app/utils"
+	"gorm.io/gorm"
+	"time"
+)
+
+type postgresAttemptStore struct {
+	db *gorm.DB
+}
+
+// NewPostgresAttemptStore создает хранилище счетчиков попыток входа в Postgres,
+// общее для всех экземпляров приложения
+func NewPostgresAttemptStore(db *gorm.DB) utils.AttemptStore {
+	return &postgresAttemptStore{db: db}
+}
+
+func (s *postgresAttemptStore) Get(key string) (utils.Attempts, error) {
+	var attempt models.LoginAttempt
+	if err := s.db.Where("key = ?", key).First(&attempt).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return utils.Attempts{}, nil
+		}
+		return utils.Attempts{}, err
+	}
+
+	return toAttempts(attempt), nil
+}
+
+func (s *postgresAttemptStore) Increment(key string, window time.Duration) (utils.Attempts, error) {
+	now := time.Now()
+
+	var attempt models.LoginAttempt
+	err := s.db.Raw(`
+		INSERT INTO login_attempts (key, failures, last_failure_at)
+		VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_attempts.last_failure_at < ? THEN 1
+				ELSE login_attempts.failures + 1
+			END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING key, failures, last_failure_at, locked_until`,
+		key, now, now.Add(-window),
+	).Scan(&attempt).Error
+	if err != nil {
+		return utils.Attempts{}, err
+	}
+
+	return toAttempts(attempt), nil
+}
+
+func (s *postgresAttemptStore) Lock(key string, until time.Time) error {
+	return s.db.Model(&models.LoginAttempt{}).Where("key = ?", key).Update("locked_until", until).Error
+}
+
+func (s *postgresAttemptStore) Reset(key string) error {
+	return s.db.Where("key = ?", key).Delete(&models.LoginAttempt{}).Error
+}
+
+func toAttempts(attempt models.LoginAttempt) utils.Attempts {
+	attempts := utils.Attempts{
+		Failures:      attempt.Failures,
+		LastFailureAt: attempt.LastFailureAt,
+	}
+
+	if attempt.LockedUntil != nil {
+		attempts.LockedUntil = *attempt.LockedUntil
+	}
+
+	return attempts
+}
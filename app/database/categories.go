@@ -0,0 +1,62 @@
+package database
+
+import (
+	"fusion/app/database/models"
+	"gorm.io/gorm"
+)
+
+// Раньше каждое обновление товара создавало новую категорию с тем же названием. Категории без slug
+// с одинаковым названием объединяются в самую раннюю: ее получают все товары дубликатов.
+const legacyCategoryDuplicates = `
+	SELECT id, first_value(id) OVER (PARTITION BY lower(name) ORDER BY created_at, id) AS keep
+	FROM categories
+	WHERE deleted_at IS NULL AND parent_id IS NULL AND (slug IS NULL OR slug = '')`
+
+var categoryMigrations = []string{
+	`WITH duplicates AS (` + legacyCategoryDuplicates + `)
+	INSERT INTO product_category (product_id, category_id)
+	SELECT product_category.product_id, duplicates.keep
+	FROM product_category
+	JOIN duplicates ON duplicates.id = product_category.category_id AND duplicates.id <> duplicates.keep
+	ON CONFLICT DO NOTHING`,
+
+	`WITH duplicates AS (` + legacyCategoryDuplicates + `)
+	DELETE FROM product_category
+	USING duplicates
+	WHERE product_category.category_id = duplicates.id AND duplicates.id <> duplicates.keep`,
+
+	`WITH duplicates AS (` + legacyCategoryDuplicates + `)
+	UPDATE categories SET deleted_at = now()
+	FROM duplicates
+	WHERE categories.id = duplicates.id AND duplicates.id <> duplicates.keep`,
+}
+
+// migrateCategories объединяет дубликаты категорий, заполняет недостающие slug и создает
+// уникальный индекс по slug среди неудаленных категорий
+func migrateCategories(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range categoryMigrations {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		var categories []models.Category
+		if err := tx.Where("slug IS NULL OR slug = ''").Order("created_at, id").Find(&categories).Error; err != nil {
+			return err
+		}
+
+		for _, category := range categories {
+			slug, err := models.UniqueCategorySlug(tx, category.Name)
+			if err != nil {
+				return err
+			}
+
+			if err := tx.Model(&category).Update("slug", slug).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_slug ON categories (slug) WHERE deleted_at IS NULL`).Error
+	})
+}
@@ -12,6 +12,38 @@ import (
 	"time"
 )
 
+// schemaModels модели, таблицы которых создает AutoMigrate при запуске
+var schemaModels = []interface{}{
+	&models.User{},
+	&models.Role{},
+	&models.Permission{},
+	&models.Session{},
+	&models.Verification{},
+	&models.RecoveryCode{},
+	&models.PasswordHistory{},
+	&models.ApiKey{},
+	&models.ImpersonationLog{},
+	&models.ExternalIdentity{},
+	&models.OAuthState{},
+	&models.LoginAttempt{},
+	&models.Product{},
+	&models.ProductImage{},
+	&models.Category{},
+	&models.OptionType{},
+	&models.OptionValue{},
+	&models.Variant{},
+	&models.StockMovement{},
+	&models.Review{},
+	&models.Favourite{},
+	&models.Cart{},
+	&models.Order{},
+	&models.OrderProduct{},
+	&models.OrderEvent{},
+	&models.Payment{},
+	&models.PaymentWebhookEvent{},
+	&models.IdempotencyKey{},
+}
+
 func ConnectDB(config utils.AppConfig) (*gorm.DB, error) {
 	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s",
 		config.DatabaseHost, config.DatabasePort, config.DatabaseUser, config.DatabasePassword, config.DatabaseName)
@@ -60,21 +92,37 @@ func ConnectDB(config utils.AppConfig) (*gorm.DB, error) {
 		return nil, fmt.Errorf("failed to create extension uuid-ossp: %w", err)
 	}
 
-	if err := db.AutoMigrate(
-		&models.User{},
-		&models.Permissions{},
-		&models.Session{},
-		&models.Verification{},
-		&models.Product{},
-		&models.Category{},
-		&models.Review{},
-		&models.Favourite{},
-		&models.Cart{},
-		&models.Order{},
-		&models.OrderProduct{},
-	); err != nil {
+	if err := db.AutoMigrate(schemaModels...); err != nil {
 		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
 
+	if err := migrateCategories(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate categories: %w", err)
+	}
+
+	if err := migrateVariants(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate variants: %w", err)
+	}
+
+	if err := migrateInventory(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate inventory: %w", err)
+	}
+
+	if err := migrateOrderSnapshots(db, config.Currency); err != nil {
+		return nil, fmt.Errorf("failed to migrate order snapshots: %w", err)
+	}
+
+	if err := migrateProductSearch(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate product search: %w", err)
+	}
+
+	if err := migrateListings(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate listings: %w", err)
+	}
+
+	if err := seedRoles(db, utils.SplitList(config.AdminEmails)); err != nil {
+		return nil, fmt.Errorf("failed to seed roles: %w", err)
+	}
+
 	return db, nil
 }
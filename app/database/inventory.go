@@ -0,0 +1,120 @@
+package database
+
+import "gorm.io/gorm"
+
+// Остатки товаров и вариантов выводятся из складского журнала stock_movements: триггер применяет каждое
+// новое движение к products.stock и variants.stock, движение по варианту меняет и остаток товара.
+// Запись в журнал и проверка остатка выполняются под блокировкой строки товара.
+var inventoryMigrations = []string{
+	`CREATE OR REPLACE FUNCTION stock_effect(kind varchar, quantity integer) RETURNS integer AS $$
+		SELECT CASE kind
+			WHEN 'receipt' THEN quantity
+			WHEN 'adjustment' THEN quantity
+			WHEN 'release' THEN quantity
+			WHEN 'reservation' THEN -quantity
+			ELSE 0
+		END
+	$$ LANGUAGE sql IMMUTABLE`,
+
+	`CREATE OR REPLACE FUNCTION stock_movement_applied() RETURNS trigger AS $$
+	DECLARE
+		delta integer := stock_effect(NEW.kind, NEW.quantity);
+	BEGIN
+		IF delta <> 0 THEN
+			IF NEW.variant_id IS NOT NULL THEN
+				UPDATE variants SET stock = stock + delta WHERE id = NEW.variant_id;
+			END IF;
+			UPDATE products SET stock = stock + delta WHERE id = NEW.product_id;
+		END IF;
+
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+
+	`CREATE OR REPLACE FUNCTION stock_movement_immutable() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'stock_movements is append-only';
+	END
+	$$ LANGUAGE plpgsql`,
+
+	`DROP TRIGGER IF EXISTS trg_stock_movement_applied ON stock_movements`,
+
+	// Остатки, заданные до появления журнала, записываются в него начальными корректировками.
+	// Триггер в этот момент снят, поэтому сами остатки не меняются.
+	`INSERT INTO stock_movements (product_id, variant_id, kind, quantity, reason, created_at)
+		SELECT variants.product_id, variants.id, 'adjustment', variants.stock, 'opening balance', now()
+		FROM variants
+		WHERE variants.stock <> 0
+			AND NOT EXISTS (SELECT 1 FROM stock_movements WHERE stock_movements.variant_id = variants.id)`,
+	`INSERT INTO stock_movements (product_id, kind, quantity, reason, created_at)
+		SELECT products.id, 'adjustment', products.stock, 'opening balance', now()
+		FROM products
+		WHERE products.stock <> 0
+			AND NOT EXISTS (SELECT 1 FROM variants WHERE variants.product_id = products.id AND variants.deleted_at IS NULL)
+			AND NOT EXISTS (SELECT 1 FROM stock_movements WHERE stock_movements.product_id = products.id)`,
+
+	`CREATE TRIGGER trg_stock_movement_applied
+		AFTER INSERT ON stock_movements
+		FOR EACH ROW EXECUTE FUNCTION stock_movement_applied()`,
+
+	`DROP TRIGGER IF EXISTS trg_stock_movement_immutable ON stock_movements`,
+	`CREATE TRIGGER trg_stock_movement_immutable
+		BEFORE UPDATE OR DELETE ON stock_movements
+		FOR EACH ROW EXECUTE FUNCTION stock_movement_immutable()`,
+
+	`CREATE INDEX IF NOT EXISTS idx_stock_movements_product_created_at_id ON stock_movements (product_id, created_at, id)`,
+}
+
+// migrateInventory создает триггеры складского журнала и переносит в него прежние остатки
+func migrateInventory(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range inventoryMigrations {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// ReconcileStock пересчитывает остатки товаров и вариантов по складскому журналу и возвращает
+// число исправленных строк. Запускается вручную (server -reconcile-stock), если остатки разошлись
+// с журналом, например после правки таблиц в обход приложения. На время сверки новые записи
+// в журнал ждут ее завершения.
+func ReconcileStock(db *gorm.DB) (int64, error) {
+	var corrected int64
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`LOCK TABLE stock_movements IN SHARE MODE`).Error; err != nil {
+			return err
+		}
+
+		variants := tx.Exec(`UPDATE variants SET stock = balance.stock
+			FROM (
+				SELECT variants.id, coalesce(sum(stock_effect(stock_movements.kind, stock_movements.quantity)), 0) AS stock
+				FROM variants LEFT JOIN stock_movements ON stock_movements.variant_id = variants.id
+				GROUP BY variants.id
+			) AS balance
+			WHERE variants.id = balance.id AND variants.stock <> balance.stock`)
+		if variants.Error != nil {
+			return variants.Error
+		}
+
+		products := tx.Exec(`UPDATE products SET stock = balance.stock
+			FROM (
+				SELECT products.id, coalesce(sum(stock_effect(stock_movements.kind, stock_movements.quantity)), 0) AS stock
+				FROM products LEFT JOIN stock_movements ON stock_movements.product_id = products.id
+				GROUP BY products.id
+			) AS balance
+			WHERE products.id = balance.id AND products.stock <> balance.stock`)
+		if products.Error != nil {
+			return products.Error
+		}
+
+		corrected = variants.RowsAffected + products.RowsAffected
+		return nil
+	})
+
+	return corrected, err
+}
@@ -0,0 +1,79 @@
+package database
+
+import "gorm.io/gorm"
+
+// Списки товаров, заказов и отзывов листаются курсором по паре (столбец сортировки, id).
+// Рейтинг и популярность товара хранятся в products и пересчитываются триггерами при изменении
+// отзывов и избранного, чтобы по ним можно было фильтровать и сортировать через индекс.
+var listingMigrations = []string{
+	`CREATE OR REPLACE FUNCTION refresh_product_stats(target uuid) RETURNS void AS $$
+		UPDATE products SET
+			rating = coalesce(stats.rating, 0),
+			reviews_count = stats.reviews_count,
+			favourites_count = (SELECT count(*) FROM favourites WHERE favourites.product_id = target)
+		FROM (
+			SELECT avg(reviews.rating) AS rating, count(*) AS reviews_count
+			FROM reviews
+			WHERE reviews.product_id = target AND reviews.deleted_at IS NULL
+		) AS stats
+		WHERE products.id = target
+	$$ LANGUAGE sql`,
+
+	`CREATE OR REPLACE FUNCTION product_stats_changed() RETURNS trigger AS $$
+	BEGIN
+		IF TG_OP = 'INSERT' THEN
+			PERFORM refresh_product_stats(NEW.product_id);
+		ELSIF TG_OP = 'DELETE' THEN
+			PERFORM refresh_product_stats(OLD.product_id);
+		ELSE
+			PERFORM refresh_product_stats(NEW.product_id);
+			IF NEW.product_id <> OLD.product_id THEN
+				PERFORM refresh_product_stats(OLD.product_id);
+			END IF;
+		END IF;
+
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+
+	`DROP TRIGGER IF EXISTS trg_review_product_stats ON reviews`,
+	`CREATE TRIGGER trg_review_product_stats
+		AFTER INSERT OR UPDATE OR DELETE ON reviews
+		FOR EACH ROW EXECUTE FUNCTION product_stats_changed()`,
+
+	`DROP TRIGGER IF EXISTS trg_favourite_product_stats ON favourites`,
+	`CREATE TRIGGER trg_favourite_product_stats
+		AFTER INSERT OR UPDATE OR DELETE ON favourites
+		FOR EACH ROW EXECUTE FUNCTION product_stats_changed()`,
+
+	// Товары, созданные до появления триггеров
+	`SELECT refresh_product_stats(products.id)
+		FROM products
+		WHERE products.reviews_count = 0 AND products.favourites_count = 0
+			AND (EXISTS (SELECT 1 FROM reviews WHERE reviews.product_id = products.id AND reviews.deleted_at IS NULL)
+				OR EXISTS (SELECT 1 FROM favourites WHERE favourites.product_id = products.id))`,
+
+	// Заказы, созданные до появления created_at
+	`UPDATE orders SET created_at = now() WHERE created_at IS NULL`,
+
+	`CREATE INDEX IF NOT EXISTS idx_products_price_id ON products (price, id)`,
+	`CREATE INDEX IF NOT EXISTS idx_products_created_at_id ON products (created_at, id)`,
+	`CREATE INDEX IF NOT EXISTS idx_products_rating_id ON products (rating, id)`,
+	`CREATE INDEX IF NOT EXISTS idx_products_favourites_count_id ON products (favourites_count, id)`,
+	`CREATE INDEX IF NOT EXISTS idx_orders_user_created_at_id ON orders (user_id, created_at, id)`,
+	`CREATE INDEX IF NOT EXISTS idx_orders_created_at_id ON orders (created_at, id)`,
+	`CREATE INDEX IF NOT EXISTS idx_reviews_product_created_at_id ON reviews (product_id, created_at, id)`,
+}
+
+// migrateListings создает триггеры статистики товаров и индексы для постраничных списков
+func migrateListings(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range listingMigrations {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// ApiKey долгоживущий ключ доступа для интеграций. Хранится только хеш ключа;
+// Prefix показывается в списке ключей, чтобы их можно было различать.
+type ApiKey struct {
+	ID          uuid.UUID    `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID      uuid.UUID    `gorm:"type:uuid;index;not null"`
+	CreatedByID uuid.UUID    `gorm:"type:uuid;not null"`
+	Name        string       `gorm:"type:varchar(64);not null"`
+	Prefix      string       `gorm:"type:varchar(16);not null"`
+	KeyHash     string       `gorm:"uniqueIndex;not null"`
+	Permissions []Permission `gorm:"many2many:api_key_permissions"`
+
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+
+	User User `gorm:"foreignKey:UserID"`
+}
+
+// Scopes возвращает имена разрешений, которыми ограничен ключ
+func (k *ApiKey) Scopes() []string {
+	scopes := make([]string, len(k.Permissions))
+	for i, permission := range k.Permissions {
+		scopes[i] = permission.Name
+	}
+
+	return scopes
+}
@@ -20,6 +20,8 @@ type CartProduct struct {
 	CartID    uuid.UUID `gorm:"type:uuid;primaryKey"`
 	ProductID uuid.UUID `gorm:"type:uuid;primaryKey"`
 	Product   Product
+	VariantID *uuid.UUID `gorm:"type:uuid;index"`
+	Variant   *Variant
 	Quantity  int `gorm:"not null,default:1"`
 
 	CreatedAt time.Time
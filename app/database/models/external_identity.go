@@ -0,0 +1,32 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// ExternalIdentity связывает пользователя с учетной записью внешнего провайдера
+type ExternalIdentity struct {
+	ID       uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID   uuid.UUID `gorm:"type:uuid;index;not null"`
+	Provider string    `gorm:"type:varchar(50);uniqueIndex:idx_provider_subject;not null"`
+	Subject  string    `gorm:"uniqueIndex:idx_provider_subject;not null"`
+	Email    string
+	User     User
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// OAuthState хранит параметры незавершенного входа через внешнего провайдера
+type OAuthState struct {
+	ID           uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	State        string    `gorm:"uniqueIndex;not null"`
+	Provider     string    `gorm:"type:varchar(50);not null"`
+	Nonce        string    `gorm:"not null"`
+	CodeVerifier string    `gorm:"not null"`
+	RedirectURL  string    `gorm:"not null"`
+
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// IdempotencyKey результат запроса, выполненного с заголовком Idempotency-Key. Fingerprint — хеш метода,
+// адреса и тела запроса; StatusCode равен 0, пока запрос выполняется.
+type IdempotencyKey struct {
+	ID          uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID      uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_idempotency_keys_user_key"`
+	Key         string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_idempotency_keys_user_key"`
+	Fingerprint string    `gorm:"type:varchar(64);not null"`
+	StatusCode  int       `gorm:"not null;default:0"`
+	ContentType string    `gorm:"type:varchar(255);not null;default:''"`
+	Body        []byte
+	ExpiresAt   time.Time `gorm:"not null;index"`
+
+	CreatedAt time.Time
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// ImpersonationLog запись о выдаче токена поддержки или о запросе, выполненном от имени пользователя
+type ImpersonationLog struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	ActorID   uuid.UUID `gorm:"type:uuid;index;not null"`
+	UserID    uuid.UUID `gorm:"type:uuid;index;not null"`
+	SessionID uuid.UUID `gorm:"type:uuid"`
+	Method    string    `gorm:"type:varchar(10);not null"`
+	Path      string    `gorm:"not null"`
+	Status    int
+	IP        string
+	Reason    string
+
+	CreatedAt time.Time `gorm:"index"`
+
+	Actor User `gorm:"foreignKey:ActorID"`
+	User  User `gorm:"foreignKey:UserID"`
+}
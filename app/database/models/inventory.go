@@ -0,0 +1,39 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// StockMovementKind вид движения по складскому журналу
+type StockMovementKind string
+
+const (
+	// StockReceipt поступление товара
+	StockReceipt StockMovementKind = "receipt"
+	// StockReservation резерв под оформленный заказ
+	StockReservation StockMovementKind = "reservation"
+	// StockSale продажа зарезервированного товара после оплаты
+	StockSale StockMovementKind = "sale"
+	// StockRelease возврат резерва неоплаченного или отмененного заказа
+	StockRelease StockMovementKind = "release"
+	// StockAdjustment ручная корректировка остатка, в том числе отрицательная
+	StockAdjustment StockMovementKind = "adjustment"
+)
+
+// StockMovement запись складского журнала. Журнал только дополняется, остатки products.stock и variants.stock
+// пересчитываются из него триггером базы. Quantity — число единиц; поступление, корректировка и возврат резерва
+// увеличивают доступный остаток, резерв уменьшает, продажа лишь закрывает резерв. ActorID пуст
+// у движений, которые выполнила система, например при истечении резерва.
+type StockMovement struct {
+	ID        uuid.UUID         `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	ProductID uuid.UUID         `gorm:"type:uuid;not null;index"`
+	VariantID *uuid.UUID        `gorm:"type:uuid;index"`
+	OrderID   *uuid.UUID        `gorm:"type:uuid;index"`
+	Kind      StockMovementKind `gorm:"type:varchar(20);not null"`
+	Quantity  int               `gorm:"not null"`
+	Reason    string            `gorm:"type:varchar(255);not null"`
+	ActorID   *uuid.UUID        `gorm:"type:uuid"`
+
+	CreatedAt time.Time
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// LoginAttempt счетчик неудачных попыток входа по ключу учетной записи или адреса
+type LoginAttempt struct {
+	Key           string `gorm:"primaryKey"`
+	Failures      int    `gorm:"not null;default:0"`
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
@@ -15,24 +15,142 @@ const (
 	SENT
 	DELIVERED
 	ACCEPTED
+	CANCELLED
+	REFUNDED
 )
 
+var orderStatusNames = map[OrderStatus]string{
+	CREATED:   "created",
+	STAGING:   "staging",
+	BILLED:    "billed",
+	SENT:      "sent",
+	DELIVERED: "delivered",
+	ACCEPTED:  "accepted",
+	CANCELLED: "cancelled",
+	REFUNDED:  "refunded",
+}
+
+// String возвращает название статуса для сообщений и ответов API
+func (s OrderStatus) String() string {
+	if name, ok := orderStatusNames[s]; ok {
+		return name
+	}
+
+	return "unknown"
+}
+
+// OrderActor сторона, которая меняет статус заказа: покупатель, сотрудник с разрешением orders:manage
+// или сама система (истечение резерва, подтверждение оплаты)
+type OrderActor string
+
+const (
+	OrderActorCustomer OrderActor = "customer"
+	OrderActorStaff    OrderActor = "staff"
+	OrderActorSystem   OrderActor = "system"
+)
+
+// OrderTransition допустимый переход статуса заказа и сторона, которой он разрешен
+type OrderTransition struct {
+	From  OrderStatus
+	To    OrderStatus
+	Actor OrderActor
+}
+
+// OrderTransitions таблица переходов статуса заказа. Заказ проходит CREATED → STAGING → BILLED → SENT →
+// DELIVERED → ACCEPTED; до оплаты его можно отменить, после оплаты — вернуть деньги. Оплаченным
+// и возвращенным заказ становится только по вебхуку провайдера, подтвердившему списание или возврат.
+var OrderTransitions = []OrderTransition{
+	{From: CREATED, To: STAGING, Actor: OrderActorCustomer},
+	{From: CREATED, To: CANCELLED, Actor: OrderActorCustomer},
+	{From: CREATED, To: CANCELLED, Actor: OrderActorStaff},
+	{From: CREATED, To: CANCELLED, Actor: OrderActorSystem},
+
+	{From: STAGING, To: BILLED, Actor: OrderActorSystem},
+	{From: STAGING, To: CANCELLED, Actor: OrderActorCustomer},
+	{From: STAGING, To: CANCELLED, Actor: OrderActorStaff},
+	{From: STAGING, To: CANCELLED, Actor: OrderActorSystem},
+
+	{From: BILLED, To: SENT, Actor: OrderActorStaff},
+	{From: BILLED, To: REFUNDED, Actor: OrderActorSystem},
+
+	{From: SENT, To: DELIVERED, Actor: OrderActorStaff},
+	{From: SENT, To: REFUNDED, Actor: OrderActorSystem},
+
+	{From: DELIVERED, To: ACCEPTED, Actor: OrderActorCustomer},
+	{From: DELIVERED, To: ACCEPTED, Actor: OrderActorStaff},
+	{From: DELIVERED, To: REFUNDED, Actor: OrderActorSystem},
+}
+
+// OrderTransitionAllowed сообщает, существует ли переход from → to и разрешен ли он стороне actor
+func OrderTransitionAllowed(from, to OrderStatus, actor OrderActor) (exists bool, allowed bool) {
+	for _, transition := range OrderTransitions {
+		if transition.From != from || transition.To != to {
+			continue
+		}
+
+		exists = true
+		if transition.Actor == actor {
+			return true, true
+		}
+	}
+
+	return exists, false
+}
+
 type Order struct {
 	ID     uuid.UUID   `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
 	UserID uuid.UUID   `gorm:"type:uuid;not null"`
 	Status OrderStatus `gorm:"type:int;default:0"`
 
+	// ReservedUntil срок резерва товаров неоплаченного заказа; пусто, когда резерв продан или возвращен
+	ReservedUntil *time.Time `gorm:"index"`
+
+	// Итоги заказа, рассчитанные при оформлении: GrandTotal = Subtotal - Discount + Tax + Shipping
+	Currency   string  `gorm:"type:varchar(3);not null;default:''"`
+	Subtotal   float64 `gorm:"type:decimal(12,2);not null;default:0"`
+	Discount   float64 `gorm:"type:decimal(12,2);not null;default:0"`
+	Tax        float64 `gorm:"type:decimal(12,2);not null;default:0"`
+	Shipping   float64 `gorm:"type:decimal(12,2);not null;default:0"`
+	GrandTotal float64 `gorm:"type:decimal(12,2);not null;default:0"`
+
 	User     User
-	Products []CartProduct `gorm:"many2many:order_products;"`
+	Products []OrderProduct
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
 }
 
+// OrderEvent запись истории заказа о смене статуса. ActorID пуст, если статус изменила система.
+type OrderEvent struct {
+	ID         uuid.UUID   `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	OrderID    uuid.UUID   `gorm:"type:uuid;not null;index"`
+	FromStatus OrderStatus `gorm:"type:int;not null"`
+	ToStatus   OrderStatus `gorm:"type:int;not null"`
+	Actor      OrderActor  `gorm:"type:varchar(20);not null"`
+	ActorID    *uuid.UUID  `gorm:"type:uuid"`
+	Note       string      `gorm:"type:varchar(500)"`
+
+	CreatedAt time.Time
+}
+
+// OrderProduct строка заказа. Цена, название, артикул и изображение копируются при оформлении,
+// поэтому изменение или удаление товара не меняет историю заказов.
 type OrderProduct struct {
 	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
 	OrderID   uuid.UUID `gorm:"type:uuid;primaryKey"`
 	ProductID uuid.UUID `gorm:"type:uuid;primaryKey"`
 	Product   Product
+	VariantID *uuid.UUID `gorm:"type:uuid;index"`
+	Variant   *Variant
 	Quantity  int `gorm:"not null,default:1"`
 
+	UnitPrice   float64 `gorm:"type:decimal(10,2);not null;default:0"`
+	Total       float64 `gorm:"type:decimal(12,2);not null;default:0"`
+	Currency    string  `gorm:"type:varchar(3);not null;default:''"`
+	ProductName string  `gorm:"type:varchar(255);not null;default:''"`
+	SKU         string  `gorm:"type:varchar(64);not null;default:''"`
+	Image       *string
+
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
@@ -0,0 +1,43 @@
+package models
+
+import "testing"
+
+func TestOrderTransitionAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		from    OrderStatus
+		to      OrderStatus
+		actor   OrderActor
+		exists  bool
+		allowed bool
+	}{
+		{"customer stages order", CREATED, STAGING, OrderActorCustomer, true, true},
+		{"staff cannot stage order", CREATED, STAGING, OrderActorStaff, true, false},
+		{"customer cancels before payment", STAGING, CANCELLED, OrderActorCustomer, true, true},
+		{"system cancels expired reservation", CREATED, CANCELLED, OrderActorSystem, true, true},
+		{"only webhook bills order", STAGING, BILLED, OrderActorSystem, true, true},
+		{"customer cannot bill order", STAGING, BILLED, OrderActorCustomer, true, false},
+		{"staff cannot bill order", STAGING, BILLED, OrderActorStaff, true, false},
+		{"staff sends paid order", BILLED, SENT, OrderActorStaff, true, true},
+		{"customer cannot cancel paid order", BILLED, CANCELLED, OrderActorCustomer, false, false},
+		{"staff cannot refund", BILLED, REFUNDED, OrderActorStaff, true, false},
+		{"webhook refunds paid order", BILLED, REFUNDED, OrderActorSystem, true, true},
+		{"webhook refunds sent order", SENT, REFUNDED, OrderActorSystem, true, true},
+		{"webhook refunds delivered order", DELIVERED, REFUNDED, OrderActorSystem, true, true},
+		{"staff delivers order", SENT, DELIVERED, OrderActorStaff, true, true},
+		{"customer accepts delivered order", DELIVERED, ACCEPTED, OrderActorCustomer, true, true},
+		{"accepted order is final", ACCEPTED, REFUNDED, OrderActorSystem, false, false},
+		{"cancelled order is final", CANCELLED, CREATED, OrderActorStaff, false, false},
+		{"no skipping states", CREATED, SENT, OrderActorStaff, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exists, allowed := OrderTransitionAllowed(tt.from, tt.to, tt.actor)
+			if exists != tt.exists || allowed != tt.allowed {
+				t.Errorf("OrderTransitionAllowed(%v, %v, %v) = %v, %v, want %v, %v",
+					tt.from, tt.to, tt.actor, exists, allowed, tt.exists, tt.allowed)
+			}
+		})
+	}
+}
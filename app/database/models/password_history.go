@@ -0,0 +1,15 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// PasswordHistory хеш одного из предыдущих паролей пользователя
+type PasswordHistory struct {
+	ID     uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID uuid.UUID `gorm:"type:uuid;index;not null"`
+	Hash   string    `gorm:"not null"`
+
+	CreatedAt time.Time
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// PaymentStatus состояние платежа; меняется только по вебхукам провайдера
+type PaymentStatus string
+
+const (
+	// PaymentPending платеж создан у провайдера и ожидает списания
+	PaymentPending PaymentStatus = "pending"
+	// PaymentCaptured провайдер подтвердил списание
+	PaymentCaptured PaymentStatus = "captured"
+	// PaymentFailed провайдер отклонил платеж
+	PaymentFailed PaymentStatus = "failed"
+	// PaymentRefunded провайдер подтвердил возврат
+	PaymentRefunded PaymentStatus = "refunded"
+)
+
+// Payment платеж за заказ. Reference — идентификатор платежа у провайдера Provider, по нему
+// вебхуки находят платеж, ClientSecret передается покупателю для подтверждения оплаты.
+// Amount и Currency копируются из итогов заказа при создании, Refunded — сумма подтвержденных возвратов.
+// RefundPending отмечает списание, которое нужно вернуть, пока провайдер не принял запрос возврата.
+type Payment struct {
+	ID            uuid.UUID     `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	OrderID       uuid.UUID     `gorm:"type:uuid;not null;index"`
+	Provider      string        `gorm:"type:varchar(32);not null;uniqueIndex:idx_payments_provider_reference"`
+	Reference     string        `gorm:"type:varchar(255);not null;uniqueIndex:idx_payments_provider_reference"`
+	ClientSecret  string        `gorm:"type:varchar(255);not null"`
+	Status        PaymentStatus `gorm:"type:varchar(20);not null;default:'pending'"`
+	Amount        float64       `gorm:"type:decimal(12,2);not null"`
+	Refunded      float64       `gorm:"type:decimal(12,2);not null;default:0"`
+	Currency      string        `gorm:"type:varchar(3);not null"`
+	RefundPending bool          `gorm:"not null;default:false;index"`
+
+	Order Order
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// PaymentWebhookEvent обработанное событие провайдера. Уникальность EventID у провайдера не дает
+// применить повторно доставленное событие дважды.
+type PaymentWebhookEvent struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	Provider  string    `gorm:"type:varchar(32);not null;uniqueIndex:idx_payment_webhook_events_provider_event"`
+	EventID   string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_payment_webhook_events_provider_event"`
+	PaymentID uuid.UUID `gorm:"type:uuid;not null;index"`
+	Type      string    `gorm:"type:varchar(64);not null"`
+
+	CreatedAt time.Time
+}
@@ -1,10 +1,86 @@
 package models
 
-import "github.com/google/uuid"
+import (
+	"regexp"
+	"strings"
+)
 
-type Permissions struct {
-	ID     uint      `gorm:"primaryKey"`
-	Name   string    `gorm:"uniqueIndex;not null"`
-	UserID uuid.UUID `gorm:"type:uuid"`
-	User   User
+// Разрешения, которые проверяют маршруты приложения
+const (
+	PermissionAll              = "*"
+	PermissionRolesManage      = "roles:manage"
+	PermissionUsersRead        = "users:read"
+	PermissionUsersBan         = "users:ban"
+	PermissionUsersVerify      = "users:verify"
+	PermissionUsersUnlock      = "users:unlock"
+	PermissionProductsModerate = "products:moderate"
+	PermissionCategoriesManage = "categories:manage"
+	PermissionReviewsModerate  = "reviews:moderate"
+	PermissionOrdersRead       = "orders:read"
+	PermissionOrdersManage     = "orders:manage"
+	PermissionServiceAccounts  = "service_accounts:manage"
+	PermissionUsersImpersonate = "users:impersonate"
+	PermissionAuditRead        = "audit:read"
+)
+
+// Области API-ключа для маршрутов с собственными данными пользователя. Ролями они не выдаются:
+// любой пользователь может включить их в область своего ключа.
+const (
+	ScopeProducts = "self:products"
+	ScopeOrders   = "self:orders"
+	ScopeCart     = "self:cart"
+	ScopePayments = "self:payments"
+)
+
+// IsSelfScope проверяет, относится ли разрешение к собственным данным пользователя
+func IsSelfScope(name string) bool {
+	switch name {
+	case ScopeProducts, ScopeOrders, ScopeCart, ScopePayments:
+		return true
+	default:
+		return false
+	}
+}
+
+// AdminRole роль с полным доступом, создается при миграции
+const AdminRole = "admin"
+
+// Role именованный набор разрешений, назначаемый пользователям
+type Role struct {
+	ID          uint   `gorm:"primaryKey"`
+	Name        string `gorm:"uniqueIndex;not null"`
+	Description string
+	Permissions []Permission `gorm:"many2many:role_permissions"`
+	Users       []User       `gorm:"many2many:user_roles"`
+}
+
+// Permission разрешение вида "ресурс:действие"; сегмент "*" покрывает все последующие
+type Permission struct {
+	ID   uint   `gorm:"primaryKey"`
+	Name string `gorm:"uniqueIndex;not null"`
+}
+
+var permissionPattern = regexp.MustCompile(`^(\*|[a-z_]+)(:(\*|[a-z_]+))*$`)
+
+// IsValidPermission проверяет формат имени разрешения
+func IsValidPermission(name string) bool {
+	return permissionPattern.MatchString(name)
+}
+
+// MatchPermission проверяет, покрывает ли выданное разрешение granted требуемое required
+func MatchPermission(granted, required string) bool {
+	grantedParts := strings.Split(granted, ":")
+	requiredParts := strings.Split(required, ":")
+
+	for i, part := range grantedParts {
+		if part == "*" {
+			return true
+		}
+
+		if i >= len(requiredParts) || part != requiredParts[i] {
+			return false
+		}
+	}
+
+	return len(grantedParts) == len(requiredParts)
 }
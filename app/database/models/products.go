@@ -1,8 +1,11 @@
 package models
 
 import (
+	"fmt"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -12,28 +15,111 @@ type Product struct {
 	Name        string  `json:"name"`
 	Description string  `json:"description"`
 	Price       float64 `json:"price" gorm:"type:decimal(10,2)"`
-	Stock       int     `json:"stock"`
+	Stock       int     `json:"stock" gorm:"->;not null;default:0"`
 	Image       *string
+	Images      []ProductImage
+	IsHidden    bool       `json:"-" gorm:"default:false;index"`
 	Categories  []Category `gorm:"many2many:product_category;"`
 	Reviews     []Review
+	OptionTypes []OptionType
+	Variants    []Variant
 	User        User
 
+	// Rating, ReviewsCount и FavouritesCount пересчитываются триггерами базы, приложение их только читает
+	Rating          float64 `json:"-" gorm:"->;not null;default:0"`
+	ReviewsCount    int     `json:"-" gorm:"->;not null;default:0"`
+	FavouritesCount int     `json:"-" gorm:"->;not null;default:0"`
+
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
 
+// ProductImage изображение галереи товара. Key — ключ оригинала в хранилище, ключи остальных
+// размеров строятся из него; Position задает порядок в галерее, первое изображение — обложка.
+type ProductImage struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	ProductID uuid.UUID `gorm:"type:uuid;index;not null"`
+	Position  int       `gorm:"not null"`
+	Key       string    `gorm:"type:varchar(255);not null"`
+	CreatedAt time.Time
+}
+
+// Category узел дерева категорий; Slug уникален среди неудаленных категорий
 type Category struct {
-	ID          uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	ID          uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	ParentID    *uuid.UUID `gorm:"type:uuid;index"`
 	Name        string
+	Slug        string `gorm:"type:varchar(100)"`
 	Description string
-	Products    []Product `gorm:"many2many:product_category;"`
+	Parent      *Category
+	Children    []Category `gorm:"foreignKey:ParentID"`
+	Products    []Product  `gorm:"many2many:product_category;"`
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
 
+var (
+	slugSeparators = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+	slugPattern    = regexp.MustCompile(`^[\p{Ll}\p{Lo}\p{N}]+(-[\p{Ll}\p{Lo}\p{N}]+)*$`)
+)
+
+// Slugify строит slug из названия: буквы в нижнем регистре и цифры, разделенные дефисами
+func Slugify(name string) string {
+	slug := strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len([]rune(slug)) > 80 {
+		slug = strings.TrimRight(string([]rune(slug)[:80]), "-")
+	}
+	if slug == "" {
+		slug = "category"
+	}
+
+	return slug
+}
+
+// IsValidSlug проверяет формат slug, заданного вручную
+func IsValidSlug(slug string) bool {
+	return len([]rune(slug)) <= 100 && slugPattern.MatchString(slug)
+}
+
+// UniqueCategorySlug возвращает slug из названия, добавляя номер, если он уже занят
+func UniqueCategorySlug(tx *gorm.DB, name string) (string, error) {
+	base := Slugify(name)
+	slug := base
+
+	for i := 2; ; i++ {
+		var count int64
+		if err := tx.Session(&gorm.Session{NewDB: true}).
+			Model(&Category{}).
+			Where("slug = ?", slug).
+			Count(&count).
+			Error; err != nil {
+			return "", err
+		}
+
+		if count == 0 {
+			return slug, nil
+		}
+		slug = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+func (c *Category) BeforeCreate(tx *gorm.DB) error {
+	if c.Slug != "" {
+		return nil
+	}
+
+	slug, err := UniqueCategorySlug(tx, c.Name)
+	if err != nil {
+		return err
+	}
+	c.Slug = slug
+
+	return nil
+}
+
 type Review struct {
 	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
 	ProductID uuid.UUID `gorm:"type:uuid;index"`
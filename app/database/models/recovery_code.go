@@ -0,0 +1,16 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// RecoveryCode одноразовый код для входа без второго фактора
+type RecoveryCode struct {
+	ID     uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	UserID uuid.UUID `gorm:"type:uuid;index;not null"`
+	Hash   string    `gorm:"not null"`
+	UsedAt *time.Time
+
+	CreatedAt time.Time
+}
@@ -6,17 +6,20 @@ import (
 	"time"
 )
 
+// Session хранит refresh-токен. Сессии одной цепочки ротации объединены общим FamilyID.
 type Session struct {
 	ID       uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
 	UserID   uuid.UUID `gorm:"type:uuid;index;not null"`
+	FamilyID uuid.UUID `gorm:"type:uuid;index"`
 	Token    string
 	Agent    string
 	IP       string
 	IsActive bool
 
-	IssuedAt  time.Time `gorm:"default:now()"`
-	ExpiresAt time.Time
-	DeletedAt gorm.DeletedAt `gorm:"index"`
+	IssuedAt   time.Time `gorm:"default:now()"`
+	ExpiresAt  time.Time
+	ConsumedAt *time.Time
+	DeletedAt  gorm.DeletedAt `gorm:"index"`
 
 	User User `gorm:"foreignKey:UserID"`
 }
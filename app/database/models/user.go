@@ -13,14 +13,29 @@ import (
 type User struct {
 	ID              uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
 	Avatar          *string   `gorm:"type:varchar(255);"`
+	AvatarKey       *string   `gorm:"type:varchar(255);"`
 	Email           string    `gorm:"uniqueIndex;not null"`
 	Phone           *string
 	Password        string `gorm:"not null"`
 	Username        string `gorm:"uniqueIndex;not nul"`
 	IsEmailVerified bool   `gorm:"default:false"`
+	IsPhoneVerified bool   `gorm:"default:false"`
+	IsBanned        bool   `gorm:"default:false"`
+	BanReason       string
+	BannedAt        *time.Time
+
+	// IsServiceAccount учетная запись интеграции: входит только по API-ключам
+	IsServiceAccount bool `gorm:"default:false"`
+
+	TwoFactorSecret    *string
+	IsTwoFactorEnabled bool `gorm:"default:false"`
+	TwoFactorLastStep  int64
 
 	Verifications []Verification
-	Permissions   []Permissions `gorm:"many2many:user_permissions"`
+	RecoveryCodes []RecoveryCode
+	Identities    []ExternalIdentity
+	Roles         []Role `gorm:"many2many:user_roles"`
+	ApiKeys       []ApiKey
 	Products      []Product
 	Favourites    []Favourite
 	Sessions      []Session
@@ -30,12 +45,43 @@ type User struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	// Scopes разрешения API-ключа, которым выполнен запрос; nil при входе по токену
+	Scopes []string `gorm:"-" json:"-"`
+	// ApiKeyID ключ, которым выполнен запрос; nil при входе по токену
+	ApiKeyID *uuid.UUID `gorm:"-" json:"-"`
 }
 
-func (u *User) HasPermissions(permissions ...string) bool {
-	for _, requiredPermission := range permissions {
-		for _, permission := range u.Permissions {
-			if permission.Name == requiredPermission {
+// HasPermission проверяет, выдано ли пользователю разрешение через одну из его ролей.
+// Роли должны быть загружены вместе с разрешениями (Preload("Roles.Permissions")).
+// При запросе с API-ключом разрешение должно входить и в область ключа, а сервисной
+// учетной записи разрешения выдаются только областью ключа.
+func (u *User) HasPermission(required string) bool {
+	if u.Scopes != nil {
+		inScope := false
+		for _, scope := range u.Scopes {
+			if MatchPermission(scope, required) {
+				inScope = true
+				break
+			}
+		}
+
+		if !inScope {
+			return false
+		}
+
+		if u.IsServiceAccount {
+			return true
+		}
+	}
+
+	if u.IsServiceAccount {
+		return false
+	}
+
+	for _, role := range u.Roles {
+		for _, permission := range role.Permissions {
+			if MatchPermission(permission.Name, required) {
 				return true
 			}
 		}
@@ -44,6 +90,33 @@ func (u *User) HasPermissions(permissions ...string) bool {
 	return false
 }
 
+// HasAllPermissions проверяет, что пользователю выданы все перечисленные разрешения
+func (u *User) HasAllPermissions(permissions ...string) bool {
+	for _, permission := range permissions {
+		if !u.HasPermission(permission) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// HasAnyPermission проверяет, что пользователю выдано хотя бы одно из перечисленных разрешений
+func (u *User) HasAnyPermission(permissions ...string) bool {
+	for _, permission := range permissions {
+		if u.HasPermission(permission) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NormalizePhone приводит номер телефона к формату E.164, как при сохранении пользователя
+func NormalizePhone(phone string) (string, error) {
+	return formatPhoneNumber(phone)
+}
+
 func formatPhoneNumber(phone string) (string, error) {
 	re := regexp.MustCompile(`[^0-9+]`)
 	normalizedPhone := re.ReplaceAllString(phone, "")
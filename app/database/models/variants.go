@@ -0,0 +1,53 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// OptionType измерение, по которому различаются варианты товара, например размер или цвет
+type OptionType struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	ProductID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_option_types_product_name"`
+	Name      string    `gorm:"type:varchar(50);not null;uniqueIndex:idx_option_types_product_name"`
+	Values    []OptionValue
+
+	CreatedAt time.Time
+}
+
+// OptionValue значение измерения, например "M" для размера
+type OptionValue struct {
+	ID           uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	OptionTypeID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_option_values_type_value"`
+	Value        string    `gorm:"type:varchar(50);not null;uniqueIndex:idx_option_values_type_value"`
+
+	CreatedAt time.Time
+}
+
+// Variant вариант товара с собственным артикулом, остатком и изображением. Price заменяет цену
+// товара, если задана. OptionKey — набор значений в каноническом виде, уникальный в пределах товара.
+// Stock выводится из складского журнала, как и остаток товара.
+type Variant struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
+	ProductID uuid.UUID `gorm:"type:uuid;not null;index"`
+	SKU       string    `gorm:"type:varchar(64);not null"`
+	Price     *float64  `gorm:"type:decimal(10,2)"`
+	Stock     int       `gorm:"->;not null;default:0"`
+	Image     *string
+	OptionKey string        `gorm:"not null"`
+	Options   []OptionValue `gorm:"many2many:variant_option_values"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+// EffectivePrice возвращает цену варианта, а без собственной цены — цену товара
+func (v *Variant) EffectivePrice(product Product) float64 {
+	if v.Price != nil {
+		return *v.Price
+	}
+
+	return product.Price
+}
@@ -9,6 +9,10 @@ type Verification struct {
 	ID    uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primaryKey"`
 	Type  string    `gorm:"type:varchar(50);not null"`
 	Token string    `gorm:"uniqueIndex;not null"`
+	// Target адрес, к которому относится токен, например новый email при его смене
+	Target string `gorm:"type:varchar(255)"`
+	// Attempts число неверных попыток ввода кода
+	Attempts int `gorm:"default:0"`
 
 	UserID uuid.UUID `gorm:"type:uuid;not null"`
 	User   User
@@ -0,0 +1,58 @@
+package database
+
+import "gorm.io/gorm"
+
+// Строки заказов, оформленных до появления снимков, заполняются по текущим данным товаров и вариантов,
+// а итоги таких заказов — суммой строк без налога и доставки. Признак незаполненной записи — пустая валюта.
+var orderSnapshotMigrations = []string{
+	`UPDATE order_products SET
+		unit_price = coalesce((SELECT variants.price FROM variants WHERE variants.id = order_products.variant_id), products.price),
+		total = round(coalesce((SELECT variants.price FROM variants WHERE variants.id = order_products.variant_id), products.price) * order_products.quantity, 2),
+		product_name = products.name,
+		sku = coalesce((SELECT variants.sku FROM variants WHERE variants.id = order_products.variant_id), ''),
+		image = coalesce((SELECT variants.image FROM variants WHERE variants.id = order_products.variant_id), products.image),
+		currency = @currency
+	FROM products
+	WHERE products.id = order_products.product_id AND order_products.currency = ''`,
+
+	`UPDATE order_products SET currency = @currency WHERE currency = ''`,
+
+	`UPDATE orders SET
+		subtotal = totals.subtotal,
+		grand_total = totals.subtotal,
+		currency = @currency
+	FROM (
+		SELECT orders.id, coalesce(sum(order_products.total), 0) AS subtotal
+		FROM orders
+		LEFT JOIN order_products ON order_products.order_id = orders.id
+		WHERE orders.currency = ''
+		GROUP BY orders.id
+	) AS totals
+	WHERE orders.id = totals.id`,
+}
+
+// migrateOrderSnapshots заполняет снимки цен и итоги заказов, оформленных до их появления. Когда таких
+// заказов не осталось, запросы не выполняются, поэтому после первого переноса запуск не трогает заказы.
+func migrateOrderSnapshots(db *gorm.DB, currency string) error {
+	var pending bool
+	if err := db.Raw(`SELECT EXISTS (SELECT 1 FROM orders WHERE currency = '')
+		OR EXISTS (SELECT 1 FROM order_products WHERE currency = '')`).
+		Scan(&pending).
+		Error; err != nil {
+		return err
+	}
+
+	if !pending {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range orderSnapshotMigrations {
+			if err := tx.Exec(statement, map[string]interface{}{"currency": currency}).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,126 @@
+package database
+
+import (
+	"fusion/app/database/models"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"os"
+	"testing"
+)
+
+// testDB подключается к базе из TEST_DATABASE_DSN; без нее тест пропускается
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(`CREATE EXTENSION IF NOT EXISTS "uuid-ossp"`).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(schemaModels...); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestMigrateOrderSnapshots(t *testing.T) {
+	db := testDB(t)
+
+	suffix := uuid.NewString()[:8]
+	user := models.User{Username: "orders-" + suffix, Email: "orders-" + suffix + "@example.com", Password: "hash"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	product := models.Product{UserID: user.ID, Name: "Кружка", Price: 10}
+	if err := db.Create(&product).Error; err != nil {
+		t.Fatal(err)
+	}
+	variantPrice := 15.5
+	variant := models.Variant{ProductID: product.ID, SKU: "MUG-" + suffix, Price: &variantPrice, OptionKey: suffix}
+	if err := db.Create(&variant).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := models.Order{UserID: user.ID}
+	current := models.Order{UserID: user.ID, Currency: "EUR", Subtotal: 7, Tax: 1, GrandTotal: 8}
+	if err := db.Create(&[]*models.Order{&legacy, &current}).Error; err != nil {
+		t.Fatal(err)
+	}
+	lines := []models.OrderProduct{
+		{OrderID: legacy.ID, ProductID: product.ID, Quantity: 2},
+		{OrderID: legacy.ID, ProductID: product.ID, VariantID: &variant.ID, Quantity: 3},
+		{OrderID: current.ID, ProductID: product.ID, Quantity: 1, UnitPrice: 7, Total: 7, Currency: "EUR", ProductName: "Старое имя"},
+	}
+	if err := db.Create(&lines).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		db.Where("order_id IN ?", []uuid.UUID{legacy.ID, current.ID}).Delete(&models.OrderProduct{})
+		db.Where("id IN ?", []uuid.UUID{legacy.ID, current.ID}).Delete(&models.Order{})
+		db.Unscoped().Delete(&variant)
+		db.Unscoped().Delete(&product)
+		db.Unscoped().Delete(&user)
+	})
+
+	if err := migrateOrderSnapshots(db, "RUB"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Повторный запуск после смены цены не должен переписывать уже заполненные заказы
+	if err := db.Model(&product).Update("price", 99).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateOrderSnapshots(db, "RUB"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[uuid.UUID]models.OrderProduct{
+		lines[0].ID: {UnitPrice: 10, Total: 20, Currency: "RUB", ProductName: "Кружка", SKU: ""},
+		lines[1].ID: {UnitPrice: 15.5, Total: 46.5, Currency: "RUB", ProductName: "Кружка", SKU: variant.SKU},
+		lines[2].ID: {UnitPrice: 7, Total: 7, Currency: "EUR", ProductName: "Старое имя", SKU: ""},
+	}
+	for id, expected := range want {
+		var line models.OrderProduct
+		if err := db.First(&line, "id = ?", id).Error; err != nil {
+			t.Fatal(err)
+		}
+		if line.UnitPrice != expected.UnitPrice || line.Total != expected.Total || line.Currency != expected.Currency ||
+			line.ProductName != expected.ProductName || line.SKU != expected.SKU {
+			t.Errorf("line %s = {%v %v %q %q %q}, want {%v %v %q %q %q}", id,
+				line.UnitPrice, line.Total, line.Currency, line.ProductName, line.SKU,
+				expected.UnitPrice, expected.Total, expected.Currency, expected.ProductName, expected.SKU)
+		}
+	}
+
+	tests := []struct {
+		order      uuid.UUID
+		currency   string
+		subtotal   float64
+		tax        float64
+		grandTotal float64
+	}{
+		{legacy.ID, "RUB", 66.5, 0, 66.5},
+		{current.ID, "EUR", 7, 1, 8},
+	}
+	for _, tt := range tests {
+		var order models.Order
+		if err := db.First(&order, "id = ?", tt.order).Error; err != nil {
+			t.Fatal(err)
+		}
+		if order.Currency != tt.currency || order.Subtotal != tt.subtotal || order.Tax != tt.tax || order.GrandTotal != tt.grandTotal {
+			t.Errorf("order %s = {%q %v %v %v}, want {%q %v %v %v}", tt.order,
+				order.Currency, order.Subtotal, order.Tax, order.GrandTotal,
+				tt.currency, tt.subtotal, tt.tax, tt.grandTotal)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package database
+
+import "gorm.io/gorm"
+
+// Полнотекстовый поиск по товарам. Сгенерированный столбец не может ссылаться на другие таблицы,
+// поэтому названия категорий товара копируются в products.category_names триггерами, а search_vector
+// строится из названия, описания и этой копии. Оба столбца не входят в модель, чтобы GORM их не перезаписывал.
+var productSearchMigrations = []string{
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS category_names text NOT NULL DEFAULT ''`,
+
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', coalesce(name, '')), 'A') ||
+		setweight(to_tsvector('simple', coalesce(category_names, '')), 'B') ||
+		setweight(to_tsvector('simple', coalesce(description, '')), 'C')
+	) STORED`,
+
+	`CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector)`,
+
+	`CREATE OR REPLACE FUNCTION refresh_product_category_names(target uuid) RETURNS void AS $$
+		UPDATE products SET category_names = coalesce((
+			SELECT string_agg(categories.name, ' ')
+			FROM product_category
+			JOIN categories ON categories.id = product_category.category_id AND categories.deleted_at IS NULL
+			WHERE product_category.product_id = target
+		), '')
+		WHERE id = target
+	$$ LANGUAGE sql`,
+
+	`CREATE OR REPLACE FUNCTION product_category_changed() RETURNS trigger AS $$
+	BEGIN
+		IF TG_OP = 'DELETE' THEN
+			PERFORM refresh_product_category_names(OLD.product_id);
+			RETURN OLD;
+		END IF;
+
+		PERFORM refresh_product_category_names(NEW.product_id);
+		RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql`,
+
+	`CREATE OR REPLACE FUNCTION category_changed() RETURNS trigger AS $$
+	BEGIN
+		PERFORM refresh_product_category_names(product_category.product_id)
+		FROM product_category
+		WHERE product_category.category_id = NEW.id;
+		RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql`,
+
+	`DROP TRIGGER IF EXISTS trg_product_category_names ON product_category`,
+	`CREATE TRIGGER trg_product_category_names
+		AFTER INSERT OR DELETE ON product_category
+		FOR EACH ROW EXECUTE FUNCTION product_category_changed()`,
+
+	`DROP TRIGGER IF EXISTS trg_category_names ON categories`,
+	`CREATE TRIGGER trg_category_names
+		AFTER UPDATE OF name, deleted_at ON categories
+		FOR EACH ROW EXECUTE FUNCTION category_changed()`,
+}
+
+// productSearchBackfill заполняет названия категорий у товаров, созданных до появления триггеров
+const productSearchBackfill = `SELECT refresh_product_category_names(id) FROM products`
+
+// migrateProductSearch создает столбцы, индекс и триггеры полнотекстового поиска товаров.
+// При первом создании столбца category_names он заполняется для уже существующих товаров.
+func migrateProductSearch(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var installed bool
+		if err := tx.Raw(`SELECT EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = 'products' AND column_name = 'category_names'
+		)`).Scan(&installed).Error; err != nil {
+			return err
+		}
+
+		for _, statement := range productSearchMigrations {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		if installed {
+			return nil
+		}
+
+		return tx.Exec(productSearchBackfill).Error
+	})
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"fusion/app/database/models"
+	"gorm.io/gorm"
+)
+
+// seedRoles создает роль администратора и назначает ее пользователям из adminEmails с подтвержденным email
+func seedRoles(db *gorm.DB, adminEmails []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var permission models.Permission
+		if err := tx.Where(models.Permission{Name: models.PermissionAll}).FirstOrCreate(&permission).Error; err != nil {
+			return err
+		}
+
+		var role models.Role
+		if err := tx.
+			Where(models.Role{Name: models.AdminRole}).
+			Attrs(models.Role{Description: "Full access"}).
+			FirstOrCreate(&role).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&role).Association("Permissions").Append(&permission); err != nil {
+			return err
+		}
+
+		if len(adminEmails) == 0 {
+			return nil
+		}
+
+		var users []models.User
+		// Роль получает только владелец адреса: иначе администратором станет любой, кто первым
+		// зарегистрируется с этим email
+		if err := tx.Where("email IN ? AND is_email_verified = ?", adminEmails, true).Find(&users).Error; err != nil {
+			return err
+		}
+
+		for i := range users {
+			if err := tx.Model(&users[i]).Association("Roles").Append(&role); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
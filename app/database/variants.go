@@ -0,0 +1,45 @@
+package database
+
+import "gorm.io/gorm"
+
+var variantMigrations = []string{
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_variants_sku ON variants (sku) WHERE deleted_at IS NULL`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_variants_product_option_key ON variants (product_id, option_key) WHERE deleted_at IS NULL`,
+
+	// Раньше строки заказа были связями order_products (order_id, cart_product_id) с копиями строк корзины,
+	// теперь это таблица модели OrderProduct. Старые связи переносятся в строки с товаром и количеством.
+	`DO $$
+	BEGIN
+		IF EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_name = 'order_products' AND column_name = 'cart_product_id'
+		) THEN
+			UPDATE order_products SET
+				product_id = cart_products.product_id,
+				quantity = cart_products.quantity,
+				created_at = cart_products.created_at,
+				updated_at = cart_products.updated_at
+			FROM cart_products
+			WHERE cart_products.id = order_products.cart_product_id;
+
+			DELETE FROM order_products WHERE product_id IS NULL;
+
+			ALTER TABLE order_products DROP COLUMN cart_product_id;
+			ALTER TABLE order_products ADD PRIMARY KEY (id, order_id, product_id);
+		END IF;
+	END
+	$$`,
+}
+
+// migrateVariants создает уникальные индексы вариантов товаров и переводит строки заказа на модель OrderProduct
+func migrateVariants(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, statement := range variantMigrations {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,408 @@
+package handlers
+
+import (
+	"fusion/app/database/models"
+	"fusion/app/middleware"
+	"fusion/app/schemas"
+	"fusion/app/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+const (
+	adminDefaultLimit = 20
+	adminMaxLimit     = 100
+)
+
+type AdminRoute struct {
+	config   utils.AppConfig
+	jwt      utils.JWTService
+	db       *gorm.DB
+	guard    *utils.LoginGuard
+	validate *validator.Validate
+}
+
+// RegisterAdminRoutes регистрирует маршруты модерации; каждый защищен отдельным разрешением
+func RegisterAdminRoutes(app *fiber.App, db *gorm.DB, config utils.AppConfig, jwtService utils.JWTService, guard *utils.LoginGuard) {
+	handler := &AdminRoute{
+		config:   config,
+		jwt:      jwtService,
+		db:       db,
+		guard:    guard,
+		validate: validator.New(),
+	}
+
+	require := func(permission string) fiber.Handler {
+		return middleware.AuthMiddleware(middleware.AllOf(permission))
+	}
+
+	adminGroup := app.Group("/admin")
+	adminGroup.Get("/users", require(models.PermissionUsersRead), handler.GetUsers)
+	adminGroup.Get("/users/:id", require(models.PermissionUsersRead), handler.GetUser)
+	adminGroup.Post("/users/:id/ban", require(models.PermissionUsersBan), handler.BanUser)
+	adminGroup.Post("/users/:id/unban", require(models.PermissionUsersBan), handler.UnbanUser)
+	adminGroup.Post("/users/:id/verify-email", require(models.PermissionUsersVerify), handler.VerifyUserEmail)
+	adminGroup.Post("/users/:id/unlock", require(models.PermissionUsersUnlock), handler.UnlockUser)
+	adminGroup.Post("/users/:id/impersonate", require(models.PermissionUsersImpersonate), handler.ImpersonateUser)
+	adminGroup.Get("/impersonations", require(models.PermissionAuditRead), handler.GetImpersonationLog)
+
+	adminGroup.Post("/products/:id/hide", require(models.PermissionProductsModerate), handler.HideProduct)
+	adminGroup.Post("/products/:id/restore", require(models.PermissionProductsModerate), handler.RestoreProduct)
+
+	adminGroup.Delete("/reviews/:id", require(models.PermissionReviewsModerate), handler.RemoveReview)
+
+	adminGroup.Get("/orders", require(models.PermissionOrdersRead), handler.GetOrders)
+	adminGroup.Get("/orders/:id", require(models.PermissionOrdersRead), handler.GetOrder)
+	adminGroup.Get("/orders/:id/events", require(models.PermissionOrdersRead), handler.GetOrderEvents)
+	adminGroup.Post("/orders/:id/status", require(models.PermissionOrdersManage), handler.UpdateOrderStatus)
+
+	adminGroup.Get("/service-accounts", require(models.PermissionServiceAccounts), handler.GetServiceAccounts)
+	adminGroup.Post("/service-accounts", require(models.PermissionServiceAccounts), handler.CreateServiceAccount)
+	adminGroup.Get("/service-accounts/:id/api-keys", require(models.PermissionServiceAccounts), handler.GetServiceAccountKeys)
+	adminGroup.Post("/service-accounts/:id/api-keys", require(models.PermissionServiceAccounts), handler.CreateServiceAccountKey)
+	adminGroup.Delete("/service-accounts/:id/api-keys/:key", require(models.PermissionServiceAccounts), handler.RevokeServiceAccountKey)
+}
+
+// GetUsers возвращает пользователей с поиском по email и имени
+func (h *AdminRoute) GetUsers(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+
+	limit := c.QueryInt("limit", adminDefaultLimit)
+	if limit < 1 || limit > adminMaxLimit {
+		limit = adminDefaultLimit
+	}
+
+	query := h.db.Model(&models.User{})
+	if q := c.Query("q"); q != "" {
+		pattern := "%" + q + "%"
+		query = query.Where("email ILIKE ? OR username ILIKE ?", pattern, pattern)
+	}
+
+	if banned := c.Query("banned"); banned != "" {
+		query = query.Where("is_banned = ?", c.QueryBool("banned"))
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not count users")
+	}
+
+	var users []models.User
+	if err := query.
+		Order("created_at desc").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&users).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve users")
+	}
+
+	response := schemas.AdminUserListResponse{
+		Users: make([]schemas.AdminUserResponse, len(users)),
+		Total: total,
+		Page:  page,
+		Limit: limit,
+	}
+
+	for i, user := range users {
+		response.Users[i] = toAdminUserResponse(user)
+	}
+
+	return c.JSON(response)
+}
+
+// GetUser возвращает пользователя по ID
+func (h *AdminRoute) GetUser(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := h.db.First(&user, parsedId).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "user not found")
+	}
+
+	return c.JSON(toAdminUserResponse(user))
+}
+
+// BanUser блокирует пользователя и завершает все его сессии
+func (h *AdminRoute) BanUser(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	var input schemas.BanRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	admin := c.Locals("current_user").(models.User)
+	if admin.ID == parsedId {
+		return fiber.NewError(fiber.StatusBadRequest, "cannot ban yourself")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	var user models.User
+	if err := tx.First(&user, parsedId).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusNotFound, "user not found")
+	}
+
+	if err := tx.Model(&user).Updates(map[string]interface{}{
+		"is_banned":  true,
+		"ban_reason": input.Reason,
+		"banned_at":  time.Now(),
+	}).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not ban user")
+	}
+
+	if err := tx.Model(&models.Session{}).
+		Where("user_id = ? AND is_active = ?", user.ID, true).
+		Update("is_active", false).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not revoke sessions")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return c.JSON(toAdminUserResponse(user))
+}
+
+// UnbanUser снимает блокировку с пользователя
+func (h *AdminRoute) UnbanUser(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := h.db.First(&user, parsedId).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "user not found")
+	}
+
+	if err := h.db.Model(&user).Updates(map[string]interface{}{
+		"is_banned":  false,
+		"ban_reason": "",
+		"banned_at":  nil,
+	}).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not unban user")
+	}
+
+	return c.JSON(toAdminUserResponse(user))
+}
+
+// VerifyUserEmail принудительно подтверждает email пользователя
+func (h *AdminRoute) VerifyUserEmail(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := h.db.First(&user, parsedId).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "user not found")
+	}
+
+	if err := h.db.Model(&user).Update("is_email_verified", true).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not verify email")
+	}
+
+	if err := h.db.Where("user_id = ? AND type = ?", user.ID, "EMAIL_VERIFY").Delete(&models.Verification{}).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not delete verification tokens")
+	}
+
+	return c.JSON(toAdminUserResponse(user))
+}
+
+// UnlockUser снимает блокировку входа, наложенную после неудачных попыток
+func (h *AdminRoute) UnlockUser(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := h.db.First(&user, parsedId).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "user not found")
+	}
+
+	if err := h.guard.Reset(utils.AccountKey(user.Email)); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not unlock user")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// HideProduct скрывает продукт из каталога
+func (h *AdminRoute) HideProduct(c *fiber.Ctx) error {
+	return h.setProductHidden(c, true)
+}
+
+// RestoreProduct возвращает скрытый продукт в каталог
+func (h *AdminRoute) RestoreProduct(c *fiber.Ctx) error {
+	return h.setProductHidden(c, false)
+}
+
+func (h *AdminRoute) setProductHidden(c *fiber.Ctx, hidden bool) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	result := h.db.Model(&models.Product{}).Where("id = ?", parsedId).Update("is_hidden", hidden)
+	if result.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not update product")
+	}
+
+	if result.RowsAffected == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "product not found")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RemoveReview удаляет отзыв любого пользователя
+func (h *AdminRoute) RemoveReview(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	result := h.db.Where("id = ?", parsedId).Delete(&models.Review{})
+	if result.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not remove review")
+	}
+
+	if result.RowsAffected == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "review not found")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetOrders возвращает заказы всех пользователей, с фильтром по user_id
+func (h *AdminRoute) GetOrders(c *fiber.Ctx) error {
+	page, err := parseCursorPage(c, orderSorts, "-created")
+	if err != nil {
+		return err
+	}
+
+	query := h.db.Preload("Products")
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("orders.user_id = ?", userID)
+	}
+
+	var orders []models.Order
+	if err := page.apply(query, "orders").Find(&orders).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve orders")
+	}
+
+	return c.JSON(orderPage(page, orders))
+}
+
+// GetOrder возвращает любой заказ по ID
+func (h *AdminRoute) GetOrder(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	var order models.Order
+	if err := h.db.Preload("Products").First(&order, "id = ?", parsedId).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "order not found")
+	}
+
+	return c.JSON(order)
+}
+
+// GetOrderEvents возвращает историю статусов любого заказа
+func (h *AdminRoute) GetOrderEvents(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.First(&models.Order{}, "id = ?", parsedId).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "order not found")
+	}
+
+	events, err := orderEvents(h.db, parsedId)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(events)
+}
+
+// UpdateOrderStatus меняет статус заказа от имени сотрудника: отправка, доставка, отмена и прием;
+// оплаченным и возвращенным заказ делают только вебхуки платежного провайдера
+func (h *AdminRoute) UpdateOrderStatus(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	var input schemas.OrderStatusRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	staff := c.Locals("current_user").(models.User)
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", parsedId).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusNotFound, "order not found")
+	}
+
+	if err := transitionOrder(tx, &order, input.Status, models.OrderActorStaff, &staff.ID, input.Note); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return c.JSON(order)
+}
+
+func toAdminUserResponse(user models.User) schemas.AdminUserResponse {
+	return schemas.AdminUserResponse{
+		ID:              user.ID,
+		Username:        user.Username,
+		Email:           user.Email,
+		IsEmailVerified: user.IsEmailVerified,
+		IsBanned:        user.IsBanned,
+		BanReason:       user.BanReason,
+		BannedAt:        user.BannedAt,
+		CreatedAt:       user.CreatedAt,
+	}
+}
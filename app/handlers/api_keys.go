@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"fusion/app/database/models"
+	"fusion/app/schemas"
+	"fusion/app/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+const apiKeyDisplayPrefix = 12
+
+// getApiKeys возвращает действующие API-ключи текущего пользователя
+func (h UsersRoute) getApiKeys(c *fiber.Ctx) error {
+	user := c.Locals("current_user").(models.User)
+
+	response, err := listApiKeys(h.db, user.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(response)
+}
+
+// createApiKey создает API-ключ текущего пользователя с разрешениями из его ролей
+func (h UsersRoute) createApiKey(c *fiber.Ctx) error {
+	user := c.Locals("current_user").(models.User)
+
+	response, err := issueApiKey(c, h.db, h.validate, user, user)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+// revokeApiKey отзывает API-ключ текущего пользователя
+func (h UsersRoute) revokeApiKey(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	user := c.Locals("current_user").(models.User)
+	if err := revokeApiKey(h.db, user.ID, parsedId); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// issueApiKey создает ключ для owner. Область ключа не может превышать разрешения
+// creator: для собственных ключей это сам пользователь, для сервисных - администратор.
+func issueApiKey(c *fiber.Ctx, db *gorm.DB, validate *validator.Validate, owner, creator models.User) (schemas.ApiKeyCreatedResponse, error) {
+	var response schemas.ApiKeyCreatedResponse
+
+	var input schemas.ApiKeyCreateRequest
+	if err := c.BodyParser(&input); err != nil {
+		return response, fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := validate.Struct(&input); err != nil {
+		return response, fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	if input.ExpiresAt != nil && input.ExpiresAt.Before(time.Now()) {
+		return response, fiber.NewError(fiber.StatusBadRequest, "expires_at must be in the future")
+	}
+
+	if err := requireGrantable(creator, input.Permissions); err != nil {
+		return response, err
+	}
+
+	key, err := utils.GenerateApiKey()
+	if err != nil {
+		return response, fiber.NewError(fiber.StatusInternalServerError, "could not generate api key")
+	}
+
+	tx := db.Begin()
+	if tx.Error != nil {
+		return response, fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	permissions, err := findOrCreatePermissions(tx, input.Permissions)
+	if err != nil {
+		tx.Rollback()
+		return response, err
+	}
+
+	apiKey := models.ApiKey{
+		UserID:      owner.ID,
+		CreatedByID: creator.ID,
+		Name:        input.Name,
+		Prefix:      key[:apiKeyDisplayPrefix],
+		KeyHash:     utils.HashToken(key),
+		Permissions: permissions,
+		ExpiresAt:   input.ExpiresAt,
+	}
+
+	if err := tx.Create(&apiKey).Error; err != nil {
+		tx.Rollback()
+		return response, fiber.NewError(fiber.StatusInternalServerError, "could not create api key")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return response, fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	response.ApiKeyResponse = toApiKeyResponse(apiKey)
+	response.Key = key
+	return response, nil
+}
+
+// listApiKeys возвращает неотозванные ключи пользователя
+func listApiKeys(db *gorm.DB, userID uuid.UUID) ([]schemas.ApiKeyResponse, error) {
+	var apiKeys []models.ApiKey
+	if err := db.
+		Preload("Permissions").
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("created_at desc").
+		Find(&apiKeys).Error; err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "could not retrieve api keys")
+	}
+
+	response := make([]schemas.ApiKeyResponse, len(apiKeys))
+	for i, apiKey := range apiKeys {
+		response[i] = toApiKeyResponse(apiKey)
+	}
+
+	return response, nil
+}
+
+// revokeApiKey отзывает ключ; запись сохраняется, чтобы было видно, кто и когда им пользовался
+func revokeApiKey(db *gorm.DB, userID, keyID uuid.UUID) error {
+	result := db.Model(&models.ApiKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", keyID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not revoke api key")
+	}
+
+	if result.RowsAffected == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "api key not found")
+	}
+
+	return nil
+}
+
+func toApiKeyResponse(apiKey models.ApiKey) schemas.ApiKeyResponse {
+	return schemas.ApiKeyResponse{
+		ID:          apiKey.ID,
+		Name:        apiKey.Name,
+		Prefix:      apiKey.Prefix,
+		Permissions: apiKey.Scopes(),
+		ExpiresAt:   apiKey.ExpiresAt,
+		LastUsedAt:  apiKey.LastUsedAt,
+		CreatedAt:   apiKey.CreatedAt,
+	}
+}
@@ -4,30 +4,43 @@ import (
 	"errors"
 	"fmt"
 	"fusion/app/database/models"
+	"fusion/app/middleware"
 	"fusion/app/utils"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type AuthRoute struct {
-	config   utils.AppConfig
-	jwt      utils.JWTService
-	email    utils.EmailService
-	db       *gorm.DB
-	validate *validator.Validate
+	config    utils.AppConfig
+	jwt       utils.JWTService
+	email     utils.EmailService
+	providers map[string]utils.IdentityProvider
+	guard     *utils.LoginGuard
+	passwords utils.PasswordPolicy
+	db        *gorm.DB
+	validate  *validator.Validate
 }
 
 // RegisterAuthRoutes регистрирует маршруты для аутентификации
-func RegisterAuthRoutes(app *fiber.App, db *gorm.DB, config utils.AppConfig, jwtService utils.JWTService, email utils.EmailService) {
+func RegisterAuthRoutes(app *fiber.App, db *gorm.DB, config utils.AppConfig, jwtService utils.JWTService, email utils.EmailService, providers map[string]utils.IdentityProvider, guard *utils.LoginGuard, passwords utils.PasswordPolicy) {
 	handler := &AuthRoute{
-		config:   config,
-		jwt:      jwtService,
-		email:    email,
-		db:       db,
-		validate: validator.New(),
+		config:    config,
+		jwt:       jwtService,
+		email:     email,
+		providers: providers,
+		guard:     guard,
+		passwords: passwords,
+		db:        db,
+		validate:  validator.New(),
 	}
 
 	authGroup := app.Group("/auth")
@@ -38,6 +51,17 @@ func RegisterAuthRoutes(app *fiber.App, db *gorm.DB, config utils.AppConfig, jwt
 	authGroup.Post("/reset-password", handler.ResetPassword)
 	authGroup.Post("/change-password", handler.VerifyPasswordReset)
 	authGroup.Post("/verify-email", handler.VerifyEmail)
+	authGroup.Post("/magic-link", handler.RequestMagicLink)
+	authGroup.Post("/magic-link/consume", handler.ConsumeMagicLink)
+
+	authGroup.Post("/2fa/verify", handler.VerifyTwoFactor)
+	authGroup.Post("/2fa/enroll", middleware.AuthMiddleware(middleware.NoApiKey), middleware.NoImpersonation, handler.EnrollTwoFactor)
+	authGroup.Post("/2fa/confirm", middleware.AuthMiddleware(middleware.NoApiKey), middleware.NoImpersonation, handler.ConfirmTwoFactor)
+	authGroup.Post("/2fa/disable", middleware.AuthMiddleware(middleware.NoApiKey), middleware.NoImpersonation, handler.DisableTwoFactor)
+
+	authGroup.Post("/oauth/exchange", handler.ExchangeOAuthCode)
+	authGroup.Get("/oauth/:provider", handler.StartOAuth)
+	authGroup.Get("/oauth/:provider/callback", handler.OAuthCallback)
 }
 
 // Register обрабатывает регистрацию нового пользователя
@@ -45,7 +69,7 @@ func (h AuthRoute) Register(c *fiber.Ctx) error {
 	type RegisterInput struct {
 		Username    string `json:"username" validate:"required,min=3,max=32"`
 		Email       string `json:"email"    validate:"required,email"`
-		Password    string `json:"password" validate:"required,min=8"`
+		Password    string `json:"password" validate:"required"`
 		RedirectUrl string `json:"redirect_url" validate:"required"`
 	}
 
@@ -69,6 +93,15 @@ func (h AuthRoute) Register(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, "user already registered")
 	}
 
+	fieldErrors, err := h.checkPassword(h.db, nil, input.Password)
+	if err != nil {
+		return err
+	}
+
+	if len(fieldErrors) > 0 {
+		return passwordErrorResponse(c, fieldErrors)
+	}
+
 	hashedPassword, err := utils.HashPassword(input.Password)
 	if err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "could not hash password")
@@ -98,6 +131,11 @@ func (h AuthRoute) Register(c *fiber.Ctx) error {
 		}
 	}
 
+	if err := h.recordPassword(tx, user.ID, hashedPassword); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	token := uuid.New().String()
 	verification := models.Verification{
 		Type:      "EMAIL_VERIFY",
@@ -145,102 +183,319 @@ func (h AuthRoute) Login(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
 	}
 
+	wait, err := h.guard.Wait(utils.AccountKey(input.Email), utils.IPKey(c.IP()))
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not check login attempts")
+	}
+
+	if wait > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return fiber.NewError(fiber.StatusTooManyRequests, "too many login attempts, try again later")
+	}
+
 	var user models.User
 	if err := h.db.Where("email = ? AND is_email_verified = ?", input.Email, true).First(&user).Error; err != nil {
+		if err := h.registerLoginFailure(c, input.Email, nil); err != nil {
+			return err
+		}
 		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
 	}
 
 	if !utils.CheckPasswordHash(input.Password, user.Password) {
+		if err := h.registerLoginFailure(c, input.Email, &user); err != nil {
+			return err
+		}
 		return fiber.NewError(fiber.StatusUnauthorized, "incorrect password")
 	}
 
+	// С двухфакторной аутентификацией вход завершается только вторым шагом, там и сбрасываются попытки
+	if !user.IsTwoFactorEnabled {
+		if err := h.guard.Reset(utils.AccountKey(input.Email)); err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "could not reset login attempts")
+		}
+	}
+
+	return h.completeLogin(c, user)
+}
+
+// registerLoginFailure учитывает неудачную попытку входа и уведомляет владельца о блокировке учетной записи
+func (h AuthRoute) registerLoginFailure(c *fiber.Ctx, email string, user *models.User) error {
+	if err := h.guard.FailIP(c.IP()); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not register login attempt")
+	}
+
+	locked, until, err := h.guard.FailAccount(email)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not register login attempt")
+	}
+
+	if locked && user != nil {
+		type LockedData struct{ Until string }
+		data := LockedData{
+			Until: until.UTC().Format("2006-01-02 15:04 MST"),
+		}
+
+		if err := h.email.SendEmail(user.Email, "Account locked", "account_locked", data); err != nil {
+			log.Printf("could not send account locked email to user %s: %v", user.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// completeLogin выдает пару токенов либо, если включена двухфакторная аутентификация, токен второго шага
+func (h AuthRoute) completeLogin(c *fiber.Ctx, user models.User) error {
+	if user.IsBanned {
+		return fiber.NewError(fiber.StatusForbidden, "user banned")
+	}
+
+	if user.IsTwoFactorEnabled {
+		challengeToken, err := h.createTwoFactorChallenge(user)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"two_factor_required": true, "challenge_token": challengeToken})
+	}
+
+	accessToken, refreshToken, err := h.issueSession(h.db, user.ID, uuid.New(), c)
+	if err != nil {
+		return err
+	}
+
+	return h.sendTokens(c, accessToken, refreshToken, h.wantsCookies(c))
+}
+
+// wantsCookies сообщает, нужно ли выдать токены в cookie: в режиме cookie браузерный клиент
+// запрашивает это заголовком X-Auth-Transport, мобильные клиенты продолжают получать токены в теле ответа
+func (h AuthRoute) wantsCookies(c *fiber.Ctx) bool {
+	return h.config.AuthMode == "cookie" && c.Get(middleware.TransportHeader) == "cookie"
+}
+
+// sendTokens возвращает пару токенов в теле ответа либо в HttpOnly cookie вместе с CSRF-токеном
+func (h AuthRoute) sendTokens(c *fiber.Ctx, accessToken, refreshToken string, cookies bool) error {
+	if !cookies {
+		return c.JSON(fiber.Map{"access_token": accessToken, "refresh_token": refreshToken})
+	}
+
+	csrfToken, err := utils.GenerateRandomToken()
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not create csrf token")
+	}
+
+	now := time.Now()
+	c.Cookie(h.authCookie(middleware.AccessTokenCookie, accessToken, "/", now.Add(h.config.SessionExpire), true))
+	c.Cookie(h.authCookie(middleware.RefreshTokenCookie, refreshToken, "/auth", now.Add(h.config.RefreshExpire), true))
+	// CSRF-токен должен быть доступен скрипту, чтобы тот мог повторить его в заголовке
+	c.Cookie(h.authCookie(middleware.CSRFCookie, csrfToken, "/", now.Add(h.config.RefreshExpire), false))
+
+	return c.JSON(fiber.Map{"csrf_token": csrfToken})
+}
+
+// clearAuthCookies удаляет cookie с токенами при выходе
+func (h AuthRoute) clearAuthCookies(c *fiber.Ctx) {
+	expired := time.Unix(0, 0)
+	c.Cookie(h.authCookie(middleware.AccessTokenCookie, "", "/", expired, true))
+	c.Cookie(h.authCookie(middleware.RefreshTokenCookie, "", "/auth", expired, true))
+	c.Cookie(h.authCookie(middleware.CSRFCookie, "", "/", expired, false))
+}
+
+func (h AuthRoute) authCookie(name, value, path string, expires time.Time, httpOnly bool) *fiber.Cookie {
+	sameSite := h.config.CookieSameSite
+	if sameSite == "" {
+		sameSite = fiber.CookieSameSiteStrictMode
+	}
+
+	return &fiber.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Domain:   h.config.CookieDomain,
+		Expires:  expires,
+		Secure:   !h.config.CookieInsecure,
+		HTTPOnly: httpOnly,
+		SameSite: sameSite,
+	}
+}
+
+// refreshTokenFromRequest берет refresh-токен из тела запроса или, в режиме cookie, из cookie.
+// Запрос с токеном из cookie должен пройти проверку CSRF.
+func (h AuthRoute) refreshTokenFromRequest(c *fiber.Ctx) (string, bool, error) {
+	type RefreshInput struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	var input RefreshInput
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return "", false, fiber.NewError(fiber.StatusBadRequest, "invalid input")
+		}
+	}
+
+	if input.RefreshToken != "" {
+		return input.RefreshToken, false, nil
+	}
+
+	if cookie := c.Cookies(middleware.RefreshTokenCookie); cookie != "" && h.config.AuthMode == "cookie" {
+		if err := middleware.ValidateCSRF(c); err != nil {
+			return "", false, err
+		}
+
+		return cookie, true, nil
+	}
+
+	return "", false, fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+}
+
+// issueSession создает новую сессию в цепочке familyID и выпускает для нее пару токенов
+func (h AuthRoute) issueSession(tx *gorm.DB, userID uuid.UUID, familyID uuid.UUID, c *fiber.Ctx) (string, string, error) {
 	jti := uuid.New()
-	accessToken, err := h.jwt.GenerateAccessToken(h.config.SessionExpire, user.ID.String())
+	accessToken, err := h.jwt.GenerateAccessToken(h.config.SessionExpire, userID.String(), familyID.String())
 	if err != nil {
-		return fiber.NewError(fiber.StatusInternalServerError, "could not create access token")
+		return "", "", fiber.NewError(fiber.StatusInternalServerError, "could not create access token")
 	}
 
-	refreshToken, err := h.jwt.GenerateRefreshToken(h.config.RefreshExpire, user.ID.String(), jti.String())
+	refreshToken, err := h.jwt.GenerateRefreshToken(h.config.RefreshExpire, userID.String(), jti.String())
 	if err != nil {
-		return fiber.NewError(fiber.StatusInternalServerError, "could not create refresh token")
+		return "", "", fiber.NewError(fiber.StatusInternalServerError, "could not create refresh token")
 	}
 
-	user.Sessions = append(user.Sessions, models.Session{
+	session := models.Session{
 		ID:        jti,
+		UserID:    userID,
+		FamilyID:  familyID,
 		Token:     refreshToken,
-		ExpiresAt: time.Now().Add(h.config.RefreshExpire),
 		Agent:     c.Get("User-Agent"),
-	})
+		IP:        c.IP(),
+		IsActive:  true,
+		ExpiresAt: time.Now().Add(h.config.RefreshExpire),
+	}
 
-	if err := h.db.Save(&user).Error; err != nil {
-		return fiber.NewError(fiber.StatusInternalServerError, "could not save session")
+	if err := tx.Create(&session).Error; err != nil {
+		return "", "", fiber.NewError(fiber.StatusInternalServerError, "could not save session")
 	}
 
-	return c.JSON(fiber.Map{"access_token": accessToken, "refresh_token": refreshToken})
+	return accessToken, refreshToken, nil
 }
 
 // Logout обрабатывает выход пользователя
 func (h AuthRoute) Logout(c *fiber.Ctx) error {
-	type RefreshInput struct {
-		RefreshToken string `json:"refresh_token" validate:"required"`
+	refreshToken, fromCookie, err := h.refreshTokenFromRequest(c)
+	if err != nil {
+		return err
 	}
 
-	var input RefreshInput
-	if err := c.BodyParser(&input); err != nil {
-		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	if fromCookie {
+		h.clearAuthCookies(c)
 	}
 
-	if err := h.validate.Struct(&input); err != nil {
-		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	token, err := h.jwt.ValidateToken(refreshToken)
+	if err != nil || !token.Valid {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid refresh token")
 	}
 
-	token, err := h.jwt.ValidateToken(input.RefreshToken)
-	if err != nil || !token.Valid {
+	claims, ok := token.Claims.(*utils.JwtCustomClaim)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid refresh token")
+	}
+
+	var session models.Session
+	if err := h.db.Where("id = ?", claims.ID).First(&session).Error; err != nil {
 		return fiber.NewError(fiber.StatusUnauthorized, "invalid refresh token")
 	}
 
-	claims := token.Claims.(utils.JwtCustomClaim)
-	if err := h.db.Where("JTI = ?", claims.ID).Delete(&models.Session{}); err != nil {
+	if err := revokeSessionFamily(h.db, session); err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "could not logout user")
 	}
 
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
-// Refresh обрабатывает обновление access токена
+// Refresh обменивает refresh-токен на новую пару токенов, погашая предъявленный.
+// Токен, пришедший в cookie, обновляется также в cookie и никогда не возвращается в теле ответа.
 func (h AuthRoute) Refresh(c *fiber.Ctx) error {
-	type RefreshInput struct {
-		RefreshToken string `json:"refresh_token" validate:"required"`
+	refreshToken, fromCookie, err := h.refreshTokenFromRequest(c)
+	if err != nil {
+		return err
 	}
 
-	var input RefreshInput
-	if err := c.BodyParser(&input); err != nil {
-		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	token, err := h.jwt.ValidateToken(refreshToken)
+	if err != nil || !token.Valid {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid refresh token")
 	}
 
-	if err := h.validate.Struct(&input); err != nil {
-		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	claims, ok := token.Claims.(*utils.JwtCustomClaim)
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid refresh token")
 	}
 
-	token, err := h.jwt.ValidateToken(input.RefreshToken)
-	if err != nil || !token.Valid {
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	var session models.Session
+	if err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", claims.ID).
+		First(&session).Error; err != nil {
+		tx.Rollback()
 		return fiber.NewError(fiber.StatusUnauthorized, "invalid refresh token")
 	}
 
-	claims := token.Claims.(utils.JwtCustomClaim)
-	sessionId := claims.ID
+	// Повторное предъявление уже использованного токена означает его утечку:
+	// отзываем всю цепочку, чтобы обе стороны были вынуждены войти заново.
+	if session.ConsumedAt != nil {
+		if err := revokeSessionFamily(tx, session); err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not revoke sessions")
+		}
 
-	var session models.Session
-	if err := h.db.Preload("User").Where("id = ?", sessionId).First(&session).Error; err != nil {
+		if err := tx.Commit().Error; err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+		}
+
+		return fiber.NewError(fiber.StatusUnauthorized, "refresh token reuse detected")
+	}
+
+	if !session.IsActive || session.Token != refreshToken || session.ExpiresAt.Before(time.Now()) {
+		tx.Rollback()
 		return fiber.NewError(fiber.StatusUnauthorized, "invalid refresh token")
 	}
 
-	newAccessToken, err := h.jwt.GenerateAccessToken(h.config.SessionExpire, session.UserID.String())
+	now := time.Now()
+	if err := tx.Model(&session).Updates(map[string]interface{}{
+		"consumed_at": now,
+		"is_active":   false,
+	}).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not consume refresh token")
+	}
+
+	accessToken, newRefreshToken, err := h.issueSession(tx, session.UserID, session.FamilyID, c)
 	if err != nil {
-		return fiber.NewError(fiber.StatusInternalServerError, "could not refresh access token")
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return h.sendTokens(c, accessToken, newRefreshToken, fromCookie || h.wantsCookies(c))
+}
+
+// revokeSessionFamily деактивирует все сессии цепочки ротации, к которой относится session
+func revokeSessionFamily(tx *gorm.DB, session models.Session) error {
+	query := tx.Model(&models.Session{}).Where("is_active = ?", true)
+	if session.FamilyID == uuid.Nil {
+		// сессии, созданные до появления ротации, не имеют цепочки
+		query = query.Where("id = ?", session.ID)
+	} else {
+		query = query.Where("family_id = ?", session.FamilyID)
 	}
 
-	return c.JSON(fiber.Map{"access_token": newAccessToken})
+	return query.Update("is_active", false).Error
 }
 
 // ResetPassword обрабатывает запрос на сброс пароля
@@ -292,7 +547,7 @@ func (h AuthRoute) ResetPassword(c *fiber.Ctx) error {
 func (h AuthRoute) VerifyPasswordReset(c *fiber.Ctx) error {
 	type VerifyInput struct {
 		Token    string `json:"token" validate:"required"`
-		Password string `json:"password" validate:"required,min=8"`
+		Password string `json:"password" validate:"required"`
 	}
 
 	var input VerifyInput
@@ -313,6 +568,7 @@ func (h AuthRoute) VerifyPasswordReset(c *fiber.Ctx) error {
 	if err := tx.
 		Where("token = ?", input.Token).
 		Where("type = ?", "PASSWORD_RESET").
+		Preload("User").
 		First(&verification).Error; err != nil {
 		tx.Rollback()
 		return fiber.NewError(fiber.StatusNotFound, "verification token not found")
@@ -323,18 +579,33 @@ func (h AuthRoute) VerifyPasswordReset(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusUnauthorized, "verification token expired")
 	}
 
+	fieldErrors, err := h.checkPassword(tx, &verification.User, input.Password)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if len(fieldErrors) > 0 {
+		tx.Rollback()
+		return passwordErrorResponse(c, fieldErrors)
+	}
+
 	hashedPassword, err := utils.HashPassword(input.Password)
 	if err != nil {
 		tx.Rollback()
 		return fiber.NewError(fiber.StatusInternalServerError, "could not hash password")
 	}
 
-	verification.User.Password = hashedPassword
-	if err := tx.Save(&verification.User).Error; err != nil {
+	if err := tx.Model(&verification.User).Update("password", hashedPassword).Error; err != nil {
 		tx.Rollback()
 		return fiber.NewError(fiber.StatusInternalServerError, "could not reset password")
 	}
 
+	if err := h.recordPassword(tx, verification.UserID, hashedPassword); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err := tx.Delete(&verification).Error; err != nil {
 		tx.Rollback()
 		return fiber.NewError(fiber.StatusInternalServerError, "could not delete verification token")
@@ -392,3 +663,30 @@ func (h AuthRoute) VerifyEmail(c *fiber.Ctx) error {
 
 	return c.JSON(fiber.Map{"message": "email verified"})
 }
+
+// isAllowedRedirect проверяет, что адрес возврата совпадает с одним из разрешенных адресов по схеме и хосту
+// и находится в его пути: "https://shop.example.com/app" разрешает "/app" и "/app/...", но не "/application"
+func isAllowedRedirect(config utils.AppConfig, redirectURL string) bool {
+	target, err := url.Parse(redirectURL)
+	if err != nil || target.Scheme == "" || target.Host == "" || target.User != nil {
+		return false
+	}
+
+	for _, value := range utils.SplitList(config.AllowedRedirects) {
+		allowed, err := url.Parse(value)
+		if err != nil || allowed.Host == "" {
+			continue
+		}
+
+		if !strings.EqualFold(target.Scheme, allowed.Scheme) || !strings.EqualFold(target.Host, allowed.Host) {
+			continue
+		}
+
+		prefix := strings.TrimSuffix(allowed.Path, "/")
+		if prefix == "" || target.Path == prefix || strings.HasPrefix(target.Path, prefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
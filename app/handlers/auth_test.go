@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"fusion/app/utils"
+	"testing"
+)
+
+func TestIsAllowedRedirect(t *testing.T) {
+	config := utils.AppConfig{AllowedRedirects: "https://shop.example.com, http://localhost:3000/app/"}
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://shop.example.com/", true},
+		{"https://shop.example.com/auth/callback?code=1", true},
+		{"HTTPS://Shop.Example.com/auth", true},
+		{"https://shop.example.com.evil.com/auth", false},
+		{"https://shop.example.com@evil.com/auth", false},
+		{"https://user@shop.example.com/auth", false},
+		{"http://shop.example.com/auth", false},
+		{"https://shop.example.com:8443/auth", false},
+		{"http://localhost:3000/app", true},
+		{"http://localhost:3000/app/login", true},
+		{"http://localhost:3000/application", false},
+		{"http://localhost:3000/", false},
+		{"//shop.example.com/auth", false},
+		{"/auth", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isAllowedRedirect(config, tt.url); got != tt.want {
+			t.Errorf("isAllowedRedirect(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
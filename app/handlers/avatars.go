@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"fusion/app/database/models"
+	"fusion/app/schemas"
+	"fusion/app/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"log"
+)
+
+// uploadAvatar сохраняет аватар из поля avatar во всех размерах и заменяет им текущий
+func (h UsersRoute) uploadAvatar(c *fiber.Ctx) error {
+	user := c.Locals("current_user").(models.User)
+
+	file, err := c.FormFile("avatar")
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "no avatar")
+	}
+
+	data, err := readImageUpload(file, h.config.UploadMaxSize)
+	if err != nil {
+		return err
+	}
+
+	// Каждая загрузка получает новый ключ, чтобы клиенты и CDN не показывали старый аватар из кеша
+	key, err := utils.StoreImage(c.UserContext(), h.storage, "avatars/"+user.ID.String()+"/"+uuid.NewString(), data, utils.AvatarSizes)
+	if err != nil {
+		return imageError(err)
+	}
+
+	avatar := h.storage.URL(utils.ImageKey(key, "medium"))
+	if err := h.db.Model(&user).Updates(map[string]interface{}{"avatar": avatar, "avatar_key": key}).Error; err != nil {
+		h.deleteAvatar(key)
+		return fiber.NewError(fiber.StatusInternalServerError, "could not update avatar")
+	}
+
+	if user.AvatarKey != nil {
+		h.deleteAvatar(*user.AvatarKey)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(schemas.AvatarResponse{
+		Avatar:     avatar,
+		AvatarURLs: utils.ImageURLs(h.storage, key, utils.AvatarSizes),
+	})
+}
+
+// removeAvatar удаляет аватар текущего пользователя
+func (h UsersRoute) removeAvatar(c *fiber.Ctx) error {
+	user := c.Locals("current_user").(models.User)
+
+	if err := h.db.Model(&user).Updates(map[string]interface{}{"avatar": nil, "avatar_key": nil}).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not remove avatar")
+	}
+
+	if user.AvatarKey != nil {
+		h.deleteAvatar(*user.AvatarKey)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// avatarURLs возвращает адреса всех размеров загруженного аватара
+func (h UsersRoute) avatarURLs(user models.User) map[string]string {
+	if user.AvatarKey == nil {
+		return nil
+	}
+
+	return utils.ImageURLs(h.storage, *user.AvatarKey, utils.AvatarSizes)
+}
+
+// deleteAvatar удаляет файлы аватара, который больше не используется; ошибки только записываются в лог
+func (h UsersRoute) deleteAvatar(key string) {
+	if err := utils.DeleteImage(context.Background(), h.storage, key, utils.AvatarSizes); err != nil {
+		log.Printf("could not delete avatar %s: %v", key, err)
+	}
+}
@@ -4,97 +4,70 @@ import (
 	"fusion/app/database/models"
 	"fusion/app/middleware"
 	"fusion/app/schemas"
+	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type CartRoute struct {
-	db *gorm.DB
+	db       *gorm.DB
+	validate *validator.Validate
 }
 
 func RegisterCartRoute(app *fiber.App, db *gorm.DB) {
 	handler := &CartRoute{
-		db: db,
+		db:       db,
+		validate: validator.New(),
 	}
 
 	cartGroup := app.Group("/cart")
-	cartGroup.Use(middleware.AuthMiddleware())
-	cartGroup.Get("/cart", handler.GetCart)
-	cartGroup.Post("/cart", handler.AddToCart)
-	cartGroup.Put("/cart", handler.UpdateCart)
-	cartGroup.Delete("/cart", handler.RemoveFromCart)
+	cartGroup.Use(middleware.AuthMiddleware(middleware.ApiKeyScope(models.ScopeCart)), middleware.IdempotencyMiddleware())
+	cartGroup.Get("/", handler.GetCart)
+	cartGroup.Post("/", handler.AddToCart)
+	cartGroup.Put("/", handler.UpdateCart)
+	cartGroup.Delete("/", handler.RemoveFromCart)
 }
 
 func (h *CartRoute) GetCart(c *fiber.Ctx) error {
 	user := c.Locals("current_user").(models.User)
 
-	var cart models.Cart
-	if err := h.db.
-		Preload("Products").
-		Where("user_id = ?", user.ID).
-		First(&cart).
-		Error; err != nil {
-		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve cart")
+	cart, err := h.userCart(user.ID)
+	if err != nil {
+		return err
 	}
 
-	response := schemas.CartResponse{
-		ID:       cart.ID.String(),
-		UserID:   cart.UserID.String(),
-		Products: make([]schemas.CartProductResponse, len(cart.Products)),
-	}
-
-	for i, p := range cart.Products {
-		response.Products[i] = schemas.CartProductResponse{
-			ID:       p.ID.String(),
-			Quantity: p.Quantity,
-		}
-	}
-
-	return c.JSON(response)
+	return c.JSON(toCartResponse(cart))
 }
 
+// AddToCart добавляет товар или его вариант, если он есть в наличии в нужном количестве
 func (h *CartRoute) AddToCart(c *fiber.Ctx) error {
-	type AddToCartInput struct {
-		ProductID string `json:"product_id"`
-		Quantity  int    `json:"quantity"`
-	}
-
 	user := c.Locals("current_user").(models.User)
 
-	var input AddToCartInput
-	if err := c.BodyParser(&input); err != nil {
-		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	input, err := h.parseCartItem(c)
+	if err != nil {
+		return err
+	}
+	if input.Quantity == 0 {
+		input.Quantity = 1
 	}
 
-	var product models.Product
-	if err := h.db.First(&product, "id = ?", input.ProductID).Error; err != nil {
-		return fiber.NewError(fiber.StatusNotFound, "product not found")
+	product, variant, err := resolveCartItem(h.db, input)
+	if err != nil {
+		return err
 	}
 
-	var cart models.Cart
-	if err := h.db.
-		Preload("Products").
-		Where("user_id = ?", user.ID).
-		First(&cart).
-		Error; err != nil {
-		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve cart")
+	if availableStock(product, variant) < input.Quantity {
+		return fiber.NewError(fiber.StatusConflict, "not enough stock")
 	}
 
-	if cart.ID == uuid.Nil {
-		cart = models.Cart{
-			UserID: user.ID,
-		}
+	cart, err := h.userCart(user.ID)
+	if err != nil {
+		return err
+	}
 
-		if err := h.db.Create(&cart).Error; err != nil {
-			return fiber.NewError(fiber.StatusInternalServerError, "could not create cart")
-		}
-	} else {
-		for _, p := range cart.Products {
-			if p.ID == product.ID {
-				return fiber.NewError(fiber.StatusBadRequest, "product already in cart")
-			}
-		}
+	if _, ok := findCartLine(cart, product.ID, variant); ok {
+		return fiber.NewError(fiber.StatusBadRequest, "product already in cart")
 	}
 
 	cartProduct := models.CartProduct{
@@ -102,112 +75,177 @@ func (h *CartRoute) AddToCart(c *fiber.Ctx) error {
 		ProductID: product.ID,
 		Quantity:  input.Quantity,
 	}
+	if variant != nil {
+		cartProduct.VariantID = &variant.ID
+	}
 
 	if err := h.db.Create(&cartProduct).Error; err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "could not add product to cart")
 	}
 
-	response := schemas.CartResponse{
-		ID:       cart.ID.String(),
-		UserID:   cart.UserID.String(),
-		Products: make([]schemas.CartProductResponse, len(cart.Products)),
+	cart.Products = append(cart.Products, cartProduct)
+
+	return c.JSON(toCartResponse(cart))
+}
+
+// UpdateCart меняет количество товара или варианта в корзине
+func (h *CartRoute) UpdateCart(c *fiber.Ctx) error {
+	user := c.Locals("current_user").(models.User)
+
+	input, err := h.parseCartItem(c)
+	if err != nil {
+		return err
+	}
+	if input.Quantity < 1 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid quantity")
 	}
 
-	for i, p := range cart.Products {
-		response.Products[i] = schemas.CartProductResponse{
-			ID:       p.ID.String(),
-			Quantity: p.Quantity,
-		}
+	product, variant, err := resolveCartItem(h.db, input)
+	if err != nil {
+		return err
 	}
 
-	return c.JSON(response)
-}
+	if availableStock(product, variant) < input.Quantity {
+		return fiber.NewError(fiber.StatusConflict, "not enough stock")
+	}
 
-func (h *CartRoute) UpdateCart(c *fiber.Ctx) error {
-	type UpdateCartInput struct {
-		ProductID string `json:"product_id"`
-		Quantity  int    `json:"quantity"`
+	cart, err := h.userCart(user.ID)
+	if err != nil {
+		return err
+	}
+
+	i, ok := findCartLine(cart, product.ID, variant)
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "product not in cart")
+	}
+
+	cart.Products[i].Quantity = input.Quantity
+	if err := h.db.Model(&cart.Products[i]).Update("quantity", input.Quantity).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not update product in cart")
 	}
 
+	return c.Status(fiber.StatusAccepted).JSON(toCartResponse(cart))
+}
+
+// RemoveFromCart удаляет товар или вариант из корзины
+func (h *CartRoute) RemoveFromCart(c *fiber.Ctx) error {
 	user := c.Locals("current_user").(models.User)
 
-	var input UpdateCartInput
+	input, err := h.parseCartItem(c)
+	if err != nil {
+		return err
+	}
+
+	query := h.db.
+		Where("cart_id IN (SELECT id FROM carts WHERE user_id = ?)", user.ID).
+		Where("product_id = ?", input.ProductID)
+	if input.VariantID != nil {
+		query = query.Where("variant_id = ?", *input.VariantID)
+	} else {
+		query = query.Where("variant_id IS NULL")
+	}
+
+	if err := query.Delete(&models.CartProduct{}).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not delete product from cart")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *CartRoute) parseCartItem(c *fiber.Ctx) (schemas.CartItemRequest, error) {
+	var input schemas.CartItemRequest
 	if err := c.BodyParser(&input); err != nil {
-		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+		return input, fiber.NewError(fiber.StatusBadRequest, "invalid input")
 	}
 
-	var product models.Product
-	if err := h.db.First(&product, "id = ?", input.ProductID).Error; err != nil {
-		return fiber.NewError(fiber.StatusNotFound, "product not found")
+	if err := h.validate.Struct(&input); err != nil {
+		return input, fiber.NewError(fiber.StatusBadRequest, "invalid input data")
 	}
 
+	return input, nil
+}
+
+// userCart возвращает корзину пользователя, создавая ее при первом обращении
+func (h *CartRoute) userCart(userID uuid.UUID) (models.Cart, error) {
 	var cart models.Cart
 	if err := h.db.
-		Preload("Products").
-		Where("user_id = ?", user.ID).
-		First(&cart).
+		Preload("Products", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at")
+		}).
+		Where(models.Cart{UserID: userID}).
+		FirstOrCreate(&cart).
 		Error; err != nil {
-		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve cart")
+		return cart, fiber.NewError(fiber.StatusInternalServerError, "could not retrieve cart")
 	}
 
-	for _, p := range cart.Products {
-		if p.ProductID == product.ID {
-			p.Quantity = input.Quantity
-			if err := h.db.Save(&p).Error; err != nil {
-				return fiber.NewError(fiber.StatusInternalServerError, "could not update product in cart")
-			}
-		}
-	}
+	return cart, nil
+}
 
-	response := schemas.CartResponse{
-		ID:       cart.ID.String(),
-		UserID:   cart.UserID.String(),
-		Products: make([]schemas.CartProductResponse, len(cart.Products)),
+// resolveCartItem находит товар и выбранный вариант. У товара с вариантами вариант обязателен,
+// у товара без вариантов его быть не может.
+func resolveCartItem(db *gorm.DB, input schemas.CartItemRequest) (models.Product, *models.Variant, error) {
+	var product models.Product
+	if err := db.First(&product, "id = ? AND is_hidden = ?", input.ProductID, false).Error; err != nil {
+		return product, nil, fiber.NewError(fiber.StatusNotFound, "product not found")
 	}
 
-	for i, p := range cart.Products {
-		response.Products[i] = schemas.CartProductResponse{
-			ID:       p.ID.String(),
-			Quantity: p.Quantity,
+	if input.VariantID == nil {
+		if err := db.Where("product_id = ?", product.ID).First(&models.Variant{}).Error; err == nil {
+			return product, nil, fiber.NewError(fiber.StatusBadRequest, "variant is required")
 		}
+		return product, nil, nil
+	}
+
+	var variant models.Variant
+	if err := db.Where("id = ? AND product_id = ?", *input.VariantID, product.ID).First(&variant).Error; err != nil {
+		return product, nil, fiber.NewError(fiber.StatusNotFound, "variant not found")
 	}
 
-	return c.Status(fiber.StatusAccepted).JSON(response)
+	return product, &variant, nil
 }
 
-func (h *CartRoute) RemoveFromCart(c *fiber.Ctx) error {
-	type RemoveFromCartInput struct {
-		ProductID string `json:"product_id"`
+// availableStock возвращает остаток варианта, а для товара без вариантов — остаток товара
+func availableStock(product models.Product, variant *models.Variant) int {
+	if variant != nil {
+		return variant.Stock
 	}
 
-	user := c.Locals("current_user").(models.User)
+	return product.Stock
+}
 
-	var input RemoveFromCartInput
-	if err := c.BodyParser(&input); err != nil {
-		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
-	}
+// findCartLine ищет в корзине строку с товаром и вариантом
+func findCartLine(cart models.Cart, productID uuid.UUID, variant *models.Variant) (int, bool) {
+	for i, line := range cart.Products {
+		if line.ProductID != productID {
+			continue
+		}
 
-	var product models.Product
-	if err := h.db.First(&product, "id = ?", input.ProductID).Error; err != nil {
-		return fiber.NewError(fiber.StatusNotFound, "product not found")
+		if variant == nil && line.VariantID == nil || variant != nil && line.VariantID != nil && *line.VariantID == variant.ID {
+			return i, true
+		}
 	}
 
-	var cart models.Cart
-	if err := h.db.
-		Preload("Products").
-		Where("user_id = ?", user.ID).
-		First(&cart).
-		Error; err != nil {
-		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve cart")
+	return 0, false
+}
+
+func toCartResponse(cart models.Cart) schemas.CartResponse {
+	response := schemas.CartResponse{
+		ID:       cart.ID.String(),
+		UserID:   cart.UserID.String(),
+		Products: make([]schemas.CartProductResponse, len(cart.Products)),
 	}
 
-	for _, p := range cart.Products {
-		if p.ProductID == product.ID {
-			if err := h.db.Delete(&p).Error; err != nil {
-				return fiber.NewError(fiber.StatusInternalServerError, "could not delete product from cart")
-			}
+	for i, p := range cart.Products {
+		response.Products[i] = schemas.CartProductResponse{
+			ID:        p.ID.String(),
+			ProductID: p.ProductID.String(),
+			Quantity:  p.Quantity,
+		}
+		if p.VariantID != nil {
+			variantID := p.VariantID.String()
+			response.Products[i].VariantID = &variantID
 		}
 	}
 
-	return c.SendStatus(fiber.StatusNoContent)
+	return response
 }
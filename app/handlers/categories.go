@@ -0,0 +1,408 @@
+package handlers
+
+import (
+	"fusion/app/database/models"
+	"fusion/app/middleware"
+	"fusion/app/schemas"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+)
+
+// categorySubtreeQuery выбирает ID категории и всех ее потомков
+const categorySubtreeQuery = `
+	WITH RECURSIVE subtree AS (
+		SELECT id FROM categories WHERE id = ? AND deleted_at IS NULL
+		UNION
+		SELECT categories.id FROM categories
+		JOIN subtree ON categories.parent_id = subtree.id
+		WHERE categories.deleted_at IS NULL
+	)
+	SELECT id FROM subtree`
+
+// categoryAncestorsQuery выбирает категории вместе со всеми их предками
+const categoryAncestorsQuery = `
+	WITH RECURSIVE ancestors AS (
+		SELECT id, parent_id, name, slug FROM categories WHERE id IN ? AND deleted_at IS NULL
+		UNION
+		SELECT categories.id, categories.parent_id, categories.name, categories.slug FROM categories
+		JOIN ancestors ON categories.id = ancestors.parent_id
+		WHERE categories.deleted_at IS NULL
+	)
+	SELECT id, parent_id, name, slug FROM ancestors`
+
+type CategoryHandler struct {
+	db       *gorm.DB
+	validate *validator.Validate
+}
+
+// RegisterCategoryRoutes регистрирует маршруты дерева категорий; изменение требует разрешения categories:manage
+func RegisterCategoryRoutes(app *fiber.App, db *gorm.DB) {
+	handler := &CategoryHandler{
+		db:       db,
+		validate: validator.New(),
+	}
+
+	requireCategoriesManage := middleware.AuthMiddleware(middleware.AllOf(models.PermissionCategoriesManage))
+
+	categoryGroup := app.Group("/categories")
+	categoryGroup.Get("/", handler.GetCategories)
+	categoryGroup.Get("/:id", handler.GetCategory)
+	categoryGroup.Post("/", requireCategoriesManage, handler.CreateCategory)
+	categoryGroup.Put("/:id", requireCategoriesManage, handler.UpdateCategory)
+	categoryGroup.Delete("/:id", requireCategoriesManage, handler.DeleteCategory)
+}
+
+// GetCategories возвращает дерево всех категорий
+func (h *CategoryHandler) GetCategories(c *fiber.Ctx) error {
+	var categories []models.Category
+	if err := h.db.Order("name").Find(&categories).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve categories")
+	}
+
+	children := make(map[uuid.UUID][]models.Category)
+	var roots []models.Category
+	for _, category := range categories {
+		if category.ParentID == nil {
+			roots = append(roots, category)
+			continue
+		}
+		children[*category.ParentID] = append(children[*category.ParentID], category)
+	}
+
+	var build func(nodes []models.Category) []schemas.CategoryResponse
+	build = func(nodes []models.Category) []schemas.CategoryResponse {
+		response := make([]schemas.CategoryResponse, len(nodes))
+		for i, node := range nodes {
+			response[i] = toCategoryResponse(node, nil)
+			response[i].Children = build(children[node.ID])
+		}
+		return response
+	}
+
+	return c.JSON(build(roots))
+}
+
+// GetCategory возвращает категорию по ID или slug с путем от корня и прямыми потомками
+func (h *CategoryHandler) GetCategory(c *fiber.Ctx) error {
+	category, err := findCategory(h.db.Preload("Children", func(db *gorm.DB) *gorm.DB {
+		return db.Order("name")
+	}), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return h.categoryResponse(c, category, fiber.StatusOK)
+}
+
+// CreateCategory создает категорию, при необходимости внутри родительской
+func (h *CategoryHandler) CreateCategory(c *fiber.Ctx) error {
+	var input schemas.CategoryCreateRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	category := models.Category{
+		Name:        strings.TrimSpace(input.Name),
+		Description: input.Description,
+	}
+
+	if input.Slug != "" {
+		if !models.IsValidSlug(input.Slug) {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid slug")
+		}
+		if err := h.db.Where("slug = ?", input.Slug).First(&models.Category{}).Error; err == nil {
+			return fiber.NewError(fiber.StatusConflict, "slug already in use")
+		}
+		category.Slug = input.Slug
+	}
+
+	if input.Parent != nil && *input.Parent != "" {
+		parent, err := findCategory(h.db, *input.Parent)
+		if err != nil {
+			return err
+		}
+		category.ParentID = &parent.ID
+	}
+
+	if err := h.db.Create(&category).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not create category")
+	}
+
+	return h.categoryResponse(c, category, fiber.StatusCreated)
+}
+
+// UpdateCategory изменяет категорию; перенос внутрь собственного поддерева запрещен
+func (h *CategoryHandler) UpdateCategory(c *fiber.Ctx) error {
+	var input schemas.CategoryUpdateRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	category, err := findCategory(tx, c.Params("id"))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	updates := make(map[string]interface{})
+	if input.Name != nil {
+		category.Name = strings.TrimSpace(*input.Name)
+		updates["name"] = category.Name
+	}
+	if input.Description != nil {
+		category.Description = *input.Description
+		updates["description"] = category.Description
+	}
+
+	if input.Slug != nil && *input.Slug != category.Slug {
+		if !models.IsValidSlug(*input.Slug) {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusBadRequest, "invalid slug")
+		}
+		if err := tx.Where("slug = ?", *input.Slug).First(&models.Category{}).Error; err == nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusConflict, "slug already in use")
+		}
+		category.Slug = *input.Slug
+		updates["slug"] = category.Slug
+	}
+
+	if input.Parent != nil {
+		category.ParentID = nil
+		if *input.Parent != "" {
+			parent, err := findCategory(tx, *input.Parent)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+
+			var subtree []uuid.UUID
+			if err := tx.Raw(categorySubtreeQuery, category.ID).Scan(&subtree).Error; err != nil {
+				tx.Rollback()
+				return fiber.NewError(fiber.StatusInternalServerError, "could not update category")
+			}
+			for _, id := range subtree {
+				if id == parent.ID {
+					tx.Rollback()
+					return fiber.NewError(fiber.StatusConflict, "category cannot be moved into its own subtree")
+				}
+			}
+
+			category.ParentID = &parent.ID
+		}
+		updates["parent_id"] = category.ParentID
+	}
+
+	if len(updates) > 0 {
+		if err := tx.Model(&category).Updates(updates).Error; err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not update category")
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return h.categoryResponse(c, category, fiber.StatusOK)
+}
+
+// DeleteCategory удаляет категорию без потомков и отвязывает от нее товары
+func (h *CategoryHandler) DeleteCategory(c *fiber.Ctx) error {
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	category, err := findCategory(tx, c.Params("id"))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Where("parent_id = ?", category.ID).First(&models.Category{}).Error; err == nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusConflict, "category has subcategories")
+	}
+
+	if err := tx.Model(&category).Association("Products").Clear(); err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not delete category")
+	}
+
+	if err := tx.Delete(&category).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not delete category")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *CategoryHandler) categoryResponse(c *fiber.Ctx, category models.Category, status int) error {
+	paths, err := categoryPaths(h.db, []uuid.UUID{category.ID})
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve category")
+	}
+
+	response := toCategoryResponse(category, paths[category.ID])
+	for _, child := range category.Children {
+		response.Children = append(response.Children, toCategoryResponse(child, nil))
+	}
+
+	return c.Status(status).JSON(response)
+}
+
+// findCategory ищет категорию по ID или slug
+func findCategory(db *gorm.DB, ref string) (models.Category, error) {
+	var category models.Category
+
+	query := db.Where("slug = ?", ref)
+	if id, err := uuid.Parse(ref); err == nil {
+		query = db.Where("id = ?", id)
+	}
+
+	if err := query.First(&category).Error; err != nil {
+		return category, fiber.NewError(fiber.StatusNotFound, "category not found")
+	}
+
+	return category, nil
+}
+
+// findCategories ищет категории по списку ID и slug; неизвестная категория считается ошибкой запроса
+func findCategories(db *gorm.DB, refs []string) ([]models.Category, error) {
+	var ids []uuid.UUID
+	var slugs []string
+	for _, ref := range refs {
+		if id, err := uuid.Parse(ref); err == nil {
+			ids = append(ids, id)
+		} else {
+			slugs = append(slugs, ref)
+		}
+	}
+
+	var categories []models.Category
+	if len(refs) == 0 {
+		return categories, nil
+	}
+
+	if err := db.
+		Where("id IN ? OR slug IN ?", ids, slugs).
+		Find(&categories).
+		Error; err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "could not retrieve categories")
+	}
+
+	found := make(map[string]bool)
+	for _, category := range categories {
+		found[category.ID.String()] = true
+		found[category.Slug] = true
+	}
+	for _, ref := range refs {
+		if id, err := uuid.Parse(ref); err == nil {
+			ref = id.String()
+		}
+		if !found[ref] {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "unknown category: "+ref)
+		}
+	}
+
+	return categories, nil
+}
+
+// categoryPaths возвращает для каждой категории путь от корня дерева до нее самой
+func categoryPaths(db *gorm.DB, ids []uuid.UUID) (map[uuid.UUID][]schemas.CategorySummary, error) {
+	paths := make(map[uuid.UUID][]schemas.CategorySummary)
+	if len(ids) == 0 {
+		return paths, nil
+	}
+
+	var nodes []models.Category
+	if err := db.Raw(categoryAncestorsQuery, ids).Scan(&nodes).Error; err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uuid.UUID]models.Category, len(nodes))
+	for _, node := range nodes {
+		byID[node.ID] = node
+	}
+
+	for _, id := range ids {
+		var path []schemas.CategorySummary
+		for node, ok := byID[id]; ok; {
+			path = append([]schemas.CategorySummary{toCategorySummary(node)}, path...)
+			if node.ParentID == nil || len(path) > len(nodes) {
+				break
+			}
+			node, ok = byID[*node.ParentID]
+		}
+		if path != nil {
+			paths[id] = path
+		}
+	}
+
+	return paths, nil
+}
+
+// productBreadcrumb выбирает путь к самой глубокой из категорий товара, при равной глубине — по slug
+func productBreadcrumb(categories []models.Category, paths map[uuid.UUID][]schemas.CategorySummary) []schemas.CategorySummary {
+	candidates := make([][]schemas.CategorySummary, 0, len(categories))
+	for _, category := range categories {
+		if path, ok := paths[category.ID]; ok {
+			candidates = append(candidates, path)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return []schemas.CategorySummary{}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if len(candidates[i]) != len(candidates[j]) {
+			return len(candidates[i]) > len(candidates[j])
+		}
+		return candidates[i][len(candidates[i])-1].Slug < candidates[j][len(candidates[j])-1].Slug
+	})
+
+	return candidates[0]
+}
+
+func toCategorySummary(category models.Category) schemas.CategorySummary {
+	return schemas.CategorySummary{
+		ID:   category.ID,
+		Name: category.Name,
+		Slug: category.Slug,
+	}
+}
+
+func toCategoryResponse(category models.Category, breadcrumb []schemas.CategorySummary) schemas.CategoryResponse {
+	return schemas.CategoryResponse{
+		ID:          category.ID,
+		ParentID:    category.ParentID,
+		Name:        category.Name,
+		Slug:        category.Slug,
+		Description: category.Description,
+		Breadcrumb:  breadcrumb,
+		Children:    []schemas.CategoryResponse{},
+	}
+}
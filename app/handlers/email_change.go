@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"fusion/app/database/models"
+	"fusion/app/schemas"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm/clause"
+	"log"
+	"time"
+)
+
+const (
+	emailChangeType       = "EMAIL_CHANGE"
+	emailChangeCancelType = "EMAIL_CHANGE_CANCEL"
+)
+
+// requestEmailChange откладывает смену email до подтверждения с нового адреса
+// и предупреждает владельца старого адреса, отправляя ему ссылку отмены
+func (h UsersRoute) requestEmailChange(user models.User, newEmail, redirectURL, cancelURL string) error {
+	if !isAllowedRedirect(h.config, redirectURL) || !isAllowedRedirect(h.config, cancelURL) {
+		return fiber.NewError(fiber.StatusBadRequest, "redirect_url is not allowed")
+	}
+
+	var existing int64
+	if err := h.db.Model(&models.User{}).Where("email = ?", newEmail).Count(&existing).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not check email")
+	}
+
+	if existing > 0 {
+		return fiber.NewError(fiber.StatusConflict, "email already registered")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	// Действует только последний запрос на смену
+	if err := tx.Where("user_id = ? AND type = ?", user.ID, emailChangeType).Delete(&models.Verification{}).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not replace email change")
+	}
+
+	confirmToken := uuid.New().String()
+	cancelToken := uuid.New().String()
+	verifications := []models.Verification{
+		{
+			Type:      emailChangeType,
+			UserID:    user.ID,
+			Token:     confirmToken,
+			Target:    newEmail,
+			ExpiresAt: time.Now().Add(h.config.VerificationExpire),
+		},
+		{
+			Type:      emailChangeCancelType,
+			UserID:    user.ID,
+			Token:     cancelToken,
+			Target:    user.Email,
+			ExpiresAt: time.Now().Add(h.config.EmailCancelExpire),
+		},
+	}
+
+	if err := tx.Create(&verifications).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not create verification token")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	type ConfirmData struct{ URL string }
+	confirmData := ConfirmData{
+		URL: withQuery(redirectURL, "token", confirmToken),
+	}
+
+	if err := h.email.SendEmail(newEmail, "Confirm new email", "email_change_confirm", confirmData); err != nil {
+		log.Printf("could not send email change confirmation to user %s: %v", user.ID, err)
+		return fiber.NewError(fiber.StatusInternalServerError, "could not send confirmation email")
+	}
+
+	type NoticeData struct {
+		Email string
+		URL   string
+	}
+	noticeData := NoticeData{
+		Email: newEmail,
+		URL:   withQuery(cancelURL, "token", cancelToken),
+	}
+
+	if err := h.email.SendEmail(user.Email, "Email change requested", "email_change_notice", noticeData); err != nil {
+		log.Printf("could not send email change notice to user %s: %v", user.ID, err)
+		return fiber.NewError(fiber.StatusInternalServerError, "could not send notification email")
+	}
+
+	return nil
+}
+
+// confirmEmailChange применяет новый email по токену, отправленному на новый адрес
+func (h UsersRoute) confirmEmailChange(c *fiber.Ctx) error {
+	var input schemas.EmailTokenRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	var verification models.Verification
+	if err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token = ? AND type = ? AND expires_at > ?", input.Token, emailChangeType, time.Now()).
+		First(&verification).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusNotFound, "verification token not found or expired")
+	}
+
+	var existing int64
+	if err := tx.Model(&models.User{}).Where("email = ?", verification.Target).Count(&existing).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not check email")
+	}
+
+	if existing > 0 {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusConflict, "email already registered")
+	}
+
+	if err := tx.Model(&models.User{}).Where("id = ?", verification.UserID).Updates(map[string]interface{}{
+		"email":             verification.Target,
+		"is_email_verified": true,
+	}).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not change email")
+	}
+
+	if err := tx.Delete(&verification).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not delete verification token")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return c.JSON(fiber.Map{"message": "email changed"})
+}
+
+// cancelEmailChange отменяет ожидающую смену email по ссылке со старого адреса; если смена уже
+// подтверждена, возвращает старый email, завершает все сессии и отзывает API-ключи, так как учетная запись
+// могла быть захвачена
+func (h UsersRoute) cancelEmailChange(c *fiber.Ctx) error {
+	var input schemas.EmailTokenRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	var verification models.Verification
+	if err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token = ? AND type = ? AND expires_at > ?", input.Token, emailChangeCancelType, time.Now()).
+		Preload("User").
+		First(&verification).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusNotFound, "verification token not found or expired")
+	}
+
+	if err := tx.Where("user_id = ? AND type = ?", verification.UserID, emailChangeType).Delete(&models.Verification{}).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not cancel email change")
+	}
+
+	if verification.User.Email != verification.Target {
+		var existing int64
+		if err := tx.Model(&models.User{}).Where("email = ?", verification.Target).Count(&existing).Error; err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not check email")
+		}
+
+		if existing > 0 {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusConflict, "email already registered")
+		}
+
+		if err := tx.Model(&verification.User).Updates(map[string]interface{}{
+			"email":             verification.Target,
+			"is_email_verified": true,
+		}).Error; err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not restore email")
+		}
+
+		if err := tx.Model(&models.Session{}).
+			Where("user_id = ? AND is_active = ?", verification.UserID, true).
+			Update("is_active", false).Error; err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not revoke sessions")
+		}
+
+		// Ключи, выпущенные после захвата, дают доступ без сессии
+		if err := tx.Model(&models.ApiKey{}).
+			Where("user_id = ? AND revoked_at IS NULL", verification.UserID).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not revoke api keys")
+		}
+	}
+
+	if err := tx.Delete(&verification).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not delete verification token")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return c.JSON(fiber.Map{"message": "email change cancelled"})
+}
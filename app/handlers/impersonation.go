@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"fusion/app/database/models"
+	"fusion/app/schemas"
+	"fusion/app/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"time"
+)
+
+// ImpersonateUser выпускает короткоживущий access-токен пользователя для сотрудника поддержки.
+// Токен действует, пока активна сессия сотрудника, а все запросы с ним попадают в журнал.
+func (h *AdminRoute) ImpersonateUser(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	var input schemas.ImpersonateRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	admin := c.Locals("current_user").(models.User)
+	if admin.ID == parsedId {
+		return fiber.NewError(fiber.StatusBadRequest, "cannot impersonate yourself")
+	}
+
+	sessionID := c.Locals("current_session").(uuid.UUID)
+	if sessionID == uuid.Nil {
+		return fiber.NewError(fiber.StatusForbidden, "impersonation requires a user session")
+	}
+
+	var user models.User
+	if err := h.db.First(&user, parsedId).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "user not found")
+	}
+
+	if user.IsServiceAccount {
+		return fiber.NewError(fiber.StatusBadRequest, "cannot impersonate a service account")
+	}
+
+	accessToken, err := h.jwt.GenerateImpersonationToken(h.config.ImpersonateExpire, user.ID.String(), sessionID.String(), admin.ID.String())
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not generate access token")
+	}
+
+	entry := models.ImpersonationLog{
+		ActorID:   admin.ID,
+		UserID:    user.ID,
+		SessionID: sessionID,
+		Method:    c.Method(),
+		Path:      c.OriginalURL(),
+		Status:    fiber.StatusCreated,
+		IP:        c.IP(),
+		Reason:    input.Reason,
+	}
+
+	if err := h.db.Create(&entry).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not write impersonation log")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(schemas.ImpersonateResponse{
+		AccessToken: accessToken,
+		ExpiresAt:   time.Now().Add(h.config.ImpersonateExpire),
+	})
+}
+
+// GetImpersonationLog возвращает журнал действий от имени пользователей с фильтрами actor_id и user_id
+func (h *AdminRoute) GetImpersonationLog(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+
+	limit := c.QueryInt("limit", adminDefaultLimit)
+	if limit < 1 || limit > adminMaxLimit {
+		limit = adminDefaultLimit
+	}
+
+	query := h.db.Model(&models.ImpersonationLog{})
+	if actorID := c.Query("actor_id"); actorID != "" {
+		query = query.Where("actor_id = ?", actorID)
+	}
+
+	if userID := c.Query("user_id"); userID != "" {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not count impersonation log")
+	}
+
+	var entries []models.ImpersonationLog
+	if err := query.
+		Order("created_at desc").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&entries).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve impersonation log")
+	}
+
+	response := schemas.ImpersonationLogListResponse{
+		Entries: make([]schemas.ImpersonationLogResponse, len(entries)),
+		Total:   total,
+		Page:    page,
+		Limit:   limit,
+	}
+
+	for i, entry := range entries {
+		response.Entries[i] = schemas.ImpersonationLogResponse{
+			ID:        entry.ID,
+			ActorID:   entry.ActorID,
+			UserID:    entry.UserID,
+			Method:    entry.Method,
+			Path:      entry.Path,
+			Status:    entry.Status,
+			IP:        entry.IP,
+			Reason:    entry.Reason,
+			CreatedAt: entry.CreatedAt,
+		}
+	}
+
+	return c.JSON(response)
+}
@@ -0,0 +1,371 @@
+package handlers
+
+import (
+	"errors"
+	"fusion/app/database/models"
+	"fusion/app/schemas"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"time"
+)
+
+// Остатки товаров и вариантов меняются только записями складского журнала, остальное выводит триггер базы.
+// Любая запись в журнал делается под блокировкой строк товаров, поэтому проверка остатка и его изменение
+// атомарны и одновременные оформления заказов не могут продать больше, чем есть на складе.
+
+// stockSorts сортировки складского журнала
+var stockSorts = map[string]sortKey{
+	"created": {column: "created_at", cast: "timestamptz"},
+}
+
+// reservationBatch сколько просроченных резервов обрабатывается за один запуск
+const reservationBatch = 100
+
+// GetStockMovements возвращает страницу складского журнала товара; параметр variant ограничивает журнал вариантом
+func (h *ProductHandler) GetStockMovements(c *fiber.Ctx) error {
+	product, err := h.ownedProduct(h.db, c)
+	if err != nil {
+		return err
+	}
+
+	page, err := parseCursorPage(c, stockSorts, "-created")
+	if err != nil {
+		return err
+	}
+
+	query := h.db.Where("stock_movements.product_id = ?", product.ID)
+	if value := c.Query("variant"); value != "" {
+		variantID, err := uuid.Parse(value)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid variant")
+		}
+		query = query.Where("stock_movements.variant_id = ?", variantID)
+	}
+
+	var movements []models.StockMovement
+	if err := page.apply(query, "stock_movements").Find(&movements).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve stock movements")
+	}
+
+	movements, next := paginate(page, movements, func(movement models.StockMovement) (string, uuid.UUID) {
+		return cursorTime(movement.CreatedAt), movement.ID
+	})
+
+	items := make([]schemas.StockMovementResponse, len(movements))
+	for i, movement := range movements {
+		items[i] = toStockMovementResponse(movement)
+	}
+
+	return c.JSON(schemas.CursorPage[schemas.StockMovementResponse]{Items: items, NextCursor: next})
+}
+
+// RecordStockMovement записывает поступление или корректировку остатка товара или варианта
+func (h *ProductHandler) RecordStockMovement(c *fiber.Ctx) error {
+	user := c.Locals("current_user").(models.User)
+
+	var input schemas.StockMovementRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	if models.StockMovementKind(input.Kind) == models.StockReceipt && input.Quantity < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "receipt quantity must be positive")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	product, err := h.ownedProduct(tx.Clauses(clause.Locking{Strength: "UPDATE"}), c)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	stock := product.Stock
+	if input.VariantID != nil {
+		var variant models.Variant
+		if err := tx.Where("id = ? AND product_id = ?", *input.VariantID, product.ID).First(&variant).Error; err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusNotFound, "variant not found")
+		}
+		stock = variant.Stock
+	} else if err := tx.Where("product_id = ?", product.ID).First(&models.Variant{}).Error; err == nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusBadRequest, "variant is required")
+	}
+
+	if stock+input.Quantity < 0 {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusConflict, "stock cannot go negative")
+	}
+
+	movement := models.StockMovement{
+		ProductID: product.ID,
+		VariantID: input.VariantID,
+		Kind:      models.StockMovementKind(input.Kind),
+		Quantity:  input.Quantity,
+		Reason:    input.Reason,
+		ActorID:   &user.ID,
+	}
+	if err := tx.Create(&movement).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not record stock movement")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(toStockMovementResponse(movement))
+}
+
+// adjustStock записывает корректировку, после которой остаток current станет равен target.
+// Строка товара должна быть заблокирована.
+func adjustStock(tx *gorm.DB, productID uuid.UUID, variantID *uuid.UUID, current, target int, actorID uuid.UUID, reason string) error {
+	if current == target {
+		return nil
+	}
+
+	return tx.Create(&models.StockMovement{
+		ProductID: productID,
+		VariantID: variantID,
+		Kind:      models.StockAdjustment,
+		Quantity:  target - current,
+		Reason:    reason,
+		ActorID:   &actorID,
+	}).Error
+}
+
+// stockKey товар и вариант (uuid.Nil для товара без вариантов), по которым ведется остаток
+type stockKey struct {
+	product uuid.UUID
+	variant uuid.UUID
+}
+
+func orderLineStockKey(line models.OrderProduct) stockKey {
+	key := stockKey{product: line.ProductID}
+	if line.VariantID != nil {
+		key.variant = *line.VariantID
+	}
+
+	return key
+}
+
+// lockOrderProducts блокирует строки товаров заказа в порядке ID, чтобы одновременные операции
+// с пересекающимися заказами не взаимоблокировались
+func lockOrderProducts(tx *gorm.DB, lines []models.OrderProduct) ([]models.Product, error) {
+	seen := make(map[uuid.UUID]bool)
+	var ids []uuid.UUID
+	for _, line := range lines {
+		if !seen[line.ProductID] {
+			seen[line.ProductID] = true
+			ids = append(ids, line.ProductID)
+		}
+	}
+
+	var products []models.Product
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Unscoped().
+		Where("id IN ?", ids).
+		Order("id").
+		Find(&products).
+		Error
+
+	return products, err
+}
+
+// reserveOrderStock резервирует товары строк нового заказа, если их хватает на складе.
+// Скрытый или удаленный товар, удаленный вариант и товар, у которого после добавления в корзину
+// появились варианты, заказать нельзя.
+func reserveOrderStock(tx *gorm.DB, order models.Order, actorID uuid.UUID) error {
+	products, err := lockOrderProducts(tx, order.Products)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not reserve stock")
+	}
+
+	productIDs := make([]uuid.UUID, len(products))
+	visible := make(map[uuid.UUID]bool)
+	stock := make(map[stockKey]int)
+	for i, product := range products {
+		productIDs[i] = product.ID
+		if !product.DeletedAt.Valid && !product.IsHidden {
+			visible[product.ID] = true
+			stock[stockKey{product: product.ID}] = product.Stock
+		}
+	}
+
+	// Остаток товара с вариантами складывается из остатков вариантов, заказывается только вариант
+	var variants []models.Variant
+	if err := tx.Where("product_id IN ?", productIDs).Find(&variants).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not reserve stock")
+	}
+	for _, variant := range variants {
+		delete(stock, stockKey{product: variant.ProductID})
+		if visible[variant.ProductID] {
+			stock[stockKey{product: variant.ProductID, variant: variant.ID}] = variant.Stock
+		}
+	}
+
+	needed := make(map[stockKey]int)
+	for _, line := range order.Products {
+		needed[orderLineStockKey(line)] += line.Quantity
+	}
+
+	for _, line := range order.Products {
+		key := orderLineStockKey(line)
+		available, ok := stock[key]
+		if !ok {
+			return fiber.NewError(fiber.StatusConflict, "product is no longer available")
+		}
+		if available < needed[key] {
+			return fiber.NewError(fiber.StatusConflict, "not enough stock")
+		}
+	}
+
+	for _, line := range order.Products {
+		if err := tx.Create(&models.StockMovement{
+			ProductID: line.ProductID,
+			VariantID: line.VariantID,
+			OrderID:   &order.ID,
+			Kind:      models.StockReservation,
+			Quantity:  line.Quantity,
+			Reason:    "order placed",
+			ActorID:   &actorID,
+		}).Error; err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "could not reserve stock")
+		}
+	}
+
+	return nil
+}
+
+// settleOrderStock закрывает резерв заказа продажей или возвратом на склад. Строка заказа должна быть
+// заблокирована; заказ без резерва не меняется. actorID пуст, если резерв закрывает система.
+func settleOrderStock(tx *gorm.DB, order *models.Order, kind models.StockMovementKind, actorID *uuid.UUID, reason string) error {
+	if order.ReservedUntil == nil {
+		return nil
+	}
+
+	if err := recordOrderStock(tx, order.ID, kind, actorID, reason); err != nil {
+		return err
+	}
+
+	if err := tx.Model(order).Update("reserved_until", nil).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not update order")
+	}
+	order.ReservedUntil = nil
+
+	return nil
+}
+
+// recordOrderStock записывает в журнал движение вида kind по каждой строке заказа
+func recordOrderStock(tx *gorm.DB, orderID uuid.UUID, kind models.StockMovementKind, actorID *uuid.UUID, reason string) error {
+	var lines []models.OrderProduct
+	if err := tx.Where("order_id = ?", orderID).Find(&lines).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve order products")
+	}
+
+	if _, err := lockOrderProducts(tx, lines); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not update stock")
+	}
+
+	for _, line := range lines {
+		if err := tx.Create(&models.StockMovement{
+			ProductID: line.ProductID,
+			VariantID: line.VariantID,
+			OrderID:   &orderID,
+			Kind:      kind,
+			Quantity:  line.Quantity,
+			Reason:    reason,
+			ActorID:   actorID,
+		}).Error; err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "could not update stock")
+		}
+	}
+
+	return nil
+}
+
+// ReleaseExpiredReservations отменяет неоплаченные заказы с истекшим резервом и возвращает товары на склад.
+// Заказы, которые в этот момент меняет другой запрос, пропускаются до следующего запуска.
+func ReleaseExpiredReservations(db *gorm.DB) (int, error) {
+	unpaid := []models.OrderStatus{models.CREATED, models.STAGING}
+
+	var ids []uuid.UUID
+	if err := db.Model(&models.Order{}).
+		Where("reserved_until < ? AND status IN ?", time.Now(), unpaid).
+		Order("reserved_until").
+		Limit(reservationBatch).
+		Pluck("id", &ids).
+		Error; err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, id := range ids {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var order models.Order
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("id = ? AND reserved_until < ? AND status IN ?", id, time.Now(), unpaid).
+				First(&order).
+				Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return nil
+				}
+				return err
+			}
+
+			if err := transitionOrder(tx, &order, models.CANCELLED, models.OrderActorSystem, nil, "reservation expired"); err != nil {
+				return err
+			}
+
+			released++
+			return nil
+		})
+		if err != nil {
+			return released, err
+		}
+	}
+
+	return released, nil
+}
+
+// StartReservationExpiry периодически возвращает на склад просроченные резервы
+func StartReservationExpiry(db *gorm.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			released, err := ReleaseExpiredReservations(db)
+			if err != nil {
+				log.Printf("could not release expired reservations: %v", err)
+			} else if released > 0 {
+				log.Printf("released %d expired reservations", released)
+			}
+		}
+	}()
+}
+
+func toStockMovementResponse(movement models.StockMovement) schemas.StockMovementResponse {
+	return schemas.StockMovementResponse{
+		ID:        movement.ID,
+		ProductID: movement.ProductID,
+		VariantID: movement.VariantID,
+		OrderID:   movement.OrderID,
+		Kind:      string(movement.Kind),
+		Quantity:  movement.Quantity,
+		Reason:    movement.Reason,
+		ActorID:   movement.ActorID,
+		CreatedAt: movement.CreatedAt,
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"fusion/app/database/models"
+	"fusion/app/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm/clause"
+	"log"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	magicLinkType = "MAGIC_LINK"
+	// magicLinkKeyPrefix отделяет счетчики запросов ссылок от счетчиков попыток входа по паролю
+	magicLinkKeyPrefix = "magic_link:"
+)
+
+// RequestMagicLink отправляет на почту одноразовую ссылку для входа без пароля. Запросы ограничиваются
+// по адресу и по IP так же, как попытки входа, но отдельными счетчиками.
+func (h AuthRoute) RequestMagicLink(c *fiber.Ctx) error {
+	type MagicLinkInput struct {
+		Email       string `json:"email" validate:"required,email"`
+		RedirectUrl string `json:"redirect_url" validate:"required"`
+	}
+
+	var input MagicLinkInput
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	if !isAllowedRedirect(h.config, input.RedirectUrl) {
+		return fiber.NewError(fiber.StatusBadRequest, "redirect_url is not allowed")
+	}
+
+	// Ограничение действует и для несуществующих адресов, иначе по нему можно было бы их перебирать
+	accountKey := magicLinkKeyPrefix + utils.AccountKey(input.Email)
+	ipKey := magicLinkKeyPrefix + utils.IPKey(c.IP())
+	wait, err := h.guard.Wait(accountKey, ipKey)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not check sign-in link requests")
+	}
+
+	if wait > 0 {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return fiber.NewError(fiber.StatusTooManyRequests, "too many sign-in link requests, try again later")
+	}
+
+	if err := h.guard.Record(accountKey, h.config.LoginMaxAttempts); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not record sign-in link request")
+	}
+	if err := h.guard.Record(ipKey, h.config.LoginIPMaxAttempts); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not record sign-in link request")
+	}
+
+	// Ответ не зависит от того, существует ли пользователь, чтобы по нему нельзя было перебирать адреса
+	response := fiber.Map{"message": "if the account exists, a sign-in link has been sent"}
+
+	var user models.User
+	if err := h.db.Where("email = ? AND is_email_verified = ?", input.Email, true).First(&user).Error; err != nil {
+		return c.JSON(response)
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	// Действует только последняя запрошенная ссылка
+	if err := tx.Where("user_id = ? AND type = ?", user.ID, magicLinkType).Delete(&models.Verification{}).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not replace magic link")
+	}
+
+	token := uuid.New().String()
+	verification := models.Verification{
+		Type:      magicLinkType,
+		UserID:    user.ID,
+		Token:     token,
+		ExpiresAt: time.Now().Add(h.config.MagicLinkExpire),
+	}
+
+	if err := tx.Create(&verification).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not create magic link")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	type MagicLinkData struct{ URL string }
+	data := MagicLinkData{
+		URL: withQuery(input.RedirectUrl, "token", token),
+	}
+
+	if err := h.email.SendEmail(user.Email, "Sign in to Fusion", "magic_link", data); err != nil {
+		log.Printf("could not send magic link to user %s: %v", user.ID, err)
+		return fiber.NewError(fiber.StatusInternalServerError, "could not send magic link")
+	}
+
+	return c.JSON(response)
+}
+
+// ConsumeMagicLink гасит одноразовую ссылку и выполняет вход, как Login
+func (h AuthRoute) ConsumeMagicLink(c *fiber.Ctx) error {
+	type ConsumeInput struct {
+		Token string `json:"token" validate:"required"`
+	}
+
+	var input ConsumeInput
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	var verification models.Verification
+	if err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token = ? AND type = ? AND expires_at > ?", input.Token, magicLinkType, time.Now()).
+		First(&verification).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusUnauthorized, "magic link not found or expired")
+	}
+
+	var user models.User
+	if err := tx.First(&user, verification.UserID).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+
+	if err := tx.Delete(&verification).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not delete magic link")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return h.completeLogin(c, user)
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"fusion/app/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestMagicLinkThrottle(t *testing.T) {
+	// Запрос из app.Test приходит с адреса 0.0.0.0
+	tests := []struct {
+		name     string
+		recorded string
+	}{
+		{"throttled by address", magicLinkKeyPrefix + utils.AccountKey("User@Example.com")},
+		{"throttled by ip", magicLinkKeyPrefix + utils.IPKey("0.0.0.0")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := utils.NewLoginGuard(utils.NewMemoryAttemptStore(), utils.LoginPolicy{
+				MaxAccountAttempts: 5,
+				MaxIPAttempts:      50,
+				Backoff:            time.Minute,
+				Lockout:            15 * time.Minute,
+			})
+			if err := guard.Record(tt.recorded, 5); err != nil {
+				t.Fatal(err)
+			}
+
+			app := fiber.New()
+			handler := AuthRoute{
+				config:   utils.AppConfig{AllowedRedirects: "https://shop.example.com/"},
+				guard:    guard,
+				validate: validator.New(),
+			}
+			app.Post("/auth/magic-link", handler.RequestMagicLink)
+
+			request := httptest.NewRequest("POST", "/auth/magic-link", strings.NewReader(`{"email":"user@example.com","redirect_url":"https://shop.example.com/login"}`))
+			request.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			response, err := app.Test(request, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != fiber.StatusTooManyRequests {
+				t.Errorf("status = %d, want %d", response.StatusCode, fiber.StatusTooManyRequests)
+			}
+			if response.Header.Get(fiber.HeaderRetryAfter) == "" {
+				t.Error("Retry-After is not set")
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"errors"
+	"fusion/app/database/models"
+	"fusion/app/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	oauthLoginType   = "OAUTH_LOGIN"
+	oauthLoginExpire = time.Minute
+)
+
+var usernameUnsafeChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// StartOAuth перенаправляет пользователя на страницу входа внешнего провайдера
+func (h AuthRoute) StartOAuth(c *fiber.Ctx) error {
+	name := c.Params("provider")
+	provider, ok := h.providers[name]
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "identity provider not found")
+	}
+
+	redirectURL := c.Query("redirect_url")
+	if !isAllowedRedirect(h.config, redirectURL) {
+		return fiber.NewError(fiber.StatusBadRequest, "redirect_url is not allowed")
+	}
+
+	state := models.OAuthState{
+		State:        uuid.New().String(),
+		Provider:     name,
+		Nonce:        uuid.New().String(),
+		CodeVerifier: utils.GenerateOAuthVerifier(),
+		RedirectURL:  redirectURL,
+		ExpiresAt:    time.Now().Add(h.config.OidcStateExpire),
+	}
+
+	authURL, err := provider.AuthCodeURL(c.UserContext(), state.State, state.Nonce, state.CodeVerifier)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadGateway, "identity provider unavailable")
+	}
+
+	if err := h.db.Create(&state).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not save oauth state")
+	}
+
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// OAuthCallback принимает ответ провайдера и возвращает клиенту одноразовый код входа
+func (h AuthRoute) OAuthCallback(c *fiber.Ctx) error {
+	name := c.Params("provider")
+	provider, ok := h.providers[name]
+	if !ok {
+		return fiber.NewError(fiber.StatusNotFound, "identity provider not found")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	var state models.OAuthState
+	if err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("state = ? AND provider = ? AND expires_at > ?", c.Query("state"), name, time.Now()).
+		First(&state).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusBadRequest, "oauth state not found or expired")
+	}
+
+	if err := tx.Delete(&state).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not delete oauth state")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	if providerError := c.Query("error"); providerError != "" {
+		return c.Redirect(withQuery(state.RedirectURL, "error", providerError), fiber.StatusFound)
+	}
+
+	identity, err := provider.Exchange(c.UserContext(), c.Query("code"), state.Nonce, state.CodeVerifier)
+	if err != nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "could not verify external identity")
+	}
+
+	tx = h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	user, err := resolveExternalUser(tx, name, identity)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	token := uuid.New().String()
+	verification := models.Verification{
+		Type:      oauthLoginType,
+		UserID:    user.ID,
+		Token:     token,
+		ExpiresAt: time.Now().Add(oauthLoginExpire),
+	}
+
+	if err := tx.Create(&verification).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not create login code")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return c.Redirect(withQuery(state.RedirectURL, "code", token), fiber.StatusFound)
+}
+
+// ExchangeOAuthCode обменивает одноразовый код входа на токены, как Login
+func (h AuthRoute) ExchangeOAuthCode(c *fiber.Ctx) error {
+	type ExchangeInput struct {
+		Code string `json:"code" validate:"required"`
+	}
+
+	var input ExchangeInput
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	var verification models.Verification
+	if err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token = ? AND type = ? AND expires_at > ?", input.Code, oauthLoginType, time.Now()).
+		First(&verification).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusUnauthorized, "login code not found or expired")
+	}
+
+	var user models.User
+	if err := tx.First(&user, verification.UserID).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+
+	if err := tx.Delete(&verification).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not delete login code")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return h.completeLogin(c, user)
+}
+
+// resolveExternalUser находит пользователя по привязанной учетной записи провайдера,
+// привязывает ее к существующему пользователю с тем же подтвержденным email или создает нового
+func resolveExternalUser(tx *gorm.DB, provider string, identity *utils.ExternalIdentity) (models.User, error) {
+	var user models.User
+
+	var link models.ExternalIdentity
+	err := tx.Where("provider = ? AND subject = ?", provider, identity.Subject).First(&link).Error
+	if err == nil {
+		if err := tx.First(&user, link.UserID).Error; err != nil {
+			return user, fiber.NewError(fiber.StatusUnauthorized, "user not found")
+		}
+		return user, nil
+	}
+
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, fiber.NewError(fiber.StatusInternalServerError, "could not retrieve identity")
+	}
+
+	if identity.Email == "" {
+		return user, fiber.NewError(fiber.StatusBadRequest, "identity provider did not share an email address")
+	}
+
+	err = tx.Where("email = ?", identity.Email).First(&user).Error
+	switch {
+	case err == nil:
+		if !identity.EmailVerified {
+			return user, fiber.NewError(fiber.StatusConflict, "email already registered")
+		}
+
+		// Неподтвержденную учетную запись мог заранее создать кто угодно: сбрасываем
+		// ее пароль, чтобы доступ остался только у владельца почты.
+		if !user.IsEmailVerified {
+			if err := tx.Model(&user).Updates(map[string]interface{}{
+				"is_email_verified": true,
+				"password":          "",
+			}).Error; err != nil {
+				return user, fiber.NewError(fiber.StatusInternalServerError, "could not update user")
+			}
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		user = models.User{
+			Username:        generateUsername(identity.Email),
+			Email:           identity.Email,
+			IsEmailVerified: identity.EmailVerified,
+		}
+
+		if err := tx.Create(&user).Error; err != nil {
+			return user, fiber.NewError(fiber.StatusInternalServerError, "could not create user")
+		}
+	default:
+		return user, fiber.NewError(fiber.StatusInternalServerError, "could not retrieve user")
+	}
+
+	link = models.ExternalIdentity{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}
+
+	if err := tx.Create(&link).Error; err != nil {
+		return user, fiber.NewError(fiber.StatusInternalServerError, "could not link identity")
+	}
+
+	return user, nil
+}
+
+// generateUsername строит уникальное имя пользователя из локальной части email
+func generateUsername(email string) string {
+	base := usernameUnsafeChars.ReplaceAllString(strings.Split(email, "@")[0], "")
+	if len(base) < 3 {
+		base = "user"
+	}
+	if len(base) > 20 {
+		base = base[:20]
+	}
+
+	return base + "-" + strings.ReplaceAll(uuid.New().String(), "-", "")[:8]
+}
+
+// withQuery добавляет параметр к адресу
+func withQuery(rawURL, key, value string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	query := parsed.Query()
+	query.Set(key, value)
+	parsed.RawQuery = query.Encode()
+
+	return parsed.String()
+}
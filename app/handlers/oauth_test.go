@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fusion/app/database/models"
+	"fusion/app/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+// stubIdentityProvider выдает заданную учетную запись только в обмен на код, nonce и code_verifier,
+// переданные в адресе авторизации, как это делает провайдер с PKCE
+type stubIdentityProvider struct {
+	identity utils.ExternalIdentity
+	nonce    string
+	verifier string
+}
+
+func (p *stubIdentityProvider) AuthCodeURL(_ context.Context, state, nonce, verifier string) (string, error) {
+	p.nonce = nonce
+	p.verifier = verifier
+	return "https://idp.example.com/authorize?state=" + url.QueryEscape(state), nil
+}
+
+func (p *stubIdentityProvider) Exchange(_ context.Context, code, nonce, verifier string) (*utils.ExternalIdentity, error) {
+	if code != "code" || nonce != p.nonce || verifier != p.verifier {
+		return nil, errors.New("invalid grant")
+	}
+	identity := p.identity
+	return &identity, nil
+}
+
+// testDB подключается к базе из TEST_DATABASE_DSN; без нее тест пропускается
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(`CREATE EXTENSION IF NOT EXISTS "uuid-ossp"`).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Verification{}, &models.ExternalIdentity{}, &models.OAuthState{}, &models.Order{}, &models.Payment{}); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestOAuthCallback(t *testing.T) {
+	db := testDB(t)
+
+	tests := []struct {
+		name          string
+		userVerified  bool
+		emailVerified bool
+		status        int
+		linked        bool
+	}{
+		{"verified email links existing user", true, true, fiber.StatusFound, true},
+		{"verified email claims unverified user", false, true, fiber.StatusFound, true},
+		{"unverified email does not link", true, false, fiber.StatusConflict, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suffix := uuid.NewString()[:8]
+			user := models.User{
+				Username:        "oauth-" + suffix,
+				Email:           "oauth-" + suffix + "@example.com",
+				Password:        "hash",
+				IsEmailVerified: tt.userVerified,
+			}
+			if err := db.Create(&user).Error; err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				db.Where("user_id = ?", user.ID).Delete(&models.ExternalIdentity{})
+				db.Where("user_id = ?", user.ID).Delete(&models.Verification{})
+				db.Delete(&user)
+			})
+
+			provider := &stubIdentityProvider{identity: utils.ExternalIdentity{
+				Subject:       "subject-" + suffix,
+				Email:         user.Email,
+				EmailVerified: tt.emailVerified,
+			}}
+
+			app := fiber.New()
+			handler := AuthRoute{
+				config: utils.AppConfig{
+					AllowedRedirects: "https://shop.example.com/",
+					OidcStateExpire:  time.Minute,
+				},
+				providers: map[string]utils.IdentityProvider{"test": provider},
+				db:        db,
+			}
+			app.Get("/auth/oauth/:provider", handler.StartOAuth)
+			app.Get("/auth/oauth/:provider/callback", handler.OAuthCallback)
+
+			response, err := app.Test(httptest.NewRequest("GET", "/auth/oauth/test?redirect_url="+url.QueryEscape("https://shop.example.com/login"), nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != fiber.StatusFound {
+				t.Fatalf("start status = %d, want %d", response.StatusCode, fiber.StatusFound)
+			}
+			location, err := url.Parse(response.Header.Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			state := location.Query().Get("state")
+
+			callback := "/auth/oauth/test/callback?code=code&state=" + url.QueryEscape(state)
+
+			// Состояние, выданное другим запросом, не принимается
+			response, err = app.Test(httptest.NewRequest("GET", "/auth/oauth/test/callback?code=code&state="+uuid.NewString(), nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("unknown state status = %d, want %d", response.StatusCode, fiber.StatusBadRequest)
+			}
+
+			response, err = app.Test(httptest.NewRequest("GET", callback, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != tt.status {
+				t.Fatalf("callback status = %d, want %d", response.StatusCode, tt.status)
+			}
+
+			// Состояние одноразовое
+			response, err = app.Test(httptest.NewRequest("GET", callback, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("reused state status = %d, want %d", response.StatusCode, fiber.StatusBadRequest)
+			}
+
+			var links int64
+			db.Model(&models.ExternalIdentity{}).Where("user_id = ? AND provider = ?", user.ID, "test").Count(&links)
+			if linked := links == 1; linked != tt.linked {
+				t.Errorf("linked = %v, want %v", linked, tt.linked)
+			}
+
+			var stored models.User
+			if err := db.First(&stored, user.ID).Error; err != nil {
+				t.Fatal(err)
+			}
+			// Пароль неподтвержденной учетной записи сбрасывается при привязке
+			if wantPassword := tt.userVerified || !tt.linked; (stored.Password != "") != wantPassword {
+				t.Errorf("password kept = %v, want %v", stored.Password != "", wantPassword)
+			}
+		})
+	}
+}
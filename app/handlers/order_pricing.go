@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"fusion/app/database/models"
+	"fusion/app/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// priceOrder копирует в строки нового заказа цену, название, артикул и изображение товара или варианта
+// и рассчитывает итоги заказа. Вызывается до резервирования, которое проверяет доступность товаров.
+func priceOrder(tx *gorm.DB, order *models.Order, pricing utils.PricingPolicy) error {
+	products, err := lockOrderProducts(tx, order.Products)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not price order")
+	}
+
+	productsByID := make(map[uuid.UUID]models.Product, len(products))
+	for _, product := range products {
+		productsByID[product.ID] = product
+	}
+
+	var variantIDs []uuid.UUID
+	for _, line := range order.Products {
+		if line.VariantID != nil {
+			variantIDs = append(variantIDs, *line.VariantID)
+		}
+	}
+
+	variantsByID := make(map[uuid.UUID]models.Variant)
+	if len(variantIDs) > 0 {
+		var variants []models.Variant
+		if err := tx.Where("id IN ?", variantIDs).Find(&variants).Error; err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "could not price order")
+		}
+		for _, variant := range variants {
+			variantsByID[variant.ID] = variant
+		}
+	}
+
+	var subtotal int64
+	for i := range order.Products {
+		line := &order.Products[i]
+
+		product, ok := productsByID[line.ProductID]
+		if !ok {
+			return fiber.NewError(fiber.StatusConflict, "product is no longer available")
+		}
+
+		line.UnitPrice = product.Price
+		line.ProductName = product.Name
+		line.Image = product.Image
+		if line.VariantID != nil {
+			variant, ok := variantsByID[*line.VariantID]
+			if !ok {
+				return fiber.NewError(fiber.StatusConflict, "variant is no longer available")
+			}
+
+			line.UnitPrice = variant.EffectivePrice(product)
+			line.SKU = variant.SKU
+			if variant.Image != nil {
+				line.Image = variant.Image
+			}
+		}
+
+		line.Currency = pricing.Currency
+		line.Total = utils.LineTotal(line.UnitPrice, line.Quantity)
+		subtotal += utils.ToCents(line.Total)
+	}
+
+	// Скидки пока не реализованы: источника скидок (промокодов, акций) нет, поэтому Discount всегда 0
+	totals := pricing.Totals(utils.FromCents(subtotal), 0)
+	order.Currency = pricing.Currency
+	order.Subtotal = totals.Subtotal
+	order.Discount = totals.Discount
+	order.Tax = totals.Tax
+	order.Shipping = totals.Shipping
+	order.GrandTotal = totals.GrandTotal
+
+	return nil
+}
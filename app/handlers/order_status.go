@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"fmt"
+	"fusion/app/database/models"
+	"fusion/app/schemas"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// transitionOrder переводит заблокированный заказ в статус to по таблице models.OrderTransitions,
+// применяет переход к складу и записывает событие в историю заказа. Несуществующий переход — 409,
+// переход, который не разрешен стороне actor, — 403.
+func transitionOrder(tx *gorm.DB, order *models.Order, to models.OrderStatus, actor models.OrderActor, actorID *uuid.UUID, note string) error {
+	from := order.Status
+
+	exists, allowed := models.OrderTransitionAllowed(from, to, actor)
+	if !exists {
+		return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("order cannot move from %s to %s", from, to))
+	}
+	if !allowed {
+		return fiber.NewError(fiber.StatusForbidden, fmt.Sprintf("%s cannot move order from %s to %s", actor, from, to))
+	}
+
+	reason := note
+	if reason == "" {
+		reason = "order " + to.String()
+	}
+
+	var err error
+	switch {
+	case to == models.CANCELLED:
+		err = settleOrderStock(tx, order, models.StockRelease, actorID, reason)
+	case to == models.BILLED:
+		err = settleOrderStock(tx, order, models.StockSale, actorID, reason)
+	case to == models.REFUNDED && from == models.BILLED:
+		// Оплаченный, но не отправленный заказ не покидал склад
+		err = recordOrderStock(tx, order.ID, models.StockReceipt, actorID, reason)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Model(order).Update("status", to).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not update order status")
+	}
+	order.Status = to
+
+	event := models.OrderEvent{
+		OrderID:    order.ID,
+		FromStatus: from,
+		ToStatus:   to,
+		Actor:      actor,
+		ActorID:    actorID,
+		Note:       note,
+	}
+	if err := tx.Create(&event).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not record order event")
+	}
+
+	return nil
+}
+
+// orderEvents возвращает историю заказа по порядку
+func orderEvents(db *gorm.DB, orderID uuid.UUID) ([]schemas.OrderEventResponse, error) {
+	var events []models.OrderEvent
+	if err := db.Where("order_id = ?", orderID).Order("created_at, id").Find(&events).Error; err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "could not retrieve order events")
+	}
+
+	responses := make([]schemas.OrderEventResponse, len(events))
+	for i, event := range events {
+		responses[i] = schemas.OrderEventResponse{
+			ID:         event.ID,
+			FromStatus: event.FromStatus.String(),
+			ToStatus:   event.ToStatus.String(),
+			Actor:      string(event.Actor),
+			ActorID:    event.ActorID,
+			Note:       event.Note,
+			CreatedAt:  event.CreatedAt,
+		}
+	}
+
+	return responses, nil
+}
@@ -5,29 +5,43 @@ import (
 	"fusion/app/middleware"
 	"fusion/app/schemas"
 	"fusion/app/utils"
+	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
 )
 
 type OrderHandler struct {
-	db *gorm.DB
+	db       *gorm.DB
+	config   utils.AppConfig
+	pricing  utils.PricingPolicy
+	validate *validator.Validate
 }
 
 // NewOrderHandler создает новый обработчик для заказов
-func NewOrderHandler(db *gorm.DB) *OrderHandler {
-	return &OrderHandler{db: db}
+func NewOrderHandler(db *gorm.DB, config utils.AppConfig) *OrderHandler {
+	pricing := utils.PricingPolicy{
+		Currency:         config.Currency,
+		TaxRate:          config.TaxRate,
+		ShippingFee:      config.ShippingFee,
+		FreeShippingFrom: config.FreeShippingFrom,
+	}
+
+	return &OrderHandler{db: db, config: config, pricing: pricing, validate: validator.New()}
 }
 
 // RegisterOrderRoutes регистрирует маршруты для заказов
-func RegisterOrderRoutes(app *fiber.App, db *gorm.DB) {
-	handler := NewOrderHandler(db)
+func RegisterOrderRoutes(app *fiber.App, db *gorm.DB, config utils.AppConfig) {
+	handler := NewOrderHandler(db, config)
 
 	orderGroup := app.Group("/orders")
 
-	orderGroup.Use(middleware.AuthMiddleware())
+	orderGroup.Use(middleware.AuthMiddleware(middleware.ApiKeyScope(models.ScopeOrders)), middleware.IdempotencyMiddleware())
 	orderGroup.Get("/", handler.GetOrders)
 	orderGroup.Post("/", handler.CreateOrder)
+	orderGroup.Get("/:id/events", handler.GetOrderEvents)
 	orderGroup.Put("/:id", handler.UpdateOrderStatus)
 	orderGroup.Delete("/:id", handler.DeleteOrder)
 }
@@ -36,15 +50,22 @@ func RegisterOrderRoutes(app *fiber.App, db *gorm.DB) {
 func (h *OrderHandler) GetOrders(c *fiber.Ctx) error {
 	user := c.Locals("current_user").(models.User)
 
+	page, err := parseCursorPage(c, orderSorts, "-created")
+	if err != nil {
+		return err
+	}
+
 	var orders []models.Order
-	if err := h.db.Preload("Products").Where("user_id = ?", user.ID).Find(&orders).Error; err != nil {
+	query := h.db.Preload("Products").Where("orders.user_id = ?", user.ID)
+	if err := page.apply(query, "orders").Find(&orders).Error; err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve orders")
 	}
 
-	return c.JSON(orders)
+	return c.JSON(orderPage(page, orders))
 }
 
-// CreateOrder создает новый заказ из выбранных товаров корзины
+// CreateOrder создает новый заказ из выбранных товаров корзины по текущим ценам и резервирует их
+// на складе до оплаты; если товара не хватает, заказ не создается
 func (h *OrderHandler) CreateOrder(c *fiber.Ctx) error {
 	user := c.Locals("current_user").(models.User)
 
@@ -54,99 +75,221 @@ func (h *OrderHandler) CreateOrder(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
 	}
 
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
 	// Проверяем наличие корзины для текущего пользователя
 	var cart models.Cart
-	if err := h.db.Preload("Products").Where("user_id = ?", user.ID).First(&cart).Error; err != nil {
+	if err := tx.Where("user_id = ?", user.ID).First(&cart).Error; err != nil {
+		tx.Rollback()
 		return fiber.NewError(fiber.StatusNotFound, "cart not found")
 	}
 
-	// Создаем новый заказ
-	order := models.Order{
-		UserID: user.ID,
-		Status: models.CREATED, // Или другой статус по умолчанию
-	}
-	if err := h.db.Create(&order).Error; err != nil {
-		return fiber.NewError(fiber.StatusInternalServerError, "could not create order")
+	// Строки корзины блокируются до конца транзакции, чтобы параллельное оформление
+	// не превратило одни и те же строки в два заказа
+	if err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("cart_id = ?", cart.ID).
+		Find(&cart.Products).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve cart products")
 	}
 
-	var orderProducts []models.CartProduct
+	var orderProducts []models.OrderProduct
 	var productsToRemove []uuid.UUID
+	selected := make(map[uuid.UUID]bool)
 	for _, selectedProduct := range input.CartProductResponse {
 		for _, cartProduct := range cart.Products {
-			if cartProduct.ProductID.String() == selectedProduct.ID {
-				orderProduct := models.CartProduct{
-					CartID:    order.ID,
+			if !selected[cartProduct.ID] && cartLineSelected(cartProduct, selectedProduct) {
+				selected[cartProduct.ID] = true
+				orderProducts = append(orderProducts, models.OrderProduct{
 					ProductID: cartProduct.ProductID,
+					VariantID: cartProduct.VariantID,
 					Quantity:  cartProduct.Quantity,
-				}
-
-				orderProducts = append(orderProducts, orderProduct)
+				})
 				productsToRemove = append(productsToRemove, cartProduct.ID)
 				break
 			}
 		}
 	}
 
-	if err := h.db.Model(&order).Association("Products").Append(orderProducts); err != nil {
-		return fiber.NewError(fiber.StatusInternalServerError, "could not add products to order")
+	if len(orderProducts) == 0 {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusBadRequest, "no cart products selected")
+	}
+
+	// Создаем новый заказ
+	reservedUntil := time.Now().Add(h.config.ReservationExpire)
+	order := models.Order{
+		UserID:        user.ID,
+		Status:        models.CREATED,
+		ReservedUntil: &reservedUntil,
+		Products:      orderProducts,
+	}
+
+	// Цены и итоги фиксируются при оформлении и дальше не пересчитываются
+	if err := priceOrder(tx, &order, h.pricing); err != nil {
+		tx.Rollback()
+		return err
 	}
 
-	if err := h.db.Where("id IN ?", productsToRemove).Delete(&models.CartProduct{}).Error; err != nil {
+	if err := tx.Create(&order).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not create order")
+	}
+
+	if err := reserveOrderStock(tx, order, user.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	removed := tx.Where("id IN ?", productsToRemove).Delete(&models.CartProduct{})
+	if removed.Error != nil {
+		tx.Rollback()
 		return fiber.NewError(fiber.StatusInternalServerError, "could not remove products from cart")
 	}
+	if removed.RowsAffected != int64(len(productsToRemove)) {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusConflict, "cart has changed, try again")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
 
 	response := schemas.CreateOrderResponse{
-		ID:           order.ID.String(),
-		CartProducts: orderProducts,
-		UserID:       order.UserID.String(),
-		Status:       int(order.Status),
+		ID:            order.ID.String(),
+		Products:      order.Products,
+		UserID:        order.UserID.String(),
+		Status:        int(order.Status),
+		ReservedUntil: order.ReservedUntil,
+		Currency:      order.Currency,
+		Subtotal:      order.Subtotal,
+		Discount:      order.Discount,
+		Tax:           order.Tax,
+		Shipping:      order.Shipping,
+		GrandTotal:    order.GrandTotal,
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(response)
 }
 
-// UpdateOrderStatus обновляет статус заказа по ID
+// UpdateOrderStatus меняет статус заказа покупателем по таблице переходов: покупатель может подтвердить
+// заказ, отменить его до оплаты и принять доставленный заказ
 func (h *OrderHandler) UpdateOrderStatus(c *fiber.Ctx) error {
-	parsedId, err := utils.ParseRouteID(c)
+	var input schemas.OrderStatusRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	order, err := h.transitionOwnOrder(c, input.Status, input.Note)
 	if err != nil {
 		return err
 	}
 
-	user := c.Locals("current_user").(models.User)
-	var order models.Order
+	return c.JSON(order)
+}
 
-	if err := h.db.Where("id = ? AND user_id = ?", parsedId, user.ID).First(&order).Error; err != nil {
-		return fiber.NewError(fiber.StatusNotFound, "order not found or access denied")
+// DeleteOrder отменяет заказ по ID; история заказа сохраняется
+func (h *OrderHandler) DeleteOrder(c *fiber.Ctx) error {
+	if _, err := h.transitionOwnOrder(c, models.CANCELLED, ""); err != nil {
+		return err
 	}
 
-	type UpdateStatusInput struct {
-		Status models.OrderStatus `json:"status"`
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetOrderEvents возвращает историю статусов заказа текущего пользователя
+func (h *OrderHandler) GetOrderEvents(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
 	}
 
-	var input UpdateStatusInput
-	if err := c.BodyParser(&input); err != nil {
-		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	user := c.Locals("current_user").(models.User)
+	if err := h.db.Where("id = ? AND user_id = ?", parsedId, user.ID).First(&models.Order{}).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "order not found or access denied")
 	}
 
-	order.Status = input.Status
-	if err := h.db.Save(&order).Error; err != nil {
-		return fiber.NewError(fiber.StatusInternalServerError, "could not update order status")
+	events, err := orderEvents(h.db, parsedId)
+	if err != nil {
+		return err
 	}
 
-	return c.JSON(order)
+	return c.JSON(events)
 }
 
-// DeleteOrder удаляет заказ по ID (например, отмена заказа)
-func (h *OrderHandler) DeleteOrder(c *fiber.Ctx) error {
+// transitionOwnOrder меняет статус заказа текущего пользователя от имени покупателя
+func (h *OrderHandler) transitionOwnOrder(c *fiber.Ctx, to models.OrderStatus, note string) (models.Order, error) {
+	var order models.Order
+
 	parsedId, err := utils.ParseRouteID(c)
 	if err != nil {
-		return err
+		return order, err
 	}
 
 	user := c.Locals("current_user").(models.User)
-	if err := h.db.Where("id = ? AND user_id = ?", parsedId, user.ID).Delete(&models.Order{}).Error; err != nil {
-		return fiber.NewError(fiber.StatusInternalServerError, "could not delete order")
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return order, fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
 	}
 
-	return c.SendStatus(fiber.StatusNoContent)
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ? AND user_id = ?", parsedId, user.ID).First(&order).Error; err != nil {
+		tx.Rollback()
+		return order, fiber.NewError(fiber.StatusNotFound, "order not found or access denied")
+	}
+
+	if err := transitionOrder(tx, &order, to, models.OrderActorCustomer, &user.ID, note); err != nil {
+		tx.Rollback()
+		return order, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return order, fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return order, nil
+}
+
+// orderSorts сортировки списков заказов
+var orderSorts = map[string]sortKey{
+	"created": {column: "created_at", cast: "timestamptz"},
+}
+
+// orderPage формирует страницу заказов с курсором следующей страницы
+func orderPage(page cursorPage, orders []models.Order) schemas.CursorPage[models.Order] {
+	orders, next := paginate(page, orders, func(order models.Order) (string, uuid.UUID) {
+		return cursorTime(order.CreatedAt), order.ID
+	})
+
+	return schemas.CursorPage[models.Order]{Items: orders, NextCursor: next}
+}
+
+// cartLineSelected проверяет, выбрана ли строка корзины: по ID строки или, как раньше, по ID товара
+// вместе с вариантом
+func cartLineSelected(line models.CartProduct, selected schemas.CartProductResponse) bool {
+	if selected.ID == line.ID.String() {
+		return true
+	}
+
+	productID := selected.ProductID
+	if productID == "" {
+		productID = selected.ID
+	}
+	if productID != line.ProductID.String() {
+		return false
+	}
+
+	if line.VariantID == nil || selected.VariantID == nil {
+		return line.VariantID == nil && selected.VariantID == nil
+	}
+
+	return *selected.VariantID == line.VariantID.String()
 }
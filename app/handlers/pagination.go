@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"fmt"
+	"fusion/app/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	listDefaultLimit = 20
+	listMaxLimit     = 100
+)
+
+// sortKey столбец сортировки и тип, к которому приводится значение из курсора
+type sortKey struct {
+	column string
+	cast   string
+}
+
+// cursorPage параметры запроса страницы: сортировка, размер и позиция, после которой продолжается список.
+// Записи упорядочены по столбцу сортировки и ID, поэтому позиция однозначна и при равных значениях.
+type cursorPage struct {
+	sort   string
+	key    sortKey
+	desc   bool
+	limit  int
+	cursor *utils.Cursor
+}
+
+// parseCursorPage читает параметры sort (с "-" для обратного порядка), limit и cursor
+func parseCursorPage(c *fiber.Ctx, sorts map[string]sortKey, defaultSort string) (cursorPage, error) {
+	page := cursorPage{sort: c.Query("sort", defaultSort)}
+
+	name := strings.TrimPrefix(page.sort, "-")
+	key, ok := sorts[name]
+	if !ok {
+		return page, fiber.NewError(fiber.StatusBadRequest, "unsupported sort")
+	}
+	page.key = key
+	page.desc = name != page.sort
+
+	page.limit = c.QueryInt("limit", listDefaultLimit)
+	if page.limit < 1 || page.limit > listMaxLimit {
+		page.limit = listDefaultLimit
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		cursor, err := utils.DecodeCursor(value)
+		if err != nil || cursor.Sort != page.sort || !validCursorValue(key.cast, cursor.Value) {
+			return page, fiber.NewError(fiber.StatusBadRequest, "invalid cursor")
+		}
+		page.cursor = &cursor
+	}
+
+	return page, nil
+}
+
+// validCursorValue проверяет, что значение из курсора приводится к типу столбца сортировки,
+// иначе подделанный курсор вызвал бы ошибку базы вместо ответа 400
+func validCursorValue(cast, value string) bool {
+	switch cast {
+	case "timestamptz":
+		_, err := time.Parse(time.RFC3339Nano, value)
+		return err == nil
+	case "numeric", "double precision":
+		number, err := strconv.ParseFloat(value, 64)
+		return err == nil && !math.IsNaN(number) && !math.IsInf(number, 0)
+	case "bigint":
+		_, err := strconv.ParseInt(value, 10, 64)
+		return err == nil
+	default:
+		return false
+	}
+}
+
+// apply ограничивает запрос записями после курсора, задает порядок и выбирает одну лишнюю запись,
+// по которой видно, есть ли следующая страница
+func (page cursorPage) apply(query *gorm.DB, table string) *gorm.DB {
+	column := table + "." + page.key.column
+	operator, direction := ">", "ASC"
+	if page.desc {
+		operator, direction = "<", "DESC"
+	}
+
+	if page.cursor != nil {
+		query = query.Where(
+			fmt.Sprintf("(%s, %s.id) %s (CAST(? AS %s), CAST(? AS uuid))", column, table, operator, page.key.cast),
+			page.cursor.Value, page.cursor.ID,
+		)
+	}
+
+	return query.
+		Order(fmt.Sprintf("%s %s, %s.id %s", column, direction, table, direction)).
+		Limit(page.limit + 1)
+}
+
+// paginate отбрасывает лишнюю запись и возвращает курсор следующей страницы, если она есть.
+// position возвращает значение столбца сортировки и ID записи.
+func paginate[T any](page cursorPage, items []T, position func(T) (string, uuid.UUID)) ([]T, *string) {
+	if items == nil {
+		items = []T{}
+	}
+
+	if len(items) <= page.limit {
+		return items, nil
+	}
+
+	items = items[:page.limit]
+	value, id := position(items[len(items)-1])
+	next := utils.Cursor{Sort: page.sort, Value: value, ID: id}.Encode()
+
+	return items, &next
+}
+
+// cursorTime форматирует время для курсора без потери точности
+func cursorTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
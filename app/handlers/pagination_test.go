@@ -0,0 +1,31 @@
+package handlers
+
+import "testing"
+
+func TestValidCursorValue(t *testing.T) {
+	tests := []struct {
+		cast  string
+		value string
+		want  bool
+	}{
+		{"timestamptz", "2024-05-01T10:00:00.123456789Z", true},
+		{"timestamptz", "2024-05-01", false},
+		{"timestamptz", "'; DROP TABLE orders; --", false},
+		{"numeric", "1999.99", true},
+		{"numeric", "NaN", false},
+		{"numeric", "abc", false},
+		{"double precision", "4.5", true},
+		{"double precision", "1e+06", true},
+		{"double precision", "Inf", false},
+		{"bigint", "42", true},
+		{"bigint", "4.2", false},
+		{"bigint", "99999999999999999999", false},
+		{"text", "anything", false},
+	}
+
+	for _, tt := range tests {
+		if got := validCursorValue(tt.cast, tt.value); got != tt.want {
+			t.Errorf("validCursorValue(%q, %q) = %v, want %v", tt.cast, tt.value, got, tt.want)
+		}
+	}
+}
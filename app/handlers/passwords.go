@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"fusion/app/database/models"
+	"fusion/app/schemas"
+	"fusion/app/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"sync"
+)
+
+// checkPassword проверяет новый пароль по политике, а для существующего пользователя еще
+// и по истории его паролей. Нарушения возвращаются как ошибки поля password.
+func (h AuthRoute) checkPassword(tx *gorm.DB, user *models.User, password string) ([]schemas.FieldError, error) {
+	violations, err := h.passwords.Check(password)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "could not check password")
+	}
+
+	if user != nil && h.passwords.History > 0 {
+		reused, err := h.isRecentPassword(tx, *user, password)
+		if err != nil {
+			return nil, err
+		}
+
+		if reused {
+			violations = append(violations, utils.PasswordViolation{Code: "reused", Message: "password was used recently"})
+		}
+	}
+
+	fieldErrors := make([]schemas.FieldError, len(violations))
+	for i, violation := range violations {
+		fieldErrors[i] = schemas.FieldError{
+			Field:   "password",
+			Code:    violation.Code,
+			Message: violation.Message,
+		}
+	}
+
+	return fieldErrors, nil
+}
+
+// isRecentPassword сравнивает пароль с текущим и последними сохраненными; хеши проверяются параллельно,
+// так как каждое сравнение bcrypt занимает заметное время
+func (h AuthRoute) isRecentPassword(tx *gorm.DB, user models.User, password string) (bool, error) {
+	var history []models.PasswordHistory
+	if err := tx.
+		Where("user_id = ?", user.ID).
+		Order("created_at desc").
+		Limit(h.passwords.History).
+		Find(&history).Error; err != nil {
+		return false, fiber.NewError(fiber.StatusInternalServerError, "could not retrieve password history")
+	}
+
+	hashes := make([]string, 0, len(history)+1)
+	if user.Password != "" {
+		hashes = append(hashes, user.Password)
+	}
+	for _, entry := range history {
+		if entry.Hash != user.Password {
+			hashes = append(hashes, entry.Hash)
+		}
+	}
+
+	matches := make([]bool, len(hashes))
+	var wg sync.WaitGroup
+	for i, hash := range hashes {
+		wg.Add(1)
+		go func(i int, hash string) {
+			defer wg.Done()
+			matches[i] = utils.CheckPasswordHash(password, hash)
+		}(i, hash)
+	}
+	wg.Wait()
+
+	for _, match := range matches {
+		if match {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// recordPassword сохраняет хеш нового пароля в истории и удаляет записи сверх лимита
+func (h AuthRoute) recordPassword(tx *gorm.DB, userID uuid.UUID, hash string) error {
+	if h.passwords.History <= 0 {
+		return nil
+	}
+
+	if err := tx.Create(&models.PasswordHistory{UserID: userID, Hash: hash}).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not save password history")
+	}
+
+	if err := tx.Where(
+		"user_id = ? AND id NOT IN (?)",
+		userID,
+		tx.Model(&models.PasswordHistory{}).
+			Select("id").
+			Where("user_id = ?", userID).
+			Order("created_at desc").
+			Limit(h.passwords.History),
+	).Delete(&models.PasswordHistory{}).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not prune password history")
+	}
+
+	return nil
+}
+
+// passwordErrorResponse возвращает ответ 400 со списком нарушений политики паролей
+func passwordErrorResponse(c *fiber.Ctx, fieldErrors []schemas.FieldError) error {
+	return c.Status(fiber.StatusBadRequest).JSON(schemas.ValidationErrorResponse{
+		Message: "password does not meet the policy",
+		Errors:  fieldErrors,
+	})
+}
@@ -0,0 +1,438 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fusion/app/database/models"
+	"fusion/app/middleware"
+	"fusion/app/schemas"
+	"fusion/app/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// refundBatch сколько отложенных возвратов повторяется за один запуск
+const refundBatch = 100
+
+// Платеж создается для подтвержденного заказа (STAGING) на сумму его итогов. Списание и возврат только
+// запрашиваются у провайдера; статус платежа и заказа меняет вебхук, подтвердивший операцию.
+
+type PaymentHandler struct {
+	db       *gorm.DB
+	provider utils.PaymentProvider
+	validate *validator.Validate
+}
+
+// RegisterPaymentRoutes регистрирует маршруты платежей; вебхук провайдера не требует авторизации,
+// его подлинность подтверждает подпись
+func RegisterPaymentRoutes(app *fiber.App, db *gorm.DB, provider utils.PaymentProvider) {
+	handler := &PaymentHandler{db: db, provider: provider, validate: validator.New()}
+
+	auth := middleware.AuthMiddleware(middleware.ApiKeyScope(models.ScopePayments))
+	idempotent := middleware.IdempotencyMiddleware()
+
+	paymentGroup := app.Group("/payments")
+	paymentGroup.Post("/webhook", handler.HandleWebhook)
+	paymentGroup.Post("/", auth, idempotent, handler.CreatePayment)
+	paymentGroup.Get("/:id", auth, handler.GetPayment)
+	paymentGroup.Post("/:id/capture", auth, idempotent, handler.CapturePayment)
+	paymentGroup.Post("/:id/refund", middleware.AuthMiddleware(middleware.AllOf(models.PermissionOrdersManage)), idempotent, handler.RefundPayment)
+}
+
+// CreatePayment создает платеж за подтвержденный заказ текущего пользователя. Если у заказа уже есть
+// ожидающий платеж, возвращается он.
+func (h *PaymentHandler) CreatePayment(c *fiber.Ctx) error {
+	user := c.Locals("current_user").(models.User)
+
+	var input schemas.CreatePaymentRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ? AND user_id = ?", input.OrderID, user.ID).First(&order).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusNotFound, "order not found or access denied")
+	}
+
+	var payments []models.Payment
+	if err := tx.Where("order_id = ? AND status IN ?", order.ID, []models.PaymentStatus{models.PaymentPending, models.PaymentCaptured}).Find(&payments).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve payments")
+	}
+	for _, payment := range payments {
+		if payment.Status == models.PaymentCaptured {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusConflict, "order is already paid")
+		}
+		if payment.Provider == h.provider.Name() {
+			tx.Rollback()
+			return c.JSON(toPaymentResponse(payment, true))
+		}
+	}
+
+	if order.Status != models.STAGING {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusConflict, "order is not awaiting payment")
+	}
+	if order.GrandTotal <= 0 {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusConflict, "order has nothing to pay")
+	}
+
+	intent, err := h.provider.CreateIntent(c.UserContext(), order.GrandTotal, order.Currency, "order "+order.ID.String())
+	if err != nil {
+		tx.Rollback()
+		return paymentProviderError(err)
+	}
+
+	payment := models.Payment{
+		OrderID:      order.ID,
+		Provider:     h.provider.Name(),
+		Reference:    intent.Reference,
+		ClientSecret: intent.ClientSecret,
+		Status:       models.PaymentPending,
+		Amount:       order.GrandTotal,
+		Currency:     order.Currency,
+	}
+	if err := tx.Create(&payment).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not create payment")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(toPaymentResponse(payment, true))
+}
+
+// GetPayment возвращает платеж по заказу текущего пользователя
+func (h *PaymentHandler) GetPayment(c *fiber.Ctx) error {
+	payment, err := h.ownPayment(h.db, c)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(toPaymentResponse(payment, payment.Status == models.PaymentPending))
+}
+
+// CapturePayment запрашивает списание ожидающего платежа; заказ станет оплаченным после вебхука
+func (h *PaymentHandler) CapturePayment(c *fiber.Ctx) error {
+	payment, err := h.ownPayment(h.db, c)
+	if err != nil {
+		return err
+	}
+
+	if payment.Status != models.PaymentPending {
+		return fiber.NewError(fiber.StatusConflict, "payment is not pending")
+	}
+	if payment.Order.Status != models.STAGING {
+		return fiber.NewError(fiber.StatusConflict, "order is not awaiting payment")
+	}
+
+	if err := h.provider.Capture(c.UserContext(), payment.Reference); err != nil {
+		return paymentProviderError(err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(toPaymentResponse(payment, false))
+}
+
+// RefundPayment запрашивает возврат оставшейся суммы списанного платежа от имени сотрудника;
+// заказ станет возвращенным после вебхука
+func (h *PaymentHandler) RefundPayment(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	var payment models.Payment
+	if err := h.db.First(&payment, "id = ?", parsedId).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "payment not found")
+	}
+
+	if payment.Status != models.PaymentCaptured {
+		return fiber.NewError(fiber.StatusConflict, "payment is not captured")
+	}
+	if payment.RefundPending {
+		return fiber.NewError(fiber.StatusConflict, "payment refund is already pending")
+	}
+
+	amount := utils.FromCents(utils.ToCents(payment.Amount) - utils.ToCents(payment.Refunded))
+	if err := h.provider.Refund(c.UserContext(), payment.Reference, amount); err != nil {
+		return paymentProviderError(err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(toPaymentResponse(payment, false))
+}
+
+// HandleWebhook принимает подписанное событие провайдера и применяет его к платежу и заказу.
+// Повторная доставка события не меняет состояние: ID обработанных событий сохраняются. Списание,
+// сумма или валюта которого не совпадает с платежом, отклоняется; списание, пришедшее после отмены
+// заказа, в той же транзакции отмечается к возврату, и возврат запрашивается сразу после нее, а если
+// провайдер его не принял — повторяется StartRefundRetry.
+func (h *PaymentHandler) HandleWebhook(c *fiber.Ctx) error {
+	headers := make(http.Header)
+	for name, values := range c.GetReqHeaders() {
+		for _, value := range values {
+			headers.Add(name, value)
+		}
+	}
+
+	event, err := h.provider.VerifyWebhook(c.Body(), headers)
+	if errors.Is(err, utils.ErrInvalidSignature) {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid signature")
+	}
+	if err != nil || event.ID == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid webhook")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	var payment models.Payment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("provider = ? AND reference = ?", h.provider.Name(), event.Reference).
+		First(&payment).
+		Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusNotFound, "payment not found")
+	}
+
+	processed := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.PaymentWebhookEvent{
+		Provider:  h.provider.Name(),
+		EventID:   event.ID,
+		PaymentID: payment.ID,
+		Type:      event.Type,
+	})
+	if processed.Error != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not record webhook event")
+	}
+	if processed.RowsAffected == 0 {
+		tx.Rollback()
+		return c.SendStatus(fiber.StatusOK)
+	}
+
+	if event.Type == utils.PaymentEventCaptured &&
+		(utils.ToCents(event.Amount) != utils.ToCents(payment.Amount) || !strings.EqualFold(event.Currency, payment.Currency)) {
+		tx.Rollback()
+		log.Printf("payment %s captured %.2f %s, expected %.2f %s", payment.ID, event.Amount, event.Currency, payment.Amount, payment.Currency)
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "captured amount does not match payment")
+	}
+
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", payment.OrderID).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve order")
+	}
+
+	refund := false
+	switch event.Type {
+	case utils.PaymentEventCaptured:
+		if payment.Status != models.PaymentPending {
+			break
+		}
+
+		payment.Status = models.PaymentCaptured
+		if order.Status == models.STAGING {
+			err = transitionOrder(tx, &order, models.BILLED, models.OrderActorSystem, nil, "payment captured")
+		} else {
+			payment.RefundPending = true
+			refund = true
+		}
+	case utils.PaymentEventFailed:
+		if payment.Status == models.PaymentPending {
+			payment.Status = models.PaymentFailed
+		}
+	case utils.PaymentEventRefunded:
+		if payment.Status != models.PaymentCaptured {
+			break
+		}
+
+		payment.Refunded = utils.FromCents(utils.ToCents(payment.Refunded) + utils.ToCents(event.Amount))
+		if utils.ToCents(payment.Refunded) < utils.ToCents(payment.Amount) {
+			break
+		}
+
+		payment.Status = models.PaymentRefunded
+		payment.RefundPending = false
+		if order.Status == models.BILLED || order.Status == models.SENT || order.Status == models.DELIVERED {
+			err = transitionOrder(tx, &order, models.REFUNDED, models.OrderActorSystem, nil, "payment refunded")
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Model(&payment).Select("status", "refunded", "refund_pending").Updates(&payment).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not update payment")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	// Событие уже сохранено, поэтому повторная доставка не запросит возврат: если провайдер
+	// его не принял, платеж остается отмеченным и возврат повторит StartRefundRetry
+	if refund {
+		if _, err := requestPendingRefund(c.UserContext(), h.db, h.provider, payment.ID); err != nil {
+			log.Printf("could not refund payment %s for %s order %s, will retry: %v", payment.ID, order.Status, order.ID, err)
+		}
+	}
+
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// requestPendingRefund запрашивает у провайдера возврат платежа, отмеченного RefundPending, и снимает
+// отметку, если провайдер принял запрос. Платеж, который в этот момент обрабатывает другой запрос,
+// пропускается, чтобы возврат не был запрошен дважды.
+func requestPendingRefund(ctx context.Context, db *gorm.DB, provider utils.PaymentProvider, paymentID uuid.UUID) (bool, error) {
+	requested := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var payment models.Payment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("id = ? AND provider = ? AND status = ? AND refund_pending", paymentID, provider.Name(), models.PaymentCaptured).
+			First(&payment).
+			Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		amount := utils.FromCents(utils.ToCents(payment.Amount) - utils.ToCents(payment.Refunded))
+		if err := provider.Refund(ctx, payment.Reference, amount); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&payment).Update("refund_pending", false).Error; err != nil {
+			return err
+		}
+
+		requested = true
+		return nil
+	})
+
+	return requested, err
+}
+
+// RetryPendingRefunds повторяет возвраты списаний, которые провайдер не принял, и возвращает число
+// принятых запросов. Ошибка по одному платежу не мешает повторить остальные.
+func RetryPendingRefunds(ctx context.Context, db *gorm.DB, provider utils.PaymentProvider) (int, error) {
+	var ids []uuid.UUID
+	if err := db.Model(&models.Payment{}).
+		Where("provider = ? AND status = ? AND refund_pending", provider.Name(), models.PaymentCaptured).
+		Order("updated_at").
+		Limit(refundBatch).
+		Pluck("id", &ids).
+		Error; err != nil {
+		return 0, err
+	}
+
+	refunded := 0
+	for _, id := range ids {
+		requested, err := requestPendingRefund(ctx, db, provider, id)
+		if err != nil {
+			log.Printf("could not refund payment %s: %v", id, err)
+			continue
+		}
+		if requested {
+			refunded++
+		}
+	}
+
+	return refunded, nil
+}
+
+// StartRefundRetry периодически повторяет отложенные возвраты
+func StartRefundRetry(db *gorm.DB, provider utils.PaymentProvider, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			refunded, err := RetryPendingRefunds(context.Background(), db, provider)
+			if err != nil {
+				log.Printf("could not retry pending refunds: %v", err)
+			} else if refunded > 0 {
+				log.Printf("requested %d pending refunds", refunded)
+			}
+		}
+	}()
+}
+
+// ownPayment возвращает платеж из маршрута, если он относится к заказу текущего пользователя
+func (h *PaymentHandler) ownPayment(tx *gorm.DB, c *fiber.Ctx) (models.Payment, error) {
+	var payment models.Payment
+
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return payment, err
+	}
+
+	user := c.Locals("current_user").(models.User)
+	if err := tx.
+		Joins("Order").
+		Where("payments.id = ? AND \"Order\".user_id = ?", parsedId, user.ID).
+		First(&payment).
+		Error; err != nil {
+		return payment, fiber.NewError(fiber.StatusNotFound, "payment not found or access denied")
+	}
+
+	return payment, nil
+}
+
+// paymentProviderError переводит ошибку провайдера в ответ
+func paymentProviderError(err error) error {
+	switch {
+	case errors.Is(err, utils.ErrPaymentNotFound):
+		return fiber.NewError(fiber.StatusNotFound, "payment not found at provider")
+	case errors.Is(err, utils.ErrPaymentState):
+		return fiber.NewError(fiber.StatusConflict, "payment cannot be processed in its current state")
+	default:
+		log.Printf("payment provider error: %v", err)
+		return fiber.NewError(fiber.StatusBadGateway, "payment provider error")
+	}
+}
+
+// toPaymentResponse формирует ответ с платежом; ClientSecret отдается только покупателю, пока платеж не оплачен
+func toPaymentResponse(payment models.Payment, withSecret bool) schemas.PaymentResponse {
+	response := schemas.PaymentResponse{
+		ID:            payment.ID,
+		OrderID:       payment.OrderID,
+		Provider:      payment.Provider,
+		Status:        string(payment.Status),
+		Amount:        payment.Amount,
+		Refunded:      payment.Refunded,
+		RefundPending: payment.RefundPending,
+		Currency:      payment.Currency,
+		CreatedAt:     payment.CreatedAt,
+		UpdatedAt:     payment.UpdatedAt,
+	}
+	if withSecret {
+		response.ClientSecret = payment.ClientSecret
+	}
+
+	return response
+}
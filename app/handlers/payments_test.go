@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fusion/app/database/models"
+	"fusion/app/utils"
+	"github.com/google/uuid"
+	"testing"
+)
+
+// stubRefundProvider принимает запросы возврата, пока fail не установлен
+type stubRefundProvider struct {
+	utils.PaymentProvider
+	fail    bool
+	refunds []float64
+}
+
+func (p *stubRefundProvider) Name() string {
+	return "stub"
+}
+
+func (p *stubRefundProvider) Refund(_ context.Context, _ string, amount float64) error {
+	if p.fail {
+		return errors.New("provider unavailable")
+	}
+	p.refunds = append(p.refunds, amount)
+	return nil
+}
+
+func TestRetryPendingRefunds(t *testing.T) {
+	db := testDB(t)
+
+	suffix := uuid.NewString()[:8]
+	user := models.User{Username: "refund-" + suffix, Email: "refund-" + suffix + "@example.com", Password: "hash"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	order := models.Order{UserID: user.ID, Status: models.CANCELLED, Currency: "RUB", GrandTotal: 150}
+	if err := db.Create(&order).Error; err != nil {
+		t.Fatal(err)
+	}
+	payment := models.Payment{
+		OrderID:       order.ID,
+		Provider:      "stub",
+		Reference:     "ref-" + suffix,
+		Status:        models.PaymentCaptured,
+		Amount:        150,
+		Refunded:      50,
+		Currency:      "RUB",
+		RefundPending: true,
+	}
+	if err := db.Create(&payment).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Delete(&payment)
+		db.Delete(&order)
+		db.Delete(&user)
+	})
+
+	provider := &stubRefundProvider{fail: true}
+
+	steps := []struct {
+		name     string
+		fail     bool
+		refunded int
+		pending  bool
+	}{
+		{"provider rejects refund", true, 0, true},
+		{"provider accepts refund", false, 1, false},
+		{"refund is requested once", false, 0, false},
+	}
+
+	for _, step := range steps {
+		provider.fail = step.fail
+
+		refunded, err := RetryPendingRefunds(context.Background(), db, provider)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if refunded != step.refunded {
+			t.Errorf("%s: refunded = %d, want %d", step.name, refunded, step.refunded)
+		}
+
+		var stored models.Payment
+		if err := db.First(&stored, "id = ?", payment.ID).Error; err != nil {
+			t.Fatal(err)
+		}
+		if stored.RefundPending != step.pending {
+			t.Errorf("%s: refund pending = %v, want %v", step.name, stored.RefundPending, step.pending)
+		}
+	}
+
+	// Возвращается оставшаяся после частичных возвратов сумма
+	if len(provider.refunds) != 1 || provider.refunds[0] != 100 {
+		t.Errorf("refund requests = %v, want [100]", provider.refunds)
+	}
+}
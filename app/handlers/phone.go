@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"fusion/app/database/models"
+	"fusion/app/schemas"
+	"fusion/app/utils"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm/clause"
+	"log"
+	"time"
+)
+
+const (
+	phoneVerifyType       = "PHONE_VERIFY"
+	phoneCodeDigits       = 6
+	phoneCodeMaxAttempts  = 5
+	phoneCodeResendPeriod = time.Minute
+)
+
+// requestPhoneVerification отправляет код подтверждения на новый номер телефона;
+// номер сохраняется у пользователя только после подтверждения
+func (h UsersRoute) requestPhoneVerification(c *fiber.Ctx) error {
+	user := c.Locals("current_user").(models.User)
+
+	var input schemas.PhoneRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	phone, err := models.NormalizePhone(input.Phone)
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid phone number")
+	}
+
+	var existing int64
+	if err := h.db.Model(&models.User{}).
+		Where("phone = ? AND is_phone_verified = ? AND id <> ?", phone, true, user.ID).
+		Count(&existing).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not check phone")
+	}
+
+	if existing > 0 {
+		return fiber.NewError(fiber.StatusConflict, "phone already registered")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	var previous models.Verification
+	if err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND type = ? AND created_at > ?", user.ID, phoneVerifyType, time.Now().Add(-phoneCodeResendPeriod)).
+		First(&previous).Error; err == nil {
+		tx.Rollback()
+		c.Set(fiber.HeaderRetryAfter, fmt.Sprint(int(phoneCodeResendPeriod.Seconds())))
+		return fiber.NewError(fiber.StatusTooManyRequests, "verification code was sent recently")
+	}
+
+	if err := tx.Where("user_id = ? AND type = ?", user.ID, phoneVerifyType).Delete(&models.Verification{}).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not replace verification code")
+	}
+
+	code, err := utils.GenerateNumericCode(phoneCodeDigits)
+	if err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not generate verification code")
+	}
+
+	verification := models.Verification{
+		Type:      phoneVerifyType,
+		UserID:    user.ID,
+		Token:     phoneCodeHash(user, code),
+		Target:    phone,
+		ExpiresAt: time.Now().Add(h.config.PhoneCodeExpire),
+	}
+
+	if err := tx.Create(&verification).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not create verification code")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	if err := h.sms.SendSMS(phone, fmt.Sprintf("Fusion verification code: %s", code)); err != nil {
+		log.Printf("could not send verification code to user %s: %v", user.ID, err)
+		return fiber.NewError(fiber.StatusInternalServerError, "could not send verification code")
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "verification code sent"})
+}
+
+// confirmPhone проверяет код из SMS и сохраняет подтвержденный номер
+func (h UsersRoute) confirmPhone(c *fiber.Ctx) error {
+	user := c.Locals("current_user").(models.User)
+
+	var input schemas.PhoneConfirmRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	var verification models.Verification
+	if err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND type = ? AND expires_at > ?", user.ID, phoneVerifyType, time.Now()).
+		First(&verification).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusNotFound, "verification code not found or expired")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(verification.Token), []byte(phoneCodeHash(user, input.Code))) != 1 {
+		// После нескольких неверных попыток код сгорает, чтобы его нельзя было подобрать
+		if verification.Attempts+1 >= phoneCodeMaxAttempts {
+			if err := tx.Delete(&verification).Error; err != nil {
+				tx.Rollback()
+				return fiber.NewError(fiber.StatusInternalServerError, "could not delete verification code")
+			}
+		} else if err := tx.Model(&verification).Update("attempts", verification.Attempts+1).Error; err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not update verification code")
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+		}
+
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid verification code")
+	}
+
+	var existing int64
+	if err := tx.Model(&models.User{}).
+		Where("phone = ? AND is_phone_verified = ? AND id <> ?", verification.Target, true, user.ID).
+		Count(&existing).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not check phone")
+	}
+
+	if existing > 0 {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusConflict, "phone already registered")
+	}
+
+	user.Phone = &verification.Target
+	user.IsPhoneVerified = true
+	if err := tx.Model(&user).Select("phone", "is_phone_verified").Updates(&user).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not save phone")
+	}
+
+	if err := tx.Delete(&verification).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not delete verification code")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return c.JSON(fiber.Map{"phone": user.Phone, "is_phone_verified": true})
+}
+
+// removePhone удаляет номер телефона текущего пользователя
+func (h UsersRoute) removePhone(c *fiber.Ctx) error {
+	user := c.Locals("current_user").(models.User)
+
+	if err := h.db.Model(&user).Updates(map[string]interface{}{
+		"phone":             nil,
+		"is_phone_verified": false,
+	}).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not remove phone")
+	}
+
+	if err := h.db.Where("user_id = ? AND type = ?", user.ID, phoneVerifyType).Delete(&models.Verification{}).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not delete verification code")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// phoneCodeHash привязывает код к пользователю, чтобы токены разных пользователей не совпадали
+func phoneCodeHash(user models.User, code string) string {
+	return utils.HashToken(user.ID.String() + ":" + code)
+}
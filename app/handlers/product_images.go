@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"fusion/app/database/models"
+	"fusion/app/schemas"
+	"fusion/app/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+)
+
+// maxProductImages сколько изображений может быть в галерее товара
+const maxProductImages = 20
+
+// UploadProductImages добавляет изображения из поля images в конец галереи в порядке их следования в запросе
+func (h *ProductHandler) UploadProductImages(c *fiber.Ctx) error {
+	product, err := h.ownedProduct(h.db, c)
+	if err != nil {
+		return err
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid multipart form")
+	}
+
+	files := form.File["images"]
+	if len(files) == 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "no images")
+	}
+	if len(files) > utils.MaxUploadFiles {
+		return fiber.NewError(fiber.StatusBadRequest, "too many files")
+	}
+
+	// Файлы сохраняются до транзакции, чтобы не держать блокировку товара на время обработки
+	images := make([]models.ProductImage, 0, len(files))
+	for _, file := range files {
+		data, err := readImageUpload(file, h.config.UploadMaxSize)
+		if err != nil {
+			h.deleteProductImages(images)
+			return err
+		}
+
+		image := models.ProductImage{ID: uuid.New(), ProductID: product.ID}
+		image.Key, err = utils.StoreImage(c.UserContext(), h.storage, "products/"+product.ID.String()+"/"+image.ID.String(), data, utils.ProductImageSizes)
+		if err != nil {
+			h.deleteProductImages(images)
+			return imageError(err)
+		}
+
+		images = append(images, image)
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		h.deleteProductImages(images)
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	gallery, err := h.lockedGallery(tx, c)
+	if err != nil {
+		tx.Rollback()
+		h.deleteProductImages(images)
+		return err
+	}
+
+	if len(gallery)+len(images) > maxProductImages {
+		tx.Rollback()
+		h.deleteProductImages(images)
+		return fiber.NewError(fiber.StatusConflict, "too many product images")
+	}
+
+	for i := range images {
+		images[i].Position = len(gallery) + i
+	}
+	if err := tx.Create(&images).Error; err != nil {
+		tx.Rollback()
+		h.deleteProductImages(images)
+		return fiber.NewError(fiber.StatusInternalServerError, "could not save images")
+	}
+
+	gallery = append(gallery, images...)
+	if err := h.syncProductCover(tx, product.ID, gallery); err != nil {
+		tx.Rollback()
+		h.deleteProductImages(images)
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		h.deleteProductImages(images)
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(h.imageResponses(gallery))
+}
+
+// ReorderProductImages задает новый порядок галереи; первое изображение становится обложкой
+func (h *ProductHandler) ReorderProductImages(c *fiber.Ctx) error {
+	var input schemas.ImageOrderRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	gallery, err := h.lockedGallery(tx, c)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	images := make(map[uuid.UUID]models.ProductImage, len(gallery))
+	for _, image := range gallery {
+		images[image.ID] = image
+	}
+
+	if len(input.IDs) != len(gallery) {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusBadRequest, "ids must list every product image once")
+	}
+
+	ordered := make([]models.ProductImage, 0, len(gallery))
+	for position, id := range input.IDs {
+		image, ok := images[id]
+		if !ok {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusBadRequest, "ids must list every product image once")
+		}
+		delete(images, id)
+
+		if image.Position != position {
+			if err := tx.Model(&image).Update("position", position).Error; err != nil {
+				tx.Rollback()
+				return fiber.NewError(fiber.StatusInternalServerError, "could not reorder images")
+			}
+			image.Position = position
+		}
+		ordered = append(ordered, image)
+	}
+
+	if err := h.syncProductCover(tx, ordered[0].ProductID, ordered); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return c.JSON(h.imageResponses(ordered))
+}
+
+// DeleteProductImage удаляет изображение из галереи и из хранилища
+func (h *ProductHandler) DeleteProductImage(c *fiber.Ctx) error {
+	imageID, err := uuid.Parse(c.Params("image"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid image ID")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	gallery, err := h.lockedGallery(tx, c)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	index := -1
+	for i, image := range gallery {
+		if image.ID == imageID {
+			index = i
+		}
+	}
+	if index < 0 {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusNotFound, "image not found")
+	}
+	image := gallery[index]
+
+	if err := tx.Delete(&image).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not delete image")
+	}
+
+	if err := tx.Model(&models.ProductImage{}).
+		Where("product_id = ? AND position > ?", image.ProductID, image.Position).
+		Update("position", gorm.Expr("position - 1")).
+		Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not reorder images")
+	}
+
+	gallery = append(gallery[:index], gallery[index+1:]...)
+	if err := h.syncProductCover(tx, image.ProductID, gallery); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	h.deleteProductImages([]models.ProductImage{image})
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// lockedGallery блокирует товар текущего пользователя и возвращает его галерею по порядку
+func (h *ProductHandler) lockedGallery(tx *gorm.DB, c *fiber.Ctx) ([]models.ProductImage, error) {
+	product, err := h.ownedProduct(tx.Clauses(clause.Locking{Strength: "UPDATE"}), c)
+	if err != nil {
+		return nil, err
+	}
+
+	var gallery []models.ProductImage
+	if err := tx.Where("product_id = ?", product.ID).Order("position").Find(&gallery).Error; err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "could not retrieve images")
+	}
+
+	return gallery, nil
+}
+
+// syncProductCover делает обложкой товара первое изображение галереи среднего размера
+func (h *ProductHandler) syncProductCover(tx *gorm.DB, productID uuid.UUID, gallery []models.ProductImage) error {
+	var cover *string
+	if len(gallery) > 0 {
+		url := h.storage.URL(utils.ImageKey(gallery[0].Key, "medium"))
+		cover = &url
+	}
+
+	if err := tx.Model(&models.Product{}).Where("id = ?", productID).Update("image", cover).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not update product cover")
+	}
+
+	return nil
+}
+
+// deleteProductImages удаляет файлы изображений; ошибки только записываются в лог,
+// так как изображения уже не используются
+func (h *ProductHandler) deleteProductImages(images []models.ProductImage) {
+	for _, image := range images {
+		if err := utils.DeleteImage(context.Background(), h.storage, image.Key, utils.ProductImageSizes); err != nil {
+			log.Printf("could not delete product image %s: %v", image.ID, err)
+		}
+	}
+}
+
+func (h *ProductHandler) imageResponses(gallery []models.ProductImage) []schemas.ImageResponse {
+	responses := make([]schemas.ImageResponse, len(gallery))
+	for i, image := range gallery {
+		responses[i] = schemas.ImageResponse{
+			ID:       image.ID.String(),
+			Position: image.Position,
+			URLs:     utils.ImageURLs(h.storage, image.Key, utils.ProductImageSizes),
+		}
+	}
+
+	return responses
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"fusion/app/schemas"
+	"github.com/gofiber/fiber/v2"
+	"regexp"
+	"strings"
+)
+
+const (
+	searchDefaultLimit = 20
+	searchMaxLimit     = 100
+	searchMaxTerms     = 8
+)
+
+var searchTermPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// Подсвеченные фрагменты строятся по экранированному тексту, поэтому их можно вставлять как HTML:
+// разметку содержат только теги <mark>
+const productSearchQuery = `
+SELECT
+	ranked.id,
+	ranked.name,
+	ranked.price,
+	ranked.image,
+	ranked.rank,
+	ts_headline('simple', ranked.safe_name, ranked.query,
+		'StartSel=<mark>, StopSel=</mark>, HighlightAll=true') AS name_highlight,
+	ts_headline('simple', ranked.safe_description, ranked.query,
+		'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5') AS description_highlight
+FROM (
+	SELECT
+		products.id,
+		products.name,
+		products.price,
+		products.image,
+		query,
+		ts_rank(products.search_vector, query) AS rank,
+		replace(replace(replace(products.name, '&', '&amp;'), '<', '&lt;'), '>', '&gt;') AS safe_name,
+		replace(replace(replace(products.description, '&', '&amp;'), '<', '&lt;'), '>', '&gt;') AS safe_description
+	FROM products, to_tsquery('simple', ?) AS query
+	WHERE products.search_vector @@ query
+		AND products.is_hidden = false
+		AND products.deleted_at IS NULL
+	ORDER BY rank DESC, products.id
+	LIMIT ? OFFSET ?
+) AS ranked
+ORDER BY ranked.rank DESC, ranked.id`
+
+// SearchProducts выполняет полнотекстовый поиск по названию, описанию и категориям товаров.
+// Последнее слово запроса ищется по префиксу, чтобы поиск работал при наборе.
+func (h *ProductHandler) SearchProducts(c *fiber.Ctx) error {
+	query := prefixSearchQuery(c.Query("q"))
+	if query == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "search query is required")
+	}
+
+	page := c.QueryInt("page", 1)
+	if page < 1 {
+		page = 1
+	}
+
+	limit := c.QueryInt("limit", searchDefaultLimit)
+	if limit < 1 || limit > searchMaxLimit {
+		limit = searchDefaultLimit
+	}
+
+	results := make([]schemas.ProductSearchResult, 0, limit)
+	if err := h.db.Raw(productSearchQuery, query, limit, (page-1)*limit).Scan(&results).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not search products")
+	}
+
+	return c.JSON(schemas.ProductSearchResponse{
+		Results: results,
+		Page:    page,
+		Limit:   limit,
+	})
+}
+
+// prefixSearchQuery превращает строку поиска в tsquery, где все слова обязательны, а последнее
+// сопоставляется по префиксу. Из запроса остаются только буквы и цифры, поэтому он не может
+// содержать операторы tsquery.
+func prefixSearchQuery(q string) string {
+	terms := searchTermPattern.FindAllString(strings.ToLower(q), searchMaxTerms)
+	if len(terms) == 0 {
+		return ""
+	}
+
+	terms[len(terms)-1] += ":*"
+	return strings.Join(terms, " & ")
+}
@@ -5,62 +5,137 @@ import (
 	"fusion/app/middleware"
 	"fusion/app/schemas"
 	"fusion/app/utils"
+	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"math"
+	"strconv"
 )
 
 type ProductHandler struct {
-	db *gorm.DB
+	db       *gorm.DB
+	config   utils.AppConfig
+	storage  utils.BlobStorage
+	validate *validator.Validate
 }
 
-// RegisterProductRoutes регистрирует маршруты для продуктов
-func RegisterProductRoutes(app *fiber.App, db *gorm.DB) {
+// RegisterProductRoutes регистрирует маршруты для продуктов. Загрузка изображений регистрируется
+// на сервере uploads, который принимает тело больше стандартного ограничения
+func RegisterProductRoutes(app, uploads *fiber.App, db *gorm.DB, config utils.AppConfig, storage utils.BlobStorage) {
 	handler := &ProductHandler{
-		db: db,
+		db:       db,
+		config:   config,
+		storage:  storage,
+		validate: validator.New(),
 	}
 
 	productGroup := app.Group("/products")
 	productGroup.Get("/", handler.GetProducts)
+	productGroup.Get("/search", handler.SearchProducts)
 	productGroup.Get("/:id", handler.GetProduct)
+	productGroup.Get("/:id/reviews", handler.GetReviews)
 
-	productGroup.Use(middleware.AuthMiddleware())
+	productGroup.Use(middleware.AuthMiddleware(middleware.ApiKeyScope(models.ScopeProducts)))
 	productGroup.Post("/", handler.CreateProduct)
 	productGroup.Put("/:id", handler.UpdateProduct)
 	productGroup.Delete("/:id", handler.DeleteProduct)
 
+	productGroup.Put("/:id/images/order", handler.ReorderProductImages)
+	productGroup.Delete("/:id/images/:image", handler.DeleteProductImage)
+
+	productGroup.Get("/:id/stock", handler.GetStockMovements)
+	productGroup.Post("/:id/stock", handler.RecordStockMovement)
+
+	productGroup.Post("/:id/variants", handler.CreateVariant)
+	productGroup.Put("/:id/variants/:variant", handler.UpdateVariant)
+	productGroup.Delete("/:id/variants/:variant", handler.DeleteVariant)
+
 	productGroup.Post("/:id/reviews", handler.CreateReview)
 	productGroup.Delete("/:id/reviews", handler.RemoveReview)
 
 	productGroup.Post("/:id/favorites", handler.AddToFavorites)
 	productGroup.Delete("/:id/favorites", handler.RemoveFromFavorites)
+
+	uploads.Post("/products/:id/images", middleware.AuthMiddleware(middleware.ApiKeyScope(models.ScopeProducts)), handler.UploadProductImages)
 }
 
-// GetProducts возвращает список всех продуктов
+// productSorts сортировки списка товаров; популярность определяется числом добавлений в избранное
+var productSorts = map[string]sortKey{
+	"price":      {column: "price", cast: "numeric"},
+	"created":    {column: "created_at", cast: "timestamptz"},
+	"rating":     {column: "rating", cast: "double precision"},
+	"popularity": {column: "favourites_count", cast: "bigint"},
+}
+
+// GetProducts возвращает страницу товаров с фильтрами по категории, цене, наличию, продавцу и рейтингу
 func (h *ProductHandler) GetProducts(c *fiber.Ctx) error {
+	page, err := parseCursorPage(c, productSorts, "-created")
+	if err != nil {
+		return err
+	}
+
+	query := h.db.Preload("Categories").Where("products.is_hidden = ?", false)
+
+	if ref := c.Query("category"); ref != "" {
+		category, err := findCategory(h.db, ref)
+		if err != nil {
+			return err
+		}
+		query = query.Where(
+			"EXISTS (SELECT 1 FROM product_category WHERE product_category.product_id = products.id AND product_category.category_id IN ("+categorySubtreeQuery+"))",
+			category.ID,
+		)
+	}
+	if seller := c.Query("seller"); seller != "" {
+		sellerID, err := uuid.Parse(seller)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid seller")
+		}
+		query = query.Where("products.user_id = ?", sellerID)
+	}
+
+	filters := []struct {
+		param     string
+		condition string
+	}{
+		{"min_price", "products.price >= ?"},
+		{"max_price", "products.price <= ?"},
+		{"min_rating", "products.rating >= ?"},
+	}
+	for _, filter := range filters {
+		value := c.Query(filter.param)
+		if value == "" {
+			continue
+		}
+
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid "+filter.param)
+		}
+		query = query.Where(filter.condition, number)
+	}
+
+	if c.QueryBool("in_stock") {
+		query = query.Where("products.stock > 0")
+	}
+
 	var products []models.Product
-	if err := h.db.
-		Preload("Reviews").
-		Preload("Categories").
-		Find(&products).
-		Error; err != nil {
+	if err := page.apply(query, "products").Find(&products).Error; err != nil {
 		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve products")
 	}
 
-	response := make([]schemas.ProductResponse, len(products))
-	for i, product := range products {
-		response[i] = schemas.ProductResponse{
-			ID:          product.ID.String(),
-			UserID:      product.UserID.String(),
-			Name:        product.Name,
-			Description: product.Description,
-			Price:       product.Price,
-			Image:       product.Image,
-			Categories:  product.Categories,
-			Reviews:     product.Reviews,
-		}
+	products, next := paginate(page, products, func(product models.Product) (string, uuid.UUID) {
+		return productCursorValue(product, page.key.column), product.ID
+	})
+
+	items, err := h.productResponses(products)
+	if err != nil {
+		return err
 	}
 
-	return c.JSON(response)
+	return c.JSON(schemas.CursorPage[schemas.ProductResponse]{Items: items, NextCursor: next})
 }
 
 // GetProduct возвращает продукт по ID
@@ -74,22 +149,29 @@ func (h *ProductHandler) GetProduct(c *fiber.Ctx) error {
 	if err := h.db.
 		Preload("Reviews").
 		Preload("Categories").
-		First(&product, "id = ?", parsedId).
+		Preload("Images", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position")
+		}).
+		Preload("OptionTypes.Values").
+		Preload("Variants", func(db *gorm.DB) *gorm.DB {
+			return db.Order("created_at")
+		}).
+		Preload("Variants.Options").
+		First(&product, "id = ? AND is_hidden = ?", parsedId, false).
 		Error; err != nil {
 		return fiber.NewError(fiber.StatusNotFound, "product not found")
 	}
 
-	response := schemas.ProductResponse{
-		ID:          product.ID.String(),
-		UserID:      product.UserID.String(),
-		Name:        product.Name,
-		Description: product.Description,
-		Price:       product.Price,
-		Image:       product.Image,
-		Categories:  product.Categories,
-		Reviews:     product.Reviews,
+	responses, err := h.productResponses([]models.Product{product})
+	if err != nil {
+		return err
 	}
 
+	response := responses[0]
+	response.Reviews = product.Reviews
+	response.Images = h.imageResponses(product.Images)
+	response.Options, response.Variants = variantMatrix(product)
+
 	return c.JSON(response)
 }
 
@@ -102,11 +184,44 @@ func (h *ProductHandler) CreateProduct(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
 	}
 
+	if product.Stock < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid stock")
+	}
+
 	product.UserID = user.ID
-	if err := h.db.Create(&product).Error; err != nil {
+	product.Categories = nil
+	product.Images = nil
+	product.OptionTypes = nil
+	product.Variants = nil
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	if err := tx.Create(&product).Error; err != nil {
+		tx.Rollback()
 		return fiber.NewError(fiber.StatusInternalServerError, "could not create product")
 	}
 
+	// Начальный остаток приходит на склад первой записью журнала
+	if product.Stock > 0 {
+		if err := tx.Create(&models.StockMovement{
+			ProductID: product.ID,
+			Kind:      models.StockReceipt,
+			Quantity:  product.Stock,
+			Reason:    "initial stock",
+			ActorID:   &user.ID,
+		}).Error; err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not record stock")
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
 	return c.Status(fiber.StatusCreated).JSON(product)
 }
 
@@ -142,22 +257,64 @@ func (h *ProductHandler) UpdateProduct(c *fiber.Ctx) error {
 		product.Price = *updateFields.Price
 	}
 	if updateFields.Stock != nil {
-		product.Stock = *updateFields.Stock
-	}
-	if updateFields.Categories != nil {
-		var categories []models.Category
-		for _, categoryName := range *updateFields.Categories {
-			categories = append(categories, models.Category{Name: categoryName})
+		if *updateFields.Stock < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid stock")
+		}
+		if err := h.db.Where("product_id = ?", product.ID).First(&models.Variant{}).Error; err == nil {
+			return fiber.NewError(fiber.StatusConflict, "stock is managed per variant")
 		}
-		product.Categories = categories
 	}
 
 	product.Image = updateFields.Image
 
-	if err := h.db.Save(&product).Error; err != nil {
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	if err := tx.Save(&product).Error; err != nil {
+		tx.Rollback()
 		return fiber.NewError(fiber.StatusInternalServerError, "could not update product")
 	}
 
+	// Новый остаток записывается корректировкой от текущего, прочитанного под блокировкой
+	if updateFields.Stock != nil {
+		var locked models.Product
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&locked, "id = ?", product.ID).Error; err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not update product stock")
+		}
+
+		if err := adjustStock(tx, product.ID, nil, locked.Stock, *updateFields.Stock, user.ID, "stock updated"); err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not update product stock")
+		}
+	}
+
+	// Категории подключаются только существующие, по ID или slug
+	if updateFields.Categories != nil {
+		categories, err := findCategories(tx, *updateFields.Categories)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		association := tx.Model(&product).Association("Categories")
+		if len(categories) == 0 {
+			err = association.Clear()
+		} else {
+			err = association.Replace(categories)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not update product categories")
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
 	return c.SendStatus(fiber.StatusAccepted)
 }
 
@@ -176,6 +333,38 @@ func (h *ProductHandler) DeleteProduct(c *fiber.Ctx) error {
 	return c.SendStatus(fiber.StatusNoContent)
 }
 
+// GetReviews возвращает страницу отзывов о продукте, по умолчанию сначала новые
+func (h *ProductHandler) GetReviews(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	page, err := parseCursorPage(c, reviewSorts, "-created")
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.Select("id").First(&models.Product{}, "id = ? AND is_hidden = ?", parsedId, false).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "product not found")
+	}
+
+	var reviews []models.Review
+	query := h.db.Where("reviews.product_id = ?", parsedId)
+	if err := page.apply(query, "reviews").Find(&reviews).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve reviews")
+	}
+
+	reviews, next := paginate(page, reviews, func(review models.Review) (string, uuid.UUID) {
+		if page.key.column == "rating" {
+			return strconv.FormatFloat(review.Rating, 'g', -1, 64), review.ID
+		}
+		return cursorTime(review.CreatedAt), review.ID
+	})
+
+	return c.JSON(schemas.CursorPage[models.Review]{Items: reviews, NextCursor: next})
+}
+
 // CreateReview создает новый отзыв о продукте
 func (h *ProductHandler) CreateReview(c *fiber.Ctx) error {
 	parsedId, err := utils.ParseRouteID(c)
@@ -266,3 +455,67 @@ func (h *ProductHandler) RemoveFromFavorites(c *fiber.Ctx) error {
 
 	return c.SendStatus(fiber.StatusNoContent)
 }
+
+// reviewSorts сортировки списка отзывов
+var reviewSorts = map[string]sortKey{
+	"created": {column: "created_at", cast: "timestamptz"},
+	"rating":  {column: "rating", cast: "double precision"},
+}
+
+// productCursorValue возвращает значение столбца сортировки товара для курсора
+func productCursorValue(product models.Product, column string) string {
+	switch column {
+	case "price":
+		return strconv.FormatFloat(product.Price, 'f', -1, 64)
+	case "rating":
+		return strconv.FormatFloat(product.Rating, 'g', -1, 64)
+	case "favourites_count":
+		return strconv.Itoa(product.FavouritesCount)
+	default:
+		return cursorTime(product.CreatedAt)
+	}
+}
+
+// productResponses строит ответы для товаров с предзагруженными категориями, добавляя путь к категории
+func (h *ProductHandler) productResponses(products []models.Product) ([]schemas.ProductResponse, error) {
+	var ids []uuid.UUID
+	for _, product := range products {
+		for _, category := range product.Categories {
+			ids = append(ids, category.ID)
+		}
+	}
+
+	paths, err := categoryPaths(h.db, ids)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusInternalServerError, "could not retrieve categories")
+	}
+
+	responses := make([]schemas.ProductResponse, len(products))
+	for i, product := range products {
+		responses[i] = toProductResponse(product)
+		responses[i].Breadcrumb = productBreadcrumb(product.Categories, paths)
+	}
+
+	return responses, nil
+}
+
+func toProductResponse(product models.Product) schemas.ProductResponse {
+	categories := make([]schemas.CategorySummary, len(product.Categories))
+	for i, category := range product.Categories {
+		categories[i] = toCategorySummary(category)
+	}
+
+	return schemas.ProductResponse{
+		ID:           product.ID.String(),
+		UserID:       product.UserID.String(),
+		Name:         product.Name,
+		Description:  product.Description,
+		Price:        product.Price,
+		Stock:        product.Stock,
+		Image:        product.Image,
+		Rating:       product.Rating,
+		ReviewsCount: product.ReviewsCount,
+		Categories:   categories,
+		CreatedAt:    product.CreatedAt,
+	}
+}
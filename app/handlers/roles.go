@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"fusion/app/database/models"
+	"fusion/app/middleware"
+	"fusion/app/schemas"
+	"fusion/app/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+type RolesRoute struct {
+	db       *gorm.DB
+	validate *validator.Validate
+}
+
+// RegisterRoleRoutes регистрирует маршруты управления ролями
+func RegisterRoleRoutes(app *fiber.App, db *gorm.DB) {
+	handler := &RolesRoute{
+		db:       db,
+		validate: validator.New(),
+	}
+
+	requireRolesManage := middleware.AuthMiddleware(middleware.AllOf(models.PermissionRolesManage))
+
+	roleGroup := app.Group("/admin/roles", requireRolesManage)
+	roleGroup.Get("/", handler.GetRoles)
+	roleGroup.Post("/", handler.CreateRole)
+	roleGroup.Put("/:id", handler.UpdateRole)
+	roleGroup.Delete("/:id", handler.DeleteRole)
+
+	app.Get("/admin/users/:id/roles", requireRolesManage, handler.GetUserRoles)
+	app.Post("/admin/users/:id/roles", requireRolesManage, handler.GrantRole)
+	app.Delete("/admin/users/:id/roles/:role", requireRolesManage, handler.RevokeRole)
+}
+
+// GetRoles возвращает список ролей с их разрешениями
+func (h *RolesRoute) GetRoles(c *fiber.Ctx) error {
+	var roles []models.Role
+	if err := h.db.Preload("Permissions").Order("name").Find(&roles).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve roles")
+	}
+
+	return c.JSON(toRoleResponses(roles))
+}
+
+// CreateRole создает роль с набором разрешений
+func (h *RolesRoute) CreateRole(c *fiber.Ctx) error {
+	var input schemas.RoleCreateRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	if err := requireGrantable(c.Locals("current_user").(models.User), input.Permissions); err != nil {
+		return err
+	}
+
+	if err := h.db.Where("name = ?", input.Name).First(&models.Role{}).Error; err == nil {
+		return fiber.NewError(fiber.StatusConflict, "role already exists")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	permissions, err := findOrCreatePermissions(tx, input.Permissions)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	role := models.Role{
+		Name:        input.Name,
+		Description: input.Description,
+		Permissions: permissions,
+	}
+
+	if err := tx.Create(&role).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not create role")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(toRoleResponse(role))
+}
+
+// UpdateRole изменяет описание и набор разрешений роли
+func (h *RolesRoute) UpdateRole(c *fiber.Ctx) error {
+	roleID, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid role ID")
+	}
+
+	var input schemas.RoleUpdateRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	var role models.Role
+	if err := tx.Preload("Permissions").First(&role, roleID).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusNotFound, "role not found")
+	}
+
+	// Изменять можно только роль, все разрешения которой есть у самого пользователя
+	caller := c.Locals("current_user").(models.User)
+	if err := requireGrantable(caller, permissionNames(role.Permissions)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if input.Description != nil {
+		if err := tx.Model(&role).Update("description", *input.Description).Error; err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not update role")
+		}
+	}
+
+	if input.Permissions != nil {
+		if role.Name == models.AdminRole {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusBadRequest, "admin role permissions cannot be changed")
+		}
+
+		if err := requireGrantable(caller, *input.Permissions); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		permissions, err := findOrCreatePermissions(tx, *input.Permissions)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Model(&role).Association("Permissions").Replace(permissions); err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not update role permissions")
+		}
+		role.Permissions = permissions
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return c.JSON(toRoleResponse(role))
+}
+
+// DeleteRole удаляет роль и отзывает ее у всех пользователей
+func (h *RolesRoute) DeleteRole(c *fiber.Ctx) error {
+	roleID, err := c.ParamsInt("id")
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid role ID")
+	}
+
+	var role models.Role
+	if err := h.db.First(&role, roleID).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "role not found")
+	}
+
+	if role.Name == models.AdminRole {
+		return fiber.NewError(fiber.StatusBadRequest, "admin role cannot be deleted")
+	}
+
+	if err := h.db.Select("Permissions", "Users").Delete(&role).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not delete role")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetUserRoles возвращает роли пользователя
+func (h *RolesRoute) GetUserRoles(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := h.db.Preload("Roles.Permissions").First(&user, parsedId).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "user not found")
+	}
+
+	return c.JSON(toRoleResponses(user.Roles))
+}
+
+// GrantRole назначает пользователю роль
+func (h *RolesRoute) GrantRole(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	var input schemas.RoleGrantRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	var user models.User
+	if err := h.db.First(&user, parsedId).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "user not found")
+	}
+
+	var role models.Role
+	if err := h.db.Preload("Permissions").Where("name = ?", input.Role).First(&role).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "role not found")
+	}
+
+	if err := requireGrantable(c.Locals("current_user").(models.User), permissionNames(role.Permissions)); err != nil {
+		return err
+	}
+
+	if err := h.db.Model(&user).Association("Roles").Append(&role); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not grant role")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RevokeRole отзывает роль у пользователя
+func (h *RolesRoute) RevokeRole(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := h.db.First(&user, parsedId).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "user not found")
+	}
+
+	var role models.Role
+	if err := h.db.Where("name = ?", c.Params("role")).First(&role).Error; err != nil {
+		return fiber.NewError(fiber.StatusNotFound, "role not found")
+	}
+
+	if err := h.db.Model(&user).Association("Roles").Delete(&role); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not revoke role")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// findOrCreatePermissions возвращает разрешения по именам, создавая недостающие
+func findOrCreatePermissions(tx *gorm.DB, names []string) ([]models.Permission, error) {
+	permissions := make([]models.Permission, 0, len(names))
+	for _, name := range names {
+		if !models.IsValidPermission(name) {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "invalid permission: "+name)
+		}
+
+		var permission models.Permission
+		if err := tx.Where(models.Permission{Name: name}).FirstOrCreate(&permission).Error; err != nil {
+			return nil, fiber.NewError(fiber.StatusInternalServerError, "could not save permission")
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	return permissions, nil
+}
+
+// requireGrantable запрещает выдавать разрешения, которых нет у самого granter: так роль или
+// API-ключ не позволяют получить больше прав, чем есть у выдающего. Разрешение "*" может
+// выдать только владелец "*".
+func requireGrantable(granter models.User, names []string) error {
+	for _, name := range names {
+		if models.IsValidPermission(name) && !models.IsSelfScope(name) && !granter.HasPermission(name) {
+			return fiber.NewError(fiber.StatusForbidden, "permission not granted: "+name)
+		}
+	}
+
+	return nil
+}
+
+func permissionNames(permissions []models.Permission) []string {
+	names := make([]string, len(permissions))
+	for i, permission := range permissions {
+		names[i] = permission.Name
+	}
+
+	return names
+}
+
+func toRoleResponse(role models.Role) schemas.RoleResponse {
+	return schemas.RoleResponse{
+		ID:          role.ID,
+		Name:        role.Name,
+		Description: role.Description,
+		Permissions: permissionNames(role.Permissions),
+	}
+}
+
+func toRoleResponses(roles []models.Role) []schemas.RoleResponse {
+	response := make([]schemas.RoleResponse, len(roles))
+	for i, role := range roles {
+		response[i] = toRoleResponse(role)
+	}
+
+	return response
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"fusion/app/database/models"
+	"testing"
+)
+
+func TestRequireGrantable(t *testing.T) {
+	manager := models.User{Roles: []models.Role{{Permissions: []models.Permission{
+		{Name: models.PermissionRolesManage},
+		{Name: "products:*"},
+	}}}}
+	admin := models.User{Roles: []models.Role{{Permissions: []models.Permission{{Name: models.PermissionAll}}}}}
+
+	tests := []struct {
+		name    string
+		granter models.User
+		names   []string
+		wantErr bool
+	}{
+		{"own permissions", manager, []string{models.PermissionRolesManage, models.PermissionProductsModerate}, false},
+		{"covered by wildcard", manager, []string{"products:*"}, false},
+		{"missing permission", manager, []string{models.PermissionUsersBan}, true},
+		{"wildcard without wildcard", manager, []string{models.PermissionAll}, true},
+		{"wider wildcard", manager, []string{"users:*"}, true},
+		{"wildcard by wildcard", admin, []string{models.PermissionAll}, false},
+		{"self scope", manager, []string{models.ScopeCart}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := requireGrantable(tt.granter, tt.names); (err != nil) != tt.wantErr {
+				t.Errorf("requireGrantable(%v) error = %v, wantErr %v", tt.names, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"fusion/app/database/models"
+	"fusion/app/schemas"
+	"fusion/app/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const serviceAccountEmailDomain = "service-accounts.invalid"
+
+// GetServiceAccounts возвращает сервисные учетные записи
+func (h *AdminRoute) GetServiceAccounts(c *fiber.Ctx) error {
+	var accounts []models.User
+	if err := h.db.Where("is_service_account = ?", true).Order("username").Find(&accounts).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve service accounts")
+	}
+
+	response := make([]schemas.ServiceAccountResponse, len(accounts))
+	for i, account := range accounts {
+		response[i] = toServiceAccountResponse(account)
+	}
+
+	return c.JSON(response)
+}
+
+// CreateServiceAccount создает учетную запись для интеграции; войти в нее можно только по API-ключу
+func (h *AdminRoute) CreateServiceAccount(c *fiber.Ctx) error {
+	var input schemas.ServiceAccountCreateRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	username := "svc-" + input.Name
+	if err := h.db.Where("username = ?", username).First(&models.User{}).Error; err == nil {
+		return fiber.NewError(fiber.StatusConflict, "service account already exists")
+	}
+
+	account := models.User{
+		Username:         username,
+		Email:            uuid.New().String() + "@" + serviceAccountEmailDomain,
+		IsServiceAccount: true,
+	}
+
+	if err := h.db.Create(&account).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not create service account")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(toServiceAccountResponse(account))
+}
+
+// GetServiceAccountKeys возвращает ключи сервисной учетной записи
+func (h *AdminRoute) GetServiceAccountKeys(c *fiber.Ctx) error {
+	account, err := h.findServiceAccount(c)
+	if err != nil {
+		return err
+	}
+
+	response, err := listApiKeys(h.db, account.ID)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(response)
+}
+
+// CreateServiceAccountKey выпускает ключ сервисной учетной записи в пределах разрешений администратора
+func (h *AdminRoute) CreateServiceAccountKey(c *fiber.Ctx) error {
+	if c.Locals("current_api_key") != nil {
+		return fiber.NewError(fiber.StatusForbidden, "api keys cannot manage api keys")
+	}
+
+	account, err := h.findServiceAccount(c)
+	if err != nil {
+		return err
+	}
+
+	admin := c.Locals("current_user").(models.User)
+	response, err := issueApiKey(c, h.db, h.validate, account, admin)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+// RevokeServiceAccountKey отзывает ключ сервисной учетной записи
+func (h *AdminRoute) RevokeServiceAccountKey(c *fiber.Ctx) error {
+	account, err := h.findServiceAccount(c)
+	if err != nil {
+		return err
+	}
+
+	keyID, err := uuid.Parse(c.Params("key"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid api key ID")
+	}
+
+	if err := revokeApiKey(h.db, account.ID, keyID); err != nil {
+		return err
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *AdminRoute) findServiceAccount(c *fiber.Ctx) (models.User, error) {
+	var account models.User
+
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return account, err
+	}
+
+	if err := h.db.Where("is_service_account = ?", true).First(&account, parsedId).Error; err != nil {
+		return account, fiber.NewError(fiber.StatusNotFound, "service account not found")
+	}
+
+	return account, nil
+}
+
+func toServiceAccountResponse(account models.User) schemas.ServiceAccountResponse {
+	return schemas.ServiceAccountResponse{
+		ID:        account.ID,
+		Username:  account.Username,
+		CreatedAt: account.CreatedAt,
+	}
+}
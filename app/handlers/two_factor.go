@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"fusion/app/database/models"
+	"fusion/app/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	twoFactorIssuer     = "Fusion"
+	recoveryCodesCount  = 8
+	twoFactorVerifyType = "TWO_FACTOR_CHALLENGE"
+	// twoFactorMaxAttempts после стольких неверных кодов токен испытания сгорает и вход начинается заново
+	twoFactorMaxAttempts = 5
+)
+
+// createTwoFactorChallenge создает одноразовый токен для второго шага входа
+func (h AuthRoute) createTwoFactorChallenge(user models.User) (string, error) {
+	token := uuid.New().String()
+	verification := models.Verification{
+		Type:      twoFactorVerifyType,
+		UserID:    user.ID,
+		Token:     token,
+		ExpiresAt: time.Now().Add(h.config.TwoFactorExpire),
+	}
+
+	if err := h.db.Create(&verification).Error; err != nil {
+		return "", fiber.NewError(fiber.StatusInternalServerError, "could not create two-factor challenge")
+	}
+
+	return token, nil
+}
+
+// VerifyTwoFactor завершает вход по токену испытания и коду TOTP или коду восстановления.
+// Неверные коды считаются на токене испытания и ограничителем входа, как неверные пароли.
+func (h AuthRoute) VerifyTwoFactor(c *fiber.Ctx) error {
+	type VerifyInput struct {
+		ChallengeToken string `json:"challenge_token" validate:"required"`
+		Code           string `json:"code" validate:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+		RecoveryCode   string `json:"recovery_code" validate:"required_without=Code"`
+	}
+
+	var input VerifyInput
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	var verification models.Verification
+	if err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token = ? AND type = ? AND expires_at > ?", input.ChallengeToken, twoFactorVerifyType, time.Now()).
+		First(&verification).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusUnauthorized, "challenge token not found or expired")
+	}
+
+	var user models.User
+	if err := tx.First(&user, verification.UserID).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusUnauthorized, "user not found")
+	}
+
+	wait, err := h.guard.Wait(utils.AccountKey(user.Email), utils.IPKey(c.IP()))
+	if err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not check login attempts")
+	}
+
+	if wait > 0 {
+		tx.Rollback()
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return fiber.NewError(fiber.StatusTooManyRequests, "too many login attempts, try again later")
+	}
+
+	ok, err := h.checkSecondFactor(tx, &user, input.Code, input.RecoveryCode)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if !ok {
+		if verification.Attempts+1 >= twoFactorMaxAttempts {
+			if err := tx.Delete(&verification).Error; err != nil {
+				tx.Rollback()
+				return fiber.NewError(fiber.StatusInternalServerError, "could not delete challenge token")
+			}
+		} else if err := tx.Model(&verification).Update("attempts", verification.Attempts+1).Error; err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not update challenge token")
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+		}
+
+		if err := h.registerLoginFailure(c, user.Email, &user); err != nil {
+			return err
+		}
+
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid two-factor code")
+	}
+
+	if err := tx.Delete(&verification).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not delete challenge token")
+	}
+
+	accessToken, refreshToken, err := h.issueSession(tx, user.ID, uuid.New(), c)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	if err := h.guard.Reset(utils.AccountKey(user.Email)); err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not reset login attempts")
+	}
+
+	return h.sendTokens(c, accessToken, refreshToken, h.wantsCookies(c))
+}
+
+// EnrollTwoFactor выпускает новый секрет TOTP и коды восстановления для текущего пользователя по паролю
+func (h AuthRoute) EnrollTwoFactor(c *fiber.Ctx) error {
+	type EnrollInput struct {
+		Password string `json:"password" validate:"required"`
+	}
+
+	var input EnrollInput
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	user := c.Locals("current_user").(models.User)
+	if user.IsTwoFactorEnabled {
+		return fiber.NewError(fiber.StatusConflict, "two-factor authentication already enabled")
+	}
+
+	if !utils.CheckPasswordHash(input.Password, user.Password) {
+		return fiber.NewError(fiber.StatusUnauthorized, "incorrect password")
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not generate secret")
+	}
+
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not generate recovery codes")
+	}
+
+	recoveryCodes, err := hashRecoveryCodes(user.ID, codes)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not hash recovery codes")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	if err := tx.Model(&user).Updates(map[string]interface{}{
+		"two_factor_secret":    secret,
+		"two_factor_last_step": 0,
+	}).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not save secret")
+	}
+
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not replace recovery codes")
+	}
+
+	if err := tx.Create(&recoveryCodes).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not save recovery codes")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return c.JSON(fiber.Map{
+		"secret":           secret,
+		"provisioning_uri": utils.TOTPProvisioningURI(secret, twoFactorIssuer, user.Email),
+		"recovery_codes":   codes,
+	})
+}
+
+// ConfirmTwoFactor включает двухфакторную аутентификацию после проверки пароля и первого кода
+func (h AuthRoute) ConfirmTwoFactor(c *fiber.Ctx) error {
+	type ConfirmInput struct {
+		Password string `json:"password" validate:"required"`
+		Code     string `json:"code" validate:"required,len=6,numeric"`
+	}
+
+	var input ConfirmInput
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	user := c.Locals("current_user").(models.User)
+	if user.IsTwoFactorEnabled {
+		return fiber.NewError(fiber.StatusConflict, "two-factor authentication already enabled")
+	}
+
+	if !utils.CheckPasswordHash(input.Password, user.Password) {
+		return fiber.NewError(fiber.StatusUnauthorized, "incorrect password")
+	}
+
+	if user.TwoFactorSecret == nil {
+		return fiber.NewError(fiber.StatusBadRequest, "two-factor enrollment not started")
+	}
+
+	step, ok := utils.ValidateTOTP(*user.TwoFactorSecret, input.Code, user.TwoFactorLastStep, time.Now())
+	if !ok {
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid two-factor code")
+	}
+
+	if err := h.db.Model(&user).Updates(map[string]interface{}{
+		"is_two_factor_enabled": true,
+		"two_factor_last_step":  step,
+	}).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not enable two-factor authentication")
+	}
+
+	return c.JSON(fiber.Map{"message": "two-factor authentication enabled"})
+}
+
+// DisableTwoFactor отключает двухфакторную аутентификацию по паролю и действующему коду
+func (h AuthRoute) DisableTwoFactor(c *fiber.Ctx) error {
+	type DisableInput struct {
+		Password     string `json:"password" validate:"required"`
+		Code         string `json:"code" validate:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+		RecoveryCode string `json:"recovery_code" validate:"required_without=Code"`
+	}
+
+	var input DisableInput
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	user := c.Locals("current_user").(models.User)
+	if !user.IsTwoFactorEnabled {
+		return fiber.NewError(fiber.StatusBadRequest, "two-factor authentication not enabled")
+	}
+
+	if !utils.CheckPasswordHash(input.Password, user.Password) {
+		return fiber.NewError(fiber.StatusUnauthorized, "incorrect password")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	ok, err := h.checkSecondFactor(tx, &user, input.Code, input.RecoveryCode)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if !ok {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusUnauthorized, "invalid two-factor code")
+	}
+
+	if err := tx.Model(&user).Updates(map[string]interface{}{
+		"two_factor_secret":     nil,
+		"is_two_factor_enabled": false,
+		"two_factor_last_step":  0,
+	}).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not disable two-factor authentication")
+	}
+
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not delete recovery codes")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return c.JSON(fiber.Map{"message": "two-factor authentication disabled"})
+}
+
+// checkSecondFactor проверяет код TOTP либо гасит код восстановления пользователя. Строка пользователя
+// блокируется до конца транзакции, чтобы один код нельзя было использовать в двух запросах одновременно.
+func (h AuthRoute) checkSecondFactor(tx *gorm.DB, user *models.User, code, recoveryCode string) (bool, error) {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(user, user.ID).Error; err != nil {
+		return false, fiber.NewError(fiber.StatusInternalServerError, "could not retrieve two-factor state")
+	}
+
+	if code != "" {
+		if user.TwoFactorSecret == nil {
+			return false, nil
+		}
+
+		step, ok := utils.ValidateTOTP(*user.TwoFactorSecret, code, user.TwoFactorLastStep, time.Now())
+		if !ok {
+			return false, nil
+		}
+
+		if err := tx.Model(user).Update("two_factor_last_step", step).Error; err != nil {
+			return false, fiber.NewError(fiber.StatusInternalServerError, "could not save two-factor state")
+		}
+
+		return true, nil
+	}
+
+	var codes []models.RecoveryCode
+	if err := tx.Where("user_id = ? AND used_at IS NULL", user.ID).Find(&codes).Error; err != nil {
+		return false, fiber.NewError(fiber.StatusInternalServerError, "could not retrieve recovery codes")
+	}
+
+	for _, stored := range codes {
+		if utils.CheckPasswordHash(recoveryCode, stored.Hash) {
+			if err := tx.Model(&stored).Update("used_at", time.Now()).Error; err != nil {
+				return false, fiber.NewError(fiber.StatusInternalServerError, "could not use recovery code")
+			}
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// hashRecoveryCodes хеширует коды восстановления параллельно, так как bcrypt намеренно медленный
+func hashRecoveryCodes(userID uuid.UUID, codes []string) ([]models.RecoveryCode, error) {
+	recoveryCodes := make([]models.RecoveryCode, len(codes))
+	errs := make([]error, len(codes))
+
+	var wg sync.WaitGroup
+	for i, code := range codes {
+		wg.Add(1)
+		go func(i int, code string) {
+			defer wg.Done()
+			hash, err := utils.HashPassword(code)
+			recoveryCodes[i] = models.RecoveryCode{UserID: userID, Hash: hash}
+			errs[i] = err
+		}(i, code)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return recoveryCodes, nil
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"fusion/app/database/models"
+	"fusion/app/utils"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTwoFactorRequiresPassword(t *testing.T) {
+	// Минимальная стоимость bcrypt, чтобы тест не ждал хеширования
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Password: string(hash), TwoFactorSecret: &secret}
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("current_user", user)
+		return c.Next()
+	})
+	handler := AuthRoute{validate: validator.New()}
+	app.Post("/auth/2fa/enroll", handler.EnrollTwoFactor)
+	app.Post("/auth/2fa/confirm", handler.ConfirmTwoFactor)
+
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		status  int
+		message string
+	}{
+		{"enroll without password", "/auth/2fa/enroll", `{}`, fiber.StatusBadRequest, "invalid input data"},
+		{"enroll with wrong password", "/auth/2fa/enroll", `{"password":"wrong"}`, fiber.StatusUnauthorized, "incorrect password"},
+		{"confirm without password", "/auth/2fa/confirm", `{"code":"000000"}`, fiber.StatusBadRequest, "invalid input data"},
+		{"confirm with wrong password", "/auth/2fa/confirm", `{"password":"wrong","code":"000000"}`, fiber.StatusUnauthorized, "incorrect password"},
+		// С верным паролем проверка доходит до кода
+		{"confirm with correct password", "/auth/2fa/confirm", `{"password":"correct-password","code":"000000"}`, fiber.StatusUnauthorized, "invalid two-factor code"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			request.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+			response, err := app.Test(request, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(response.Body)
+			if response.StatusCode != tt.status || string(body) != tt.message {
+				t.Errorf("%s = %d %q, want %d %q", tt.path, response.StatusCode, body, tt.status, tt.message)
+			}
+		})
+	}
+}
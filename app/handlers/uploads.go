@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"fusion/app/utils"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"mime"
+	"mime/multipart"
+)
+
+// imageContentTypes типы, которые принимаются при загрузке; фактический тип определяется по содержимому
+var imageContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// readImageUpload проверяет заявленный тип и размер файла и читает его содержимое
+func readImageUpload(file *multipart.FileHeader, maxSize int) ([]byte, error) {
+	if file.Size > int64(maxSize) {
+		return nil, fiber.NewError(fiber.StatusRequestEntityTooLarge, "file is too large")
+	}
+
+	contentType, _, err := mime.ParseMediaType(file.Header.Get("Content-Type"))
+	if err != nil || !imageContentTypes[contentType] {
+		return nil, fiber.NewError(fiber.StatusUnsupportedMediaType, "unsupported image type")
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "could not read file")
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "could not read file")
+	}
+	if len(data) > maxSize {
+		return nil, fiber.NewError(fiber.StatusRequestEntityTooLarge, "file is too large")
+	}
+
+	return data, nil
+}
+
+// imageError переводит ошибки обработки изображения в ответы API
+func imageError(err error) error {
+	switch {
+	case errors.Is(err, utils.ErrUnsupportedImage):
+		return fiber.NewError(fiber.StatusUnsupportedMediaType, "unsupported image type")
+	case errors.Is(err, utils.ErrImageTooLarge):
+		return fiber.NewError(fiber.StatusRequestEntityTooLarge, "image dimensions are too large")
+	default:
+		return fiber.NewError(fiber.StatusInternalServerError, "could not store image")
+	}
+}
@@ -4,41 +4,73 @@ import (
 	"fusion/app/database/models"
 	"fusion/app/middleware"
 	"fusion/app/schemas"
+	"fusion/app/utils"
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"time"
 )
 
 type UsersRoute struct {
+	config   utils.AppConfig
+	email    utils.EmailService
+	sms      utils.SMSService
+	storage  utils.BlobStorage
 	db       *gorm.DB
 	validate *validator.Validate
 }
 
-func RegisterUserRoutes(app *fiber.App, db *gorm.DB) {
+// RegisterUserRoutes регистрирует маршруты пользователей. Загрузка аватара регистрируется
+// на сервере uploads, который принимает тело больше стандартного ограничения; без sms
+// подтверждение телефона недоступно
+func RegisterUserRoutes(app, uploads *fiber.App, db *gorm.DB, config utils.AppConfig, email utils.EmailService, sms utils.SMSService, storage utils.BlobStorage) {
 	handler := UsersRoute{
+		config:   config,
+		email:    email,
+		sms:      sms,
+		storage:  storage,
 		db:       db,
 		validate: validator.New(),
 	}
 
 	userGroup := app.Group("/users")
 	meGroup := userGroup.Group("/me")
-	meGroup.Use(middleware.AuthMiddleware())
+	meGroup.Use(middleware.AuthMiddleware(middleware.NoApiKey))
 	meGroup.Get("/", handler.getCurrentUser)
-	meGroup.Patch("/", handler.updateUser)
-	meGroup.Delete("/", handler.deleteUser)
+	meGroup.Patch("/", middleware.NoImpersonation, handler.updateUser)
+	meGroup.Delete("/", middleware.NoImpersonation, handler.deleteUser)
+	meGroup.Delete("/avatar", middleware.NoImpersonation, handler.removeAvatar)
+	meGroup.Get("/sessions", handler.getSessions)
+	meGroup.Delete("/sessions", middleware.NoImpersonation, handler.revokeOtherSessions)
+	meGroup.Delete("/sessions/:id", middleware.NoImpersonation, handler.revokeSession)
+	if sms != nil {
+		meGroup.Post("/phone", middleware.NoImpersonation, handler.requestPhoneVerification)
+		meGroup.Post("/phone/confirm", handler.confirmPhone)
+	}
+	meGroup.Delete("/phone", middleware.NoImpersonation, handler.removePhone)
+	meGroup.Get("/api-keys", handler.getApiKeys)
+	meGroup.Post("/api-keys", middleware.NoImpersonation, handler.createApiKey)
+	meGroup.Delete("/api-keys/:id", middleware.NoImpersonation, handler.revokeApiKey)
 
+	userGroup.Post("/email/confirm", handler.confirmEmailChange)
+	userGroup.Post("/email/cancel", handler.cancelEmailChange)
 	userGroup.Get("/:id", handler.getUserById)
+
+	uploads.Post("/users/me/avatar", middleware.AuthMiddleware(middleware.NoApiKey), middleware.NoImpersonation, handler.uploadAvatar)
 }
 
 // getCurrentUser возвращает список всех пользователей
 func (h UsersRoute) getCurrentUser(c *fiber.Ctx) error {
 	user := c.Locals("current_user").(models.User)
 	response := schemas.UserMeResponse{
-		ID:       user.ID,
-		Username: user.Username,
-		Email:    user.Email,
-		Avatar:   user.Avatar,
+		ID:              user.ID,
+		Username:        user.Username,
+		Email:           user.Email,
+		Phone:           user.Phone,
+		IsPhoneVerified: user.IsPhoneVerified,
+		Avatar:          user.Avatar,
+		AvatarURLs:      h.avatarURLs(user),
 	}
 
 	return c.JSON(response)
@@ -58,14 +90,17 @@ func (h UsersRoute) getUserById(c *fiber.Ctx) error {
 	}
 
 	response := schemas.UserResponse{
-		ID:       user.ID,
-		Username: user.Username,
-		Avatar:   user.Avatar,
+		ID:         user.ID,
+		Username:   user.Username,
+		Avatar:     user.Avatar,
+		AvatarURLs: h.avatarURLs(user),
 	}
 
 	return c.JSON(response)
 }
 
+// updateUser изменяет профиль текущего пользователя; смена email требует текущий пароль
+// и вступает в силу только после подтверждения
 func (h UsersRoute) updateUser(c *fiber.Ctx) error {
 	user := c.Locals("current_user").(models.User)
 	var input schemas.UserUpdateRequest
@@ -73,18 +108,42 @@ func (h UsersRoute) updateUser(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, "could not parse request body")
 	}
 
-	if input.Username != nil {
-		user.Username = *input.Username
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	// Пароль проверяется до изменения профиля, чтобы запрос с неверным паролем ничего не менял
+	changeEmail := input.Email != nil && *input.Email != user.Email
+	if changeEmail && !utils.CheckPasswordHash(*input.Password, user.Password) {
+		return fiber.NewError(fiber.StatusUnauthorized, "incorrect password")
 	}
-	if input.Email != nil {
-		user.Email = *input.Email
+
+	updates := map[string]interface{}{}
+	if input.Username != nil {
+		updates["username"] = *input.Username
 	}
+	// Аватар по внешней ссылке заменяет загруженный
 	if input.Avatar != nil {
-		user.Avatar = input.Avatar
+		updates["avatar"] = *input.Avatar
+		updates["avatar_key"] = nil
 	}
 
-	if err := h.db.Save(&user).Error; err != nil {
-		return fiber.NewError(fiber.StatusInternalServerError, "could not update user")
+	if len(updates) > 0 {
+		if err := h.db.Model(&user).Updates(updates).Error; err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "could not update user")
+		}
+
+		if input.Avatar != nil && user.AvatarKey != nil {
+			h.deleteAvatar(*user.AvatarKey)
+		}
+	}
+
+	if changeEmail {
+		if err := h.requestEmailChange(user, *input.Email, *input.RedirectUrl, *input.CancelUrl); err != nil {
+			return err
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "please confirm your new email address"})
 	}
 
 	return c.SendStatus(fiber.StatusAccepted)
@@ -99,3 +158,67 @@ func (h UsersRoute) deleteUser(c *fiber.Ctx) error {
 
 	return c.SendStatus(fiber.StatusNoContent)
 }
+
+// getSessions возвращает активные сессии текущего пользователя
+func (h UsersRoute) getSessions(c *fiber.Ctx) error {
+	user := c.Locals("current_user").(models.User)
+	currentSession := c.Locals("current_session").(uuid.UUID)
+
+	var sessions []models.Session
+	if err := h.db.
+		Where("user_id = ? AND is_active = ? AND expires_at > ?", user.ID, true, time.Now()).
+		Order("issued_at desc").
+		Find(&sessions).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve sessions")
+	}
+
+	response := make([]schemas.SessionResponse, len(sessions))
+	for i, session := range sessions {
+		response[i] = schemas.SessionResponse{
+			ID:        session.FamilyID,
+			Agent:     session.Agent,
+			IP:        session.IP,
+			Current:   session.FamilyID == currentSession,
+			IssuedAt:  session.IssuedAt,
+			ExpiresAt: session.ExpiresAt,
+		}
+	}
+
+	return c.JSON(response)
+}
+
+// revokeSession завершает сессию текущего пользователя по ID
+func (h UsersRoute) revokeSession(c *fiber.Ctx) error {
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return err
+	}
+
+	user := c.Locals("current_user").(models.User)
+	result := h.db.Model(&models.Session{}).
+		Where("user_id = ? AND family_id = ? AND is_active = ?", user.ID, parsedId, true).
+		Update("is_active", false)
+	if result.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not revoke session")
+	}
+
+	if result.RowsAffected == 0 {
+		return fiber.NewError(fiber.StatusNotFound, "session not found")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// revokeOtherSessions завершает все сессии текущего пользователя, кроме текущей
+func (h UsersRoute) revokeOtherSessions(c *fiber.Ctx) error {
+	user := c.Locals("current_user").(models.User)
+	currentSession := c.Locals("current_session").(uuid.UUID)
+
+	if err := h.db.Model(&models.Session{}).
+		Where("user_id = ? AND family_id <> ? AND is_active = ?", user.ID, currentSession, true).
+		Update("is_active", false).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not revoke sessions")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
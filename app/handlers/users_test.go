@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"fusion/app/database/models"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUpdateUserEmailRequiresPassword(t *testing.T) {
+	// Минимальная стоимость bcrypt, чтобы тест не ждал хеширования
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct-password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{Email: "user@example.com", Password: string(hash)}
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("current_user", user)
+		return c.Next()
+	})
+	handler := UsersRoute{validate: validator.New()}
+	app.Patch("/users/me", handler.updateUser)
+
+	urls := `"redirect_url":"https://shop.example.com/confirm","cancel_url":"https://shop.example.com/cancel"`
+	tests := []struct {
+		name    string
+		body    string
+		status  int
+		message string
+	}{
+		{"without password", `{"email":"new@example.com",` + urls + `}`, fiber.StatusBadRequest, "invalid input data"},
+		{"with wrong password", `{"email":"new@example.com","password":"wrong",` + urls + `}`, fiber.StatusUnauthorized, "incorrect password"},
+		// Неверный пароль отклоняет весь запрос, в том числе другие изменения профиля
+		{"with wrong password and username", `{"email":"new@example.com","username":"renamed","password":"wrong",` + urls + `}`, fiber.StatusUnauthorized, "incorrect password"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest("PATCH", "/users/me", strings.NewReader(tt.body))
+			request.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+			response, err := app.Test(request, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(response.Body)
+			if response.StatusCode != tt.status || string(body) != tt.message {
+				t.Errorf("status = %d %q, want %d %q", response.StatusCode, body, tt.status, tt.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"fusion/app/database/models"
+	"fusion/app/schemas"
+	"fusion/app/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sort"
+	"strings"
+)
+
+// CreateVariant добавляет вариант товара; недостающие измерения и значения создаются
+func (h *ProductHandler) CreateVariant(c *fiber.Ctx) error {
+	user := c.Locals("current_user").(models.User)
+
+	var input schemas.VariantCreateRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	options := make(map[string]string, len(input.Options))
+	for name, value := range input.Options {
+		name, value = strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(value)
+		if name == "" || value == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid variant options")
+		}
+		options[name] = value
+	}
+	if len(options) != len(input.Options) {
+		return fiber.NewError(fiber.StatusBadRequest, "duplicate variant options")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	// Блокировка товара упорядочивает одновременное создание вариантов и измерений
+	product, err := h.ownedProduct(tx.Clauses(clause.Locking{Strength: "UPDATE"}), c)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var existing models.Variant
+	if err := tx.Where("product_id = ?", product.ID).First(&existing).Error; err == nil {
+		names, err := variantOptionNames(tx, existing)
+		if err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not create variant")
+		}
+		if !sameOptionNames(names, options) {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusBadRequest, "variant options must be: "+strings.Join(names, ", "))
+		}
+	}
+
+	key := variantOptionKey(options)
+	if err := tx.Where("product_id = ? AND option_key = ?", product.ID, key).First(&models.Variant{}).Error; err == nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusConflict, "variant with these options already exists")
+	}
+
+	if err := tx.Where("sku = ?", input.SKU).First(&models.Variant{}).Error; err == nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusConflict, "sku already in use")
+	}
+
+	values, err := findOrCreateOptionValues(tx, product.ID, options)
+	if err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not create variant options")
+	}
+
+	variant := models.Variant{
+		ProductID: product.ID,
+		SKU:       input.SKU,
+		Price:     input.Price,
+		Stock:     input.Stock,
+		Image:     input.Image,
+		OptionKey: key,
+		Options:   values,
+	}
+
+	if err := tx.Create(&variant).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not create variant")
+	}
+
+	// С первым вариантом остаток товара начинает складываться из остатков вариантов,
+	// поэтому собственный остаток товара списывается
+	if existing.ID == uuid.Nil {
+		if err := adjustStock(tx, product.ID, nil, product.Stock, 0, user.ID, "stock moved to variants"); err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not update product stock")
+		}
+	}
+
+	if variant.Stock > 0 {
+		if err := tx.Create(&models.StockMovement{
+			ProductID: product.ID,
+			VariantID: &variant.ID,
+			Kind:      models.StockReceipt,
+			Quantity:  variant.Stock,
+			Reason:    "initial stock",
+			ActorID:   &user.ID,
+		}).Error; err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not update product stock")
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(toVariantResponse(product, variant, options))
+}
+
+// UpdateVariant изменяет артикул, цену, остаток или изображение варианта
+func (h *ProductHandler) UpdateVariant(c *fiber.Ctx) error {
+	user := c.Locals("current_user").(models.User)
+
+	var input schemas.VariantUpdateRequest
+	if err := c.BodyParser(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input")
+	}
+
+	if err := h.validate.Struct(&input); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "invalid input data")
+	}
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	product, variant, err := h.ownedVariant(tx, c)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	updates := make(map[string]interface{})
+	if input.SKU != nil && *input.SKU != variant.SKU {
+		if err := tx.Where("sku = ?", *input.SKU).First(&models.Variant{}).Error; err == nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusConflict, "sku already in use")
+		}
+		variant.SKU = *input.SKU
+		updates["sku"] = variant.SKU
+	}
+	if input.Price != nil {
+		variant.Price = input.Price
+		updates["price"] = variant.Price
+	}
+	if input.Image != nil {
+		variant.Image = input.Image
+		updates["image"] = variant.Image
+	}
+
+	if len(updates) > 0 {
+		if err := tx.Model(&variant).Updates(updates).Error; err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not update variant")
+		}
+	}
+
+	if input.Stock != nil {
+		if err := adjustStock(tx, product.ID, &variant.ID, variant.Stock, *input.Stock, user.ID, "stock updated"); err != nil {
+			tx.Rollback()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not update product stock")
+		}
+		variant.Stock = *input.Stock
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	options, err := variantOptions(h.db, variant)
+	if err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not retrieve variant options")
+	}
+
+	return c.JSON(toVariantResponse(product, variant, options))
+}
+
+// DeleteVariant удаляет вариант и убирает его из корзин
+func (h *ProductHandler) DeleteVariant(c *fiber.Ctx) error {
+	user := c.Locals("current_user").(models.User)
+
+	tx := h.db.Begin()
+	if tx.Error != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not start transaction")
+	}
+
+	product, variant, err := h.ownedVariant(tx, c)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Where("variant_id = ?", variant.ID).Delete(&models.CartProduct{}).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not remove variant from carts")
+	}
+
+	// Остаток удаленного варианта списывается, чтобы не учитываться в остатке товара
+	if err := adjustStock(tx, product.ID, &variant.ID, variant.Stock, 0, user.ID, "variant deleted"); err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not update product stock")
+	}
+
+	if err := tx.Delete(&variant).Error; err != nil {
+		tx.Rollback()
+		return fiber.NewError(fiber.StatusInternalServerError, "could not delete variant")
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not commit transaction")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ownedProduct возвращает товар из маршрута, если он принадлежит текущему пользователю
+func (h *ProductHandler) ownedProduct(tx *gorm.DB, c *fiber.Ctx) (models.Product, error) {
+	var product models.Product
+
+	parsedId, err := utils.ParseRouteID(c)
+	if err != nil {
+		return product, err
+	}
+
+	user := c.Locals("current_user").(models.User)
+	if err := tx.Where("id = ? AND user_id = ?", parsedId, user.ID).First(&product).Error; err != nil {
+		return product, fiber.NewError(fiber.StatusNotFound, "product not found or access denied")
+	}
+
+	return product, nil
+}
+
+// ownedVariant возвращает вариант из маршрута вместе с товаром текущего пользователя
+func (h *ProductHandler) ownedVariant(tx *gorm.DB, c *fiber.Ctx) (models.Product, models.Variant, error) {
+	var variant models.Variant
+
+	product, err := h.ownedProduct(tx.Clauses(clause.Locking{Strength: "UPDATE"}), c)
+	if err != nil {
+		return product, variant, err
+	}
+
+	variantID, err := uuid.Parse(c.Params("variant"))
+	if err != nil {
+		return product, variant, fiber.NewError(fiber.StatusBadRequest, "invalid variant ID")
+	}
+
+	if err := tx.Where("id = ? AND product_id = ?", variantID, product.ID).First(&variant).Error; err != nil {
+		return product, variant, fiber.NewError(fiber.StatusNotFound, "variant not found")
+	}
+
+	return product, variant, nil
+}
+
+// findOrCreateOptionValues возвращает значения измерений товара, создавая отсутствующие
+func findOrCreateOptionValues(tx *gorm.DB, productID uuid.UUID, options map[string]string) ([]models.OptionValue, error) {
+	values := make([]models.OptionValue, 0, len(options))
+	for name, value := range options {
+		optionType := models.OptionType{ProductID: productID, Name: name}
+		if err := tx.Where(optionType).FirstOrCreate(&optionType).Error; err != nil {
+			return nil, err
+		}
+
+		optionValue := models.OptionValue{OptionTypeID: optionType.ID, Value: value}
+		if err := tx.Where(optionValue).FirstOrCreate(&optionValue).Error; err != nil {
+			return nil, err
+		}
+
+		values = append(values, optionValue)
+	}
+
+	return values, nil
+}
+
+// variantOptions возвращает значения варианта по названиям измерений
+func variantOptions(tx *gorm.DB, variant models.Variant) (map[string]string, error) {
+	var rows []struct {
+		Name  string
+		Value string
+	}
+
+	if err := tx.Table("variant_option_values").
+		Select("option_types.name, option_values.value").
+		Joins("JOIN option_values ON option_values.id = variant_option_values.option_value_id").
+		Joins("JOIN option_types ON option_types.id = option_values.option_type_id").
+		Where("variant_option_values.variant_id = ?", variant.ID).
+		Scan(&rows).
+		Error; err != nil {
+		return nil, err
+	}
+
+	options := make(map[string]string, len(rows))
+	for _, row := range rows {
+		options[row.Name] = row.Value
+	}
+
+	return options, nil
+}
+
+// variantOptionNames возвращает отсортированные названия измерений варианта
+func variantOptionNames(tx *gorm.DB, variant models.Variant) ([]string, error) {
+	options, err := variantOptions(tx, variant)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func sameOptionNames(names []string, options map[string]string) bool {
+	if len(names) != len(options) {
+		return false
+	}
+
+	for _, name := range names {
+		if _, ok := options[name]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// variantOptionKey записывает набор значений в каноническом виде "color=red;size=M"
+func variantOptionKey(options map[string]string) string {
+	pairs := make([]string, 0, len(options))
+	for name, value := range options {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ";")
+}
+
+// variantMatrix строит измерения товара с используемыми значениями и список вариантов.
+// Товар должен быть загружен с OptionTypes.Values и Variants.Options.
+func variantMatrix(product models.Product) ([]schemas.OptionTypeResponse, []schemas.VariantResponse) {
+	if len(product.Variants) == 0 {
+		return nil, nil
+	}
+
+	used := make(map[uuid.UUID]bool)
+	for _, variant := range product.Variants {
+		for _, value := range variant.Options {
+			used[value.ID] = true
+		}
+	}
+
+	optionTypes := make([]models.OptionType, len(product.OptionTypes))
+	copy(optionTypes, product.OptionTypes)
+	sort.SliceStable(optionTypes, func(i, j int) bool {
+		return optionTypes[i].CreatedAt.Before(optionTypes[j].CreatedAt)
+	})
+
+	typeNames := make(map[uuid.UUID]string)
+	options := make([]schemas.OptionTypeResponse, 0, len(optionTypes))
+	for _, optionType := range optionTypes {
+		typeNames[optionType.ID] = optionType.Name
+
+		values := make([]models.OptionValue, len(optionType.Values))
+		copy(values, optionType.Values)
+		sort.SliceStable(values, func(i, j int) bool {
+			return values[i].CreatedAt.Before(values[j].CreatedAt)
+		})
+
+		response := schemas.OptionTypeResponse{Name: optionType.Name, Values: []string{}}
+		for _, value := range values {
+			if used[value.ID] {
+				response.Values = append(response.Values, value.Value)
+			}
+		}
+		if len(response.Values) > 0 {
+			options = append(options, response)
+		}
+	}
+
+	variants := make([]schemas.VariantResponse, len(product.Variants))
+	for i, variant := range product.Variants {
+		values := make(map[string]string, len(variant.Options))
+		for _, value := range variant.Options {
+			values[typeNames[value.OptionTypeID]] = value.Value
+		}
+		variants[i] = toVariantResponse(product, variant, values)
+	}
+
+	return options, variants
+}
+
+func toVariantResponse(product models.Product, variant models.Variant, options map[string]string) schemas.VariantResponse {
+	return schemas.VariantResponse{
+		ID:      variant.ID,
+		SKU:     variant.SKU,
+		Price:   variant.EffectivePrice(product),
+		Stock:   variant.Stock,
+		InStock: variant.Stock > 0,
+		Image:   variant.Image,
+		Options: options,
+	}
+}
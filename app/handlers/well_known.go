@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"fusion/app/utils"
+	"github.com/gofiber/fiber/v2"
+)
+
+type WellKnownRoute struct {
+	jwt utils.JWTService
+}
+
+// RegisterWellKnownRoutes регистрирует публичные служебные маршруты
+func RegisterWellKnownRoutes(app *fiber.App, jwtService utils.JWTService) {
+	handler := &WellKnownRoute{
+		jwt: jwtService,
+	}
+
+	app.Get("/.well-known/jwks.json", handler.GetJWKS)
+}
+
+// GetJWKS возвращает публичные ключи для проверки access-токенов
+func (h *WellKnownRoute) GetJWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(h.jwt.PublicKeys())
+}
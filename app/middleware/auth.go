@@ -1,21 +1,75 @@
 package middleware
 
 import (
+	"errors"
 	"fusion/app/database/models"
 	"fusion/app/utils"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"log"
 	"strings"
+	"time"
 )
 
-// AuthMiddleware проверяет токен доступа пользователя
-func AuthMiddleware(permissions ...string) fiber.Handler {
+const apiKeyTouchInterval = time.Minute
+
+// Requirement проверка прав, которую должен пройти пользователь
+type Requirement func(user *models.User) bool
+
+// AllOf требует наличия всех перечисленных разрешений
+func AllOf(permissions ...string) Requirement {
+	return func(user *models.User) bool {
+		return user.HasAllPermissions(permissions...)
+	}
+}
+
+// AnyOf требует наличия хотя бы одного из перечисленных разрешений
+func AnyOf(permissions ...string) Requirement {
+	return func(user *models.User) bool {
+		return user.HasAnyPermission(permissions...)
+	}
+}
+
+// ApiKeyScope требует, чтобы scope входил в область API-ключа; запросы с токеном проходят без проверки
+func ApiKeyScope(scope string) Requirement {
+	return func(user *models.User) bool {
+		if user.ApiKeyID == nil {
+			return true
+		}
+
+		for _, granted := range user.Scopes {
+			if models.MatchPermission(granted, scope) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// NoApiKey запрещает маршрут для запросов с API-ключом: учетная запись, сессии и настройки безопасности
+// меняются только после входа пользователя
+func NoApiKey(user *models.User) bool {
+	return user.ApiKeyID == nil
+}
+
+// AuthMiddleware проверяет токен доступа или API-ключ пользователя и выполнение всех требований requirements
+func AuthMiddleware(requirements ...Requirement) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		services := c.Locals("services").(AppServices)
 
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
-			return fiber.NewError(fiber.StatusUnauthorized, "missing Authorization header")
+			// В режиме cookie браузер передает access-токен в cookie; такие запросы подвержены CSRF
+			if token := c.Cookies(AccessTokenCookie); token != "" && services.Config.AuthMode == "cookie" {
+				if err := ValidateCSRF(c); err != nil {
+					return err
+				}
+
+				authHeader = "Bearer " + token
+			} else {
+				return fiber.NewError(fiber.StatusUnauthorized, "missing Authorization header")
+			}
 		}
 
 		parts := strings.Split(authHeader, " ")
@@ -23,38 +77,191 @@ func AuthMiddleware(permissions ...string) fiber.Handler {
 			return fiber.NewError(fiber.StatusBadRequest, "invalid Authorization header")
 		}
 
-		tokenString := parts[1]
-		token, err := services.JWT.ValidateToken(tokenString)
+		var user models.User
+		var actor *models.User
+		var sessionUUID uuid.UUID
+		if strings.EqualFold(parts[0], "ApiKey") {
+			apiKey, err := authenticateApiKey(services, parts[1])
+			if err != nil {
+				return err
+			}
 
-		if err != nil || !token.Valid {
-			return fiber.NewError(fiber.StatusUnauthorized, "invalid token")
+			user = apiKey.User
+			user.Scopes = apiKey.Scopes()
+			user.ApiKeyID = &apiKey.ID
+			c.Locals("current_api_key", apiKey.ID)
+		} else {
+			var err error
+			user, actor, sessionUUID, err = authenticateToken(services, parts[1])
+			if err != nil {
+				return err
+			}
 		}
 
-		claims, ok := token.Claims.(*utils.JwtCustomClaim)
-		if !ok {
-			return fiber.NewError(fiber.StatusUnauthorized, "invalid token claims")
+		if user.IsBanned {
+			return fiber.NewError(fiber.StatusForbidden, "user banned")
 		}
 
-		userID := claims.UserID
-		userUUID, err := uuid.Parse(userID)
-		if err != nil {
-			return fiber.NewError(fiber.StatusBadRequest, "invalid user ID format")
+		for _, requirement := range requirements {
+			if !requirement(&user) {
+				return fiber.NewError(fiber.StatusForbidden, "insufficient permissions")
+			}
 		}
 
-		var user models.User
-		if err := services.DB.First(&user, userUUID).Error; err != nil {
-			return fiber.NewError(fiber.StatusNotFound, "user not found")
+		c.Locals("current_user", user)
+		c.Locals("current_session", sessionUUID)
+		if actor == nil {
+			c.Locals("real_user", user)
+			return c.Next()
 		}
 
-		if len(permissions) > 0 {
-			roleMatch := user.HasPermissions(permissions...)
+		c.Locals("real_user", *actor)
+		return impersonate(c, services, *actor, user, sessionUUID)
+	}
+}
 
-			if !roleMatch {
-				return fiber.NewError(fiber.StatusForbidden, "insufficient permissions")
-			}
+// IsImpersonating сообщает, выполняется ли запрос администратором от имени другого пользователя
+func IsImpersonating(c *fiber.Ctx) bool {
+	realUser, ok := c.Locals("real_user").(models.User)
+	if !ok {
+		return false
+	}
+
+	return realUser.ID != c.Locals("current_user").(models.User).ID
+}
+
+// NoImpersonation запрещает маршрут при входе от имени другого пользователя,
+// например чтобы поддержка не могла выпустить себе постоянный доступ к чужой учетной записи
+func NoImpersonation(c *fiber.Ctx) error {
+	if IsImpersonating(c) {
+		return fiber.NewError(fiber.StatusForbidden, "not allowed while impersonating")
+	}
+
+	return c.Next()
+}
+
+// impersonate записывает запрос в журнал до его выполнения, чтобы ни одно действие
+// не осталось без записи, и дополняет запись статусом ответа
+func impersonate(c *fiber.Ctx, services AppServices, actor, user models.User, sessionUUID uuid.UUID) error {
+	entry := models.ImpersonationLog{
+		ActorID:   actor.ID,
+		UserID:    user.ID,
+		SessionID: sessionUUID,
+		Method:    c.Method(),
+		Path:      c.OriginalURL(),
+		IP:        c.IP(),
+	}
+
+	if err := services.DB.Create(&entry).Error; err != nil {
+		return fiber.NewError(fiber.StatusInternalServerError, "could not write impersonation log")
+	}
+
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		status = fiberErr.Code
+	} else if err != nil {
+		status = fiber.StatusInternalServerError
+	}
+
+	if updateErr := services.DB.Model(&entry).Update("status", status).Error; updateErr != nil {
+		log.Printf("could not update impersonation log %s: %v", entry.ID, updateErr)
+	}
+
+	return err
+}
+
+// authenticateToken проверяет access-токен и активность его сессии. Для токена поддержки
+// возвращает также администратора actor; сессия в таком токене принадлежит администратору.
+func authenticateToken(services AppServices, tokenString string) (models.User, *models.User, uuid.UUID, error) {
+	var user models.User
+
+	token, err := services.JWT.ValidateToken(tokenString)
+	if err != nil || !token.Valid {
+		return user, nil, uuid.Nil, fiber.NewError(fiber.StatusUnauthorized, "invalid token")
+	}
+
+	claims, ok := token.Claims.(*utils.JwtCustomClaim)
+	if !ok {
+		return user, nil, uuid.Nil, fiber.NewError(fiber.StatusUnauthorized, "invalid token claims")
+	}
+
+	userUUID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		return user, nil, uuid.Nil, fiber.NewError(fiber.StatusBadRequest, "invalid user ID format")
+	}
+
+	sessionUUID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return user, nil, uuid.Nil, fiber.NewError(fiber.StatusUnauthorized, "invalid token claims")
+	}
+
+	sessionOwner := userUUID
+	if claims.Actor != nil {
+		sessionOwner, err = uuid.Parse(claims.Actor.UserID)
+		if err != nil {
+			return user, nil, uuid.Nil, fiber.NewError(fiber.StatusUnauthorized, "invalid token claims")
 		}
+	}
 
-		c.Locals("current_user", user)
-		return c.Next()
+	var activeSessions int64
+	if err := services.DB.Model(&models.Session{}).
+		Where("family_id = ? AND user_id = ? AND is_active = ? AND expires_at > ?", sessionUUID, sessionOwner, true, time.Now()).
+		Count(&activeSessions).Error; err != nil {
+		return user, nil, uuid.Nil, fiber.NewError(fiber.StatusInternalServerError, "could not check session")
 	}
+
+	if activeSessions == 0 {
+		return user, nil, uuid.Nil, fiber.NewError(fiber.StatusUnauthorized, "session revoked")
+	}
+
+	if err := services.DB.Preload("Roles.Permissions").First(&user, userUUID).Error; err != nil {
+		return user, nil, uuid.Nil, fiber.NewError(fiber.StatusNotFound, "user not found")
+	}
+
+	if claims.Actor == nil {
+		return user, nil, sessionUUID, nil
+	}
+
+	var actor models.User
+	if err := services.DB.Preload("Roles.Permissions").First(&actor, sessionOwner).Error; err != nil {
+		return user, nil, uuid.Nil, fiber.NewError(fiber.StatusUnauthorized, "actor not found")
+	}
+
+	if actor.IsBanned || !actor.HasPermission(models.PermissionUsersImpersonate) {
+		return user, nil, uuid.Nil, fiber.NewError(fiber.StatusForbidden, "impersonation not allowed")
+	}
+
+	// От имени пользователя доступны только его собственные данные: разрешения ролей не действуют
+	user.Scopes = []string{}
+
+	return user, &actor, sessionUUID, nil
+}
+
+// authenticateApiKey находит действующий API-ключ и отмечает время его использования
+func authenticateApiKey(services AppServices, key string) (models.ApiKey, error) {
+	var apiKey models.ApiKey
+	if err := services.DB.
+		Preload("Permissions").
+		Preload("User.Roles.Permissions").
+		Where("key_hash = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", utils.HashToken(key), time.Now()).
+		First(&apiKey).Error; err != nil {
+		return apiKey, fiber.NewError(fiber.StatusUnauthorized, "invalid api key")
+	}
+
+	if apiKey.User.ID == uuid.Nil {
+		return apiKey, fiber.NewError(fiber.StatusUnauthorized, "invalid api key")
+	}
+
+	// Время использования обновляется не чаще раза в минуту, чтобы не писать в базу на каждый запрос
+	now := time.Now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyTouchInterval {
+		if err := services.DB.Model(&apiKey).Update("last_used_at", now).Error; err != nil {
+			return apiKey, fiber.NewError(fiber.StatusInternalServerError, "could not update api key")
+		}
+	}
+
+	return apiKey, nil
 }
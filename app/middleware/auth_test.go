@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"errors"
+	"fusion/app/database/models"
+	"fusion/app/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestImpersonation(t *testing.T) {
+	db := testDB(t)
+	jwtService := utils.NewJWTService("test-secret")
+	suffix := uuid.NewString()[:8]
+
+	var permission models.Permission
+	if err := db.Where(models.Permission{Name: models.PermissionUsersImpersonate}).FirstOrCreate(&permission).Error; err != nil {
+		t.Fatal(err)
+	}
+	role := models.Role{Name: "support-" + suffix, Permissions: []models.Permission{permission}}
+	if err := db.Create(&role).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	actor := models.User{Username: "actor-" + suffix, Email: "actor-" + suffix + "@example.com", Password: "hash", Roles: []models.Role{role}}
+	plain := models.User{Username: "plain-" + suffix, Email: "plain-" + suffix + "@example.com", Password: "hash"}
+	user := models.User{Username: "user-" + suffix, Email: "user-" + suffix + "@example.com", Password: "hash"}
+	for _, account := range []*models.User{&actor, &plain, &user} {
+		if err := db.Create(account).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	actorSession := models.Session{UserID: actor.ID, FamilyID: uuid.New(), IsActive: true, ExpiresAt: time.Now().Add(time.Hour)}
+	plainSession := models.Session{UserID: plain.ID, FamilyID: uuid.New(), IsActive: true, ExpiresAt: time.Now().Add(time.Hour)}
+	for _, session := range []*models.Session{&actorSession, &plainSession} {
+		if err := db.Create(session).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Cleanup(func() {
+		db.Where("actor_id IN ?", []uuid.UUID{actor.ID, plain.ID}).Delete(&models.ImpersonationLog{})
+		db.Where("user_id IN ?", []uuid.UUID{actor.ID, plain.ID}).Delete(&models.Session{})
+		db.Model(&actor).Association("Roles").Clear()
+		db.Select("Permissions").Delete(&role)
+		db.Delete(&[]models.User{actor, plain, user})
+	})
+
+	app := fiber.New()
+	app.Use(InjectorMiddleware(utils.AppConfig{}, db, jwtService, nil))
+	app.Use(AuthMiddleware())
+	app.Get("/me", func(c *fiber.Ctx) error {
+		// Запрос записывается в журнал до выполнения обработчика
+		var logged int64
+		db.Model(&models.ImpersonationLog{}).Where("actor_id = ? AND path = ?", actor.ID, "/me").Count(&logged)
+		if logged == 0 {
+			return fiber.NewError(fiber.StatusInternalServerError, "request was not logged before the handler")
+		}
+
+		if !IsImpersonating(c) || c.Locals("current_user").(models.User).ID != user.ID || c.Locals("real_user").(models.User).ID != actor.ID {
+			return fiber.NewError(fiber.StatusInternalServerError, "unexpected request users")
+		}
+
+		return c.SendStatus(fiber.StatusOK)
+	})
+	app.Post("/me/api-keys", NoImpersonation, func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusCreated)
+	})
+	app.Get("/fail", func(c *fiber.Ctx) error {
+		return errors.New("failed")
+	})
+
+	tests := []struct {
+		name    string
+		actor   models.User
+		session models.Session
+		method  string
+		path    string
+		status  int
+		logged  bool
+	}{
+		{"request is logged with response status", actor, actorSession, "GET", "/me", fiber.StatusOK, true},
+		{"route closed while impersonating", actor, actorSession, "POST", "/me/api-keys", fiber.StatusForbidden, true},
+		{"handler error is logged as server error", actor, actorSession, "GET", "/fail", fiber.StatusInternalServerError, true},
+		{"actor without permission", plain, plainSession, "GET", "/me", fiber.StatusForbidden, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := jwtService.GenerateImpersonationToken(time.Minute, user.ID.String(), tt.session.FamilyID.String(), tt.actor.ID.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			request := httptest.NewRequest(tt.method, tt.path, nil)
+			request.Header.Set("Authorization", "Bearer "+token)
+			response, err := app.Test(request, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", response.StatusCode, tt.status)
+			}
+
+			var entries []models.ImpersonationLog
+			db.Where("actor_id = ? AND method = ? AND path = ?", tt.actor.ID, tt.method, tt.path).Find(&entries)
+			if !tt.logged {
+				if len(entries) != 0 {
+					t.Errorf("log entries = %d, want none", len(entries))
+				}
+				return
+			}
+			if len(entries) != 1 {
+				t.Fatalf("log entries = %d, want 1", len(entries))
+			}
+			if entry := entries[0]; entry.UserID != user.ID || entry.SessionID != tt.session.FamilyID || entry.Status != tt.status {
+				t.Errorf("log entry = %+v, want user %s, session %s, status %d", entry, user.ID, tt.session.FamilyID, tt.status)
+			}
+		})
+	}
+}
+
+func TestIsImpersonating(t *testing.T) {
+	user := models.User{ID: uuid.New()}
+	actor := models.User{ID: uuid.New()}
+
+	tests := []struct {
+		name     string
+		realUser *models.User
+		want     bool
+	}{
+		{"own request", &user, false},
+		{"impersonated request", &actor, true},
+		{"real user unknown", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Post("/", func(c *fiber.Ctx) error {
+				c.Locals("current_user", user)
+				if tt.realUser != nil {
+					c.Locals("real_user", *tt.realUser)
+				}
+				if got := IsImpersonating(c); got != tt.want {
+					t.Errorf("IsImpersonating() = %v, want %v", got, tt.want)
+				}
+				return c.Next()
+			}, NoImpersonation, func(c *fiber.Ctx) error {
+				return c.SendStatus(fiber.StatusNoContent)
+			})
+
+			response, err := app.Test(httptest.NewRequest("POST", "/", nil), -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := fiber.StatusNoContent
+			if tt.want {
+				want = fiber.StatusForbidden
+			}
+			if response.StatusCode != want {
+				t.Errorf("NoImpersonation status = %d, want %d", response.StatusCode, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Имена cookie и заголовков, используемых в режиме AUTH_MODE=cookie
+const (
+	AccessTokenCookie  = "access_token"
+	RefreshTokenCookie = "refresh_token"
+	CSRFCookie         = "csrf_token"
+	CSRFHeader         = "X-CSRF-Token"
+	// TransportHeader клиент передает со значением "cookie", чтобы получить токены в cookie, а не в теле ответа
+	TransportHeader = "X-Auth-Transport"
+)
+
+// ValidateCSRF проверяет double-submit токен: заголовок X-CSRF-Token должен совпадать с cookie csrf_token.
+// Чужой сайт может отправить cookie вместе с запросом, но не может их прочитать.
+func ValidateCSRF(c *fiber.Ctx) error {
+	if isSafeMethod(c.Method()) {
+		return nil
+	}
+
+	cookie := c.Cookies(CSRFCookie)
+	header := c.Get(CSRFHeader)
+	if cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) != 1 {
+		return fiber.NewError(fiber.StatusForbidden, "invalid csrf token")
+	}
+
+	return nil
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidateCSRF(t *testing.T) {
+	app := fiber.New()
+	app.All("/", func(c *fiber.Ctx) error {
+		if err := ValidateCSRF(c); err != nil {
+			return err
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		cookie string
+		header string
+		status int
+	}{
+		{"safe method without token", "GET", "", "", fiber.StatusNoContent},
+		{"head without token", "HEAD", "", "", fiber.StatusNoContent},
+		{"options without token", "OPTIONS", "", "", fiber.StatusNoContent},
+		{"matching token", "POST", "token", "token", fiber.StatusNoContent},
+		{"matching token on delete", "DELETE", "token", "token", fiber.StatusNoContent},
+		{"missing cookie and header", "POST", "", "", fiber.StatusForbidden},
+		{"missing header", "POST", "token", "", fiber.StatusForbidden},
+		{"missing cookie", "PUT", "", "token", fiber.StatusForbidden},
+		{"different token", "PATCH", "token", "other", fiber.StatusForbidden},
+		{"token prefix", "POST", "token", "tok", fiber.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, "/", nil)
+			if tt.cookie != "" {
+				request.Header.Set("Cookie", CSRFCookie+"="+tt.cookie)
+			}
+			if tt.header != "" {
+				request.Header.Set(CSRFHeader, tt.header)
+			}
+
+			response, err := app.Test(request, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", response.StatusCode, tt.status)
+			}
+		})
+	}
+}
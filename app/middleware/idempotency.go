@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fusion/app/database/models"
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"time"
+)
+
+const (
+	// IdempotencyHeader ключ, с которым клиент повторяет изменяющий запрос без риска выполнить его дважды
+	IdempotencyHeader = "Idempotency-Key"
+	// IdempotencyReplayedHeader отмечает ответ, повторенный из сохраненного результата
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+
+	idempotencyKeyMaxLength = 255
+)
+
+// IdempotencyMiddleware выполняет изменяющий запрос с заголовком Idempotency-Key один раз для пары
+// пользователь и ключ: повтор с тем же запросом получает сохраненный ответ, повтор с другим методом,
+// адресом или телом — 422, повтор до завершения первого запроса — 409. Ответы с ошибкой сервера
+// не сохраняются, такой запрос можно повторить; так же освобождается ключ, если обработчик
+// запаниковал или ответ не удалось сохранить. Ключ действует IDEMPOTENCY_EXPIRE.
+// Ставится после AuthMiddleware; запросы без заголовка выполняются как обычно.
+func IdempotencyMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(IdempotencyHeader)
+		if key == "" || isSafeMethod(c.Method()) {
+			return c.Next()
+		}
+		if len(key) > idempotencyKeyMaxLength {
+			return fiber.NewError(fiber.StatusBadRequest, "invalid idempotency key")
+		}
+
+		services := c.Locals("services").(AppServices)
+		user := c.Locals("current_user").(models.User)
+		fingerprint := requestFingerprint(c)
+
+		// Истекший ключ можно использовать заново
+		if err := services.DB.
+			Where("user_id = ? AND key = ? AND expires_at < ?", user.ID, key, time.Now()).
+			Delete(&models.IdempotencyKey{}).
+			Error; err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "could not check idempotency key")
+		}
+
+		record := models.IdempotencyKey{
+			UserID:      user.ID,
+			Key:         key,
+			Fingerprint: fingerprint,
+			ExpiresAt:   time.Now().Add(services.Config.IdempotencyExpire),
+		}
+		result := services.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		if result.Error != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "could not store idempotency key")
+		}
+
+		if result.RowsAffected == 0 {
+			return replayIdempotentResponse(c, services.DB, user, key, fingerprint)
+		}
+
+		// release освобождает ключ, чтобы запрос можно было повторить
+		release := func() {
+			if err := services.DB.Delete(&record).Error; err != nil {
+				log.Printf("could not release idempotency key %s: %v", record.ID, err)
+			}
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				release()
+				panic(r)
+			}
+		}()
+
+		if err := c.Next(); err != nil {
+			if err := c.App().ErrorHandler(c, err); err != nil {
+				release()
+				return err
+			}
+		}
+
+		response := c.Response()
+		if response.StatusCode() >= fiber.StatusInternalServerError {
+			release()
+			return nil
+		}
+
+		if err := services.DB.Model(&record).Updates(map[string]interface{}{
+			"status_code":  response.StatusCode(),
+			"content_type": string(response.Header.ContentType()),
+			"body":         append([]byte(nil), response.Body()...),
+		}).Error; err != nil {
+			log.Printf("could not store idempotent response %s: %v", record.ID, err)
+			release()
+			return fiber.NewError(fiber.StatusInternalServerError, "could not store idempotent response")
+		}
+
+		return nil
+	}
+}
+
+// replayIdempotentResponse отвечает на повтор запроса сохраненным результатом
+func replayIdempotentResponse(c *fiber.Ctx, db *gorm.DB, user models.User, key, fingerprint string) error {
+	var record models.IdempotencyKey
+	if err := db.Where("user_id = ? AND key = ?", user.ID, key).First(&record).Error; err != nil {
+		// Ключ освободился, пока выполнялся этот запрос
+		return fiber.NewError(fiber.StatusConflict, "request with this idempotency key is in progress")
+	}
+
+	if record.Fingerprint != fingerprint {
+		return fiber.NewError(fiber.StatusUnprocessableEntity, "idempotency key was used with a different request")
+	}
+
+	if record.StatusCode == 0 {
+		return fiber.NewError(fiber.StatusConflict, "request with this idempotency key is in progress")
+	}
+
+	c.Set(IdempotencyReplayedHeader, "true")
+	if record.ContentType != "" {
+		c.Set(fiber.HeaderContentType, record.ContentType)
+	}
+
+	return c.Status(record.StatusCode).Send(record.Body)
+}
+
+// requestFingerprint хеширует метод, адрес с параметрами и тело запроса
+func requestFingerprint(c *fiber.Ctx) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Method() + " " + c.OriginalURL() + "\n"))
+	hash.Write(c.Body())
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// StartIdempotencyExpiry периодически удаляет истекшие ключи идемпотентности
+func StartIdempotencyExpiry(db *gorm.DB, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := db.Where("expires_at < ?", time.Now()).Delete(&models.IdempotencyKey{}).Error; err != nil {
+				log.Printf("could not delete expired idempotency keys: %v", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,267 @@
+package middleware
+
+import (
+	"fusion/app/database/models"
+	"fusion/app/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testDB подключается к базе из TEST_DATABASE_DSN; без нее тест пропускается
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec(`CREATE EXTENSION IF NOT EXISTS "uuid-ossp"`).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Role{}, &models.Permission{}, &models.User{}, &models.Session{}, &models.IdempotencyKey{}, &models.ImpersonationLog{}); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// newIdempotencyApp создает приложение с маршрутом POST /orders, выполняющим handler под IdempotencyMiddleware
+func newIdempotencyApp(t *testing.T, db *gorm.DB, handler fiber.Handler) *fiber.App {
+	t.Helper()
+
+	user := models.User{ID: uuid.New()}
+	t.Cleanup(func() {
+		db.Where("user_id = ?", user.ID).Delete(&models.IdempotencyKey{})
+	})
+
+	app := fiber.New()
+	app.Use(recover.New())
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("services", AppServices{Config: utils.AppConfig{IdempotencyExpire: time.Hour}, DB: db})
+		c.Locals("current_user", user)
+		return c.Next()
+	})
+	app.Post("/orders", IdempotencyMiddleware(), handler)
+
+	return app
+}
+
+func sendIdempotent(t *testing.T, app *fiber.App, key, body string) (*http.Response, string) {
+	t.Helper()
+
+	request := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+	request.Header.Set(IdempotencyHeader, key)
+
+	response, err := app.Test(request, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return response, string(data)
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	db := testDB(t)
+
+	t.Run("replays stored response", func(t *testing.T) {
+		var calls atomic.Int32
+		app := newIdempotencyApp(t, db, func(c *fiber.Ctx) error {
+			calls.Add(1)
+			return c.Status(fiber.StatusCreated).JSON(fiber.Map{"id": uuid.NewString()})
+		})
+
+		first, firstBody := sendIdempotent(t, app, "replay", `{"items":[1]}`)
+		second, secondBody := sendIdempotent(t, app, "replay", `{"items":[1]}`)
+
+		if calls.Load() != 1 {
+			t.Errorf("handler calls = %d, want 1", calls.Load())
+		}
+		if first.StatusCode != fiber.StatusCreated || second.StatusCode != fiber.StatusCreated {
+			t.Errorf("status = %d, %d, want %d", first.StatusCode, second.StatusCode, fiber.StatusCreated)
+		}
+		if secondBody != firstBody {
+			t.Errorf("replayed body = %s, want %s", secondBody, firstBody)
+		}
+		if second.Header.Get(IdempotencyReplayedHeader) != "true" || first.Header.Get(IdempotencyReplayedHeader) != "" {
+			t.Errorf("replayed header = %q, %q", first.Header.Get(IdempotencyReplayedHeader), second.Header.Get(IdempotencyReplayedHeader))
+		}
+		if contentType := second.Header.Get(fiber.HeaderContentType); contentType != first.Header.Get(fiber.HeaderContentType) {
+			t.Errorf("replayed content type = %s, want %s", contentType, first.Header.Get(fiber.HeaderContentType))
+		}
+	})
+
+	t.Run("rejects key reused with different request", func(t *testing.T) {
+		var calls atomic.Int32
+		app := newIdempotencyApp(t, db, func(c *fiber.Ctx) error {
+			calls.Add(1)
+			return c.SendStatus(fiber.StatusCreated)
+		})
+
+		sendIdempotent(t, app, "fingerprint", `{"items":[1]}`)
+		response, _ := sendIdempotent(t, app, "fingerprint", `{"items":[2]}`)
+
+		if response.StatusCode != fiber.StatusUnprocessableEntity {
+			t.Errorf("status = %d, want %d", response.StatusCode, fiber.StatusUnprocessableEntity)
+		}
+		if calls.Load() != 1 {
+			t.Errorf("handler calls = %d, want 1", calls.Load())
+		}
+	})
+
+	t.Run("rejects key in progress", func(t *testing.T) {
+		entered := make(chan struct{})
+		proceed := make(chan struct{})
+		var calls atomic.Int32
+		app := newIdempotencyApp(t, db, func(c *fiber.Ctx) error {
+			if calls.Add(1) == 1 {
+				close(entered)
+				<-proceed
+			}
+			return c.SendStatus(fiber.StatusCreated)
+		})
+
+		done := make(chan int)
+		go func() {
+			request := httptest.NewRequest("POST", "/orders", strings.NewReader(`{}`))
+			request.Header.Set(IdempotencyHeader, "in-progress")
+			response, err := app.Test(request, -1)
+			if err != nil {
+				done <- 0
+				return
+			}
+			done <- response.StatusCode
+		}()
+		<-entered
+
+		response, _ := sendIdempotent(t, app, "in-progress", `{}`)
+		close(proceed)
+
+		if response.StatusCode != fiber.StatusConflict {
+			t.Errorf("concurrent status = %d, want %d", response.StatusCode, fiber.StatusConflict)
+		}
+		if status := <-done; status != fiber.StatusCreated {
+			t.Errorf("first status = %d, want %d", status, fiber.StatusCreated)
+		}
+	})
+
+	releases := []struct {
+		name    string
+		handler func(c *fiber.Ctx) error
+	}{
+		{"releases key after server error", func(c *fiber.Ctx) error {
+			return c.SendStatus(fiber.StatusServiceUnavailable)
+		}},
+		{"releases key after returned error", func(c *fiber.Ctx) error {
+			return fiber.NewError(fiber.StatusInternalServerError, "failed")
+		}},
+		{"releases key after panic", func(c *fiber.Ctx) error {
+			panic("failed")
+		}},
+	}
+
+	for _, tt := range releases {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			app := newIdempotencyApp(t, db, func(c *fiber.Ctx) error {
+				if calls.Add(1) == 1 {
+					return tt.handler(c)
+				}
+				return c.SendStatus(fiber.StatusCreated)
+			})
+
+			first, _ := sendIdempotent(t, app, "release", `{}`)
+			if first.StatusCode < fiber.StatusInternalServerError {
+				t.Fatalf("first status = %d, want 5xx", first.StatusCode)
+			}
+
+			second, _ := sendIdempotent(t, app, "release", `{}`)
+			if second.StatusCode != fiber.StatusCreated || second.Header.Get(IdempotencyReplayedHeader) != "" {
+				t.Errorf("retry status = %d, replayed = %q, want fresh %d", second.StatusCode, second.Header.Get(IdempotencyReplayedHeader), fiber.StatusCreated)
+			}
+			if calls.Load() != 2 {
+				t.Errorf("handler calls = %d, want 2", calls.Load())
+			}
+		})
+	}
+
+	t.Run("executes again after expiry", func(t *testing.T) {
+		var calls atomic.Int32
+		app := newIdempotencyApp(t, db, func(c *fiber.Ctx) error {
+			calls.Add(1)
+			return c.SendStatus(fiber.StatusCreated)
+		})
+
+		sendIdempotent(t, app, "expiry", `{}`)
+		if err := db.Model(&models.IdempotencyKey{}).
+			Where("key = ?", "expiry").
+			Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+			t.Fatal(err)
+		}
+
+		response, _ := sendIdempotent(t, app, "expiry", `{}`)
+		if response.Header.Get(IdempotencyReplayedHeader) != "" {
+			t.Error("expired key replayed the stored response")
+		}
+		if calls.Load() != 2 {
+			t.Errorf("handler calls = %d, want 2", calls.Load())
+		}
+	})
+}
+
+func TestIdempotencyMiddlewareSkipsRequestsWithoutKey(t *testing.T) {
+	var calls atomic.Int32
+	app := fiber.New()
+	app.Post("/orders", IdempotencyMiddleware(), func(c *fiber.Ctx) error {
+		calls.Add(1)
+		return c.SendStatus(fiber.StatusCreated)
+	})
+	app.Get("/orders", IdempotencyMiddleware(), func(c *fiber.Ctx) error {
+		calls.Add(1)
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	// Без ключа и для безопасных методов база не нужна: middleware сразу передает запрос дальше
+	for _, method := range []string{"POST", "GET"} {
+		request := httptest.NewRequest(method, "/orders", nil)
+		if method == "GET" {
+			request.Header.Set(IdempotencyHeader, "ignored")
+		}
+		if _, err := app.Test(request, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if calls.Load() != 2 {
+		t.Errorf("handler calls = %d, want 2", calls.Load())
+	}
+
+	request := httptest.NewRequest("POST", "/orders", nil)
+	request.Header.Set(IdempotencyHeader, strings.Repeat("k", idempotencyKeyMaxLength+1))
+	response, err := app.Test(request, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("long key status = %d, want %d", response.StatusCode, fiber.StatusBadRequest)
+	}
+}
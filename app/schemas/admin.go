@@ -0,0 +1,56 @@
+package schemas
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type AdminUserResponse struct {
+	ID              uuid.UUID  `json:"id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	IsEmailVerified bool       `json:"is_email_verified"`
+	IsBanned        bool       `json:"is_banned"`
+	BanReason       string     `json:"ban_reason,omitempty"`
+	BannedAt        *time.Time `json:"banned_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+type AdminUserListResponse struct {
+	Users []AdminUserResponse `json:"users"`
+	Total int64               `json:"total"`
+	Page  int                 `json:"page"`
+	Limit int                 `json:"limit"`
+}
+
+type BanRequest struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+type ImpersonateRequest struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+type ImpersonateResponse struct {
+	AccessToken string    `json:"access_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type ImpersonationLogResponse struct {
+	ID        uuid.UUID `json:"id"`
+	ActorID   uuid.UUID `json:"actor_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ImpersonationLogListResponse struct {
+	Entries []ImpersonationLogResponse `json:"entries"`
+	Total   int64                      `json:"total"`
+	Page    int                        `json:"page"`
+	Limit   int                        `json:"limit"`
+}
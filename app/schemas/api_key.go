@@ -0,0 +1,38 @@
+package schemas
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type ApiKeyCreateRequest struct {
+	Name        string     `json:"name" validate:"required,max=64"`
+	Permissions []string   `json:"permissions" validate:"dive,required"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+type ApiKeyResponse struct {
+	ID          uuid.UUID  `json:"id"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	Permissions []string   `json:"permissions"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// ApiKeyCreatedResponse содержит сам ключ; он показывается только один раз
+type ApiKeyCreatedResponse struct {
+	ApiKeyResponse
+	Key string `json:"key"`
+}
+
+type ServiceAccountCreateRequest struct {
+	Name string `json:"name" validate:"required,min=3,max=28,alphanum"`
+}
+
+type ServiceAccountResponse struct {
+	ID        uuid.UUID `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
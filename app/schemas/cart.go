@@ -7,6 +7,15 @@ type CartResponse struct {
 }
 
 type CartProductResponse struct {
-	ID       string `json:"id"`
-	Quantity int    `json:"quantity"`
+	ID        string  `json:"id"`
+	ProductID string  `json:"product_id,omitempty"`
+	VariantID *string `json:"variant_id,omitempty"`
+	Quantity  int     `json:"quantity"`
+}
+
+// CartItemRequest позиция корзины; вариант обязателен для товаров с вариантами
+type CartItemRequest struct {
+	ProductID string  `json:"product_id" validate:"required,uuid"`
+	VariantID *string `json:"variant_id,omitempty" validate:"omitempty,uuid"`
+	Quantity  int     `json:"quantity" validate:"gte=0"`
 }
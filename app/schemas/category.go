@@ -0,0 +1,36 @@
+package schemas
+
+import "github.com/google/uuid"
+
+type CategorySummary struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	Slug string    `json:"slug"`
+}
+
+type CategoryResponse struct {
+	ID          uuid.UUID          `json:"id"`
+	ParentID    *uuid.UUID         `json:"parent_id"`
+	Name        string             `json:"name"`
+	Slug        string             `json:"slug"`
+	Description string             `json:"description"`
+	Breadcrumb  []CategorySummary  `json:"breadcrumb,omitempty"`
+	Children    []CategoryResponse `json:"children"`
+}
+
+// CategoryCreateRequest создание категории; Parent принимает ID или slug родителя,
+// slug по умолчанию строится из названия
+type CategoryCreateRequest struct {
+	Name        string  `json:"name" validate:"required,max=100"`
+	Slug        string  `json:"slug" validate:"omitempty,max=100"`
+	Description string  `json:"description" validate:"max=1000"`
+	Parent      *string `json:"parent,omitempty"`
+}
+
+// CategoryUpdateRequest изменение категории; пустой Parent переносит категорию в корень дерева
+type CategoryUpdateRequest struct {
+	Name        *string `json:"name,omitempty" validate:"omitempty,min=1,max=100"`
+	Slug        *string `json:"slug,omitempty" validate:"omitempty,max=100"`
+	Description *string `json:"description,omitempty" validate:"omitempty,max=1000"`
+	Parent      *string `json:"parent,omitempty"`
+}
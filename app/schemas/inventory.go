@@ -0,0 +1,27 @@
+package schemas
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type StockMovementResponse struct {
+	ID        uuid.UUID  `json:"id"`
+	ProductID uuid.UUID  `json:"product_id"`
+	VariantID *uuid.UUID `json:"variant_id,omitempty"`
+	OrderID   *uuid.UUID `json:"order_id,omitempty"`
+	Kind      string     `json:"kind"`
+	Quantity  int        `json:"quantity"`
+	Reason    string     `json:"reason"`
+	ActorID   *uuid.UUID `json:"actor_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// StockMovementRequest поступление (положительное количество) или корректировка остатка (любого знака).
+// Для товара с вариантами обязателен VariantID.
+type StockMovementRequest struct {
+	VariantID *uuid.UUID `json:"variant_id,omitempty"`
+	Kind      string     `json:"kind" validate:"required,oneof=receipt adjustment"`
+	Quantity  int        `json:"quantity" validate:"required"`
+	Reason    string     `json:"reason" validate:"required,max=255"`
+}
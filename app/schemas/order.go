@@ -1,14 +1,41 @@
 package schemas
 
-import "fusion/app/database/models"
+import (
+	"fusion/app/database/models"
+	"github.com/google/uuid"
+	"time"
+)
 
 type CreateOrderRequest struct {
 	CartProductResponse []CartProductResponse `json:"products"`
 }
 
 type CreateOrderResponse struct {
-	ID           string               `json:"id"`
-	CartProducts []models.CartProduct `json:"cart_products"`
-	UserID       string               `json:"user_id"`
-	Status       int                  `json:"status"`
+	ID            string                `json:"id"`
+	Products      []models.OrderProduct `json:"products"`
+	UserID        string                `json:"user_id"`
+	Status        int                   `json:"status"`
+	ReservedUntil *time.Time            `json:"reserved_until,omitempty"`
+	Currency      string                `json:"currency"`
+	Subtotal      float64               `json:"subtotal"`
+	Discount      float64               `json:"discount"`
+	Tax           float64               `json:"tax"`
+	Shipping      float64               `json:"shipping"`
+	GrandTotal    float64               `json:"grand_total"`
+}
+
+// OrderStatusRequest смена статуса заказа с необязательным комментарием для истории
+type OrderStatusRequest struct {
+	Status models.OrderStatus `json:"status"`
+	Note   string             `json:"note" validate:"max=500"`
+}
+
+type OrderEventResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	FromStatus string     `json:"from_status"`
+	ToStatus   string     `json:"to_status"`
+	Actor      string     `json:"actor"`
+	ActorID    *uuid.UUID `json:"actor_id,omitempty"`
+	Note       string     `json:"note,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
 }
@@ -0,0 +1,8 @@
+package schemas
+
+// CursorPage страница списка; next_cursor передается в параметре cursor для получения следующей
+// страницы и равен null на последней
+type CursorPage[T any] struct {
+	Items      []T     `json:"items"`
+	NextCursor *string `json:"next_cursor"`
+}
@@ -0,0 +1,24 @@
+package schemas
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type CreatePaymentRequest struct {
+	OrderID string `json:"order_id" validate:"required,uuid"`
+}
+
+type PaymentResponse struct {
+	ID            uuid.UUID `json:"id"`
+	OrderID       uuid.UUID `json:"order_id"`
+	Provider      string    `json:"provider"`
+	Status        string    `json:"status"`
+	Amount        float64   `json:"amount"`
+	Refunded      float64   `json:"refunded"`
+	RefundPending bool      `json:"refund_pending"`
+	Currency      string    `json:"currency"`
+	ClientSecret  string    `json:"client_secret,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
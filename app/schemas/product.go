@@ -1,17 +1,40 @@
 package schemas
 
-import "fusion/app/database/models"
+import (
+	"fusion/app/database/models"
+	"github.com/google/uuid"
+	"time"
+)
 
 type ProductResponse struct {
-	ID          string            `json:"id"`
-	UserID      string            `json:"user_id"`
-	Name        string            `json:"name"`
-	Description string            `json:"description"`
-	Price       float64           `json:"price"`
-	Stock       int               `json:"stock"`
-	Image       *string           `json:"image,omitempty"`
-	Categories  []models.Category `json:"categories,omitempty"`
-	Reviews     []models.Review   `json:"reviews,omitempty"`
+	ID           string               `json:"id"`
+	UserID       string               `json:"user_id"`
+	Name         string               `json:"name"`
+	Description  string               `json:"description"`
+	Price        float64              `json:"price"`
+	Stock        int                  `json:"stock"`
+	Image        *string              `json:"image,omitempty"`
+	Images       []ImageResponse      `json:"images,omitempty"`
+	Rating       float64              `json:"rating"`
+	ReviewsCount int                  `json:"reviews_count"`
+	Categories   []CategorySummary    `json:"categories"`
+	Breadcrumb   []CategorySummary    `json:"breadcrumb"`
+	Options      []OptionTypeResponse `json:"options,omitempty"`
+	Variants     []VariantResponse    `json:"variants,omitempty"`
+	Reviews      []models.Review      `json:"reviews,omitempty"`
+	CreatedAt    time.Time            `json:"created_at"`
+}
+
+// ImageResponse изображение галереи с адресами всех размеров
+type ImageResponse struct {
+	ID       string            `json:"id"`
+	Position int               `json:"position"`
+	URLs     map[string]string `json:"urls"`
+}
+
+// ImageOrderRequest новый порядок галереи: все изображения товара, каждое по одному разу
+type ImageOrderRequest struct {
+	IDs []uuid.UUID `json:"ids" validate:"required,min=1"`
 }
 
 type ProductUpdateRequest struct {
@@ -22,3 +45,19 @@ type ProductUpdateRequest struct {
 	Image       *string   `json:"image,omitempty"`
 	Categories  *[]string `json:"categories,omitempty"`
 }
+
+type ProductSearchResult struct {
+	ID                   string  `json:"id"`
+	Name                 string  `json:"name"`
+	Price                float64 `json:"price"`
+	Image                *string `json:"image,omitempty"`
+	Rank                 float64 `json:"rank"`
+	NameHighlight        string  `json:"name_highlight"`
+	DescriptionHighlight string  `json:"description_highlight"`
+}
+
+type ProductSearchResponse struct {
+	Results []ProductSearchResult `json:"results"`
+	Page    int                   `json:"page"`
+	Limit   int                   `json:"limit"`
+}
@@ -0,0 +1,23 @@
+package schemas
+
+type RoleResponse struct {
+	ID          uint     `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type RoleCreateRequest struct {
+	Name        string   `json:"name" validate:"required,min=2,max=64"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" validate:"dive,required"`
+}
+
+type RoleUpdateRequest struct {
+	Description *string   `json:"description,omitempty"`
+	Permissions *[]string `json:"permissions,omitempty" validate:"omitempty,dive,required"`
+}
+
+type RoleGrantRequest struct {
+	Role string `json:"role" validate:"required"`
+}
@@ -1,22 +1,72 @@
 package schemas
 
-import "github.com/google/uuid"
+import (
+	"github.com/google/uuid"
+	"time"
+)
 
 type UserResponse struct {
-	ID       uuid.UUID `json:"id"`
-	Username string    `json:"username"`
-	Avatar   *string   `json:"avatar,omitempty"`
+	ID         uuid.UUID         `json:"id"`
+	Username   string            `json:"username"`
+	Avatar     *string           `json:"avatar,omitempty"`
+	AvatarURLs map[string]string `json:"avatar_urls,omitempty"`
 }
 
 type UserMeResponse struct {
-	ID       uuid.UUID `json:"id"`
-	Username string    `json:"username"`
-	Email    string    `json:"email"`
-	Avatar   *string   `json:"avatar,omitempty"`
+	ID              uuid.UUID         `json:"id"`
+	Username        string            `json:"username"`
+	Email           string            `json:"email"`
+	Phone           *string           `json:"phone,omitempty"`
+	IsPhoneVerified bool              `json:"is_phone_verified"`
+	Avatar          *string           `json:"avatar,omitempty"`
+	AvatarURLs      map[string]string `json:"avatar_urls,omitempty"`
+}
+
+type AvatarResponse struct {
+	Avatar     string            `json:"avatar"`
+	AvatarURLs map[string]string `json:"avatar_urls"`
 }
 
 type UserUpdateRequest struct {
 	Email    *string `json:"email,omitempty" validate:"omitempty,email"`
 	Username *string `json:"username,omitempty" validate:"omitempty,min=3,max=32"`
 	Avatar   *string `json:"avatar,omitempty" validate:"omitempty,url"`
+	// RedirectUrl и CancelUrl страницы подтверждения и отмены смены email
+	RedirectUrl *string `json:"redirect_url,omitempty" validate:"required_with=Email"`
+	CancelUrl   *string `json:"cancel_url,omitempty" validate:"required_with=Email"`
+	// Password текущий пароль, без него email не меняется
+	Password *string `json:"password,omitempty" validate:"required_with=Email"`
+}
+
+type PhoneRequest struct {
+	Phone string `json:"phone" validate:"required"`
+}
+
+type PhoneConfirmRequest struct {
+	Code string `json:"code" validate:"required,numeric"`
+}
+
+type EmailTokenRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type SessionResponse struct {
+	ID        uuid.UUID `json:"id"`
+	Agent     string    `json:"agent"`
+	IP        string    `json:"ip"`
+	Current   bool      `json:"current"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// FieldError ошибка проверки отдельного поля запроса
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ValidationErrorResponse struct {
+	Message string       `json:"message"`
+	Errors  []FieldError `json:"errors"`
 }
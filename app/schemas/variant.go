@@ -0,0 +1,35 @@
+package schemas
+
+import "github.com/google/uuid"
+
+type OptionTypeResponse struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+type VariantResponse struct {
+	ID      uuid.UUID         `json:"id"`
+	SKU     string            `json:"sku"`
+	Price   float64           `json:"price"`
+	Stock   int               `json:"stock"`
+	InStock bool              `json:"in_stock"`
+	Image   *string           `json:"image,omitempty"`
+	Options map[string]string `json:"options"`
+}
+
+// VariantCreateRequest создание варианта; Options сопоставляет измерению его значение,
+// набор измерений должен совпадать у всех вариантов товара
+type VariantCreateRequest struct {
+	SKU     string            `json:"sku" validate:"required,max=64"`
+	Price   *float64          `json:"price,omitempty" validate:"omitempty,gte=0"`
+	Stock   int               `json:"stock" validate:"gte=0"`
+	Image   *string           `json:"image,omitempty"`
+	Options map[string]string `json:"options" validate:"required,min=1,max=5,dive,keys,required,max=50,endkeys,required,max=50"`
+}
+
+type VariantUpdateRequest struct {
+	SKU   *string  `json:"sku,omitempty" validate:"omitempty,min=1,max=64"`
+	Price *float64 `json:"price,omitempty" validate:"omitempty,gte=0"`
+	Stock *int     `json:"stock,omitempty" validate:"omitempty,gte=0"`
+	Image *string  `json:"image,omitempty"`
+}
@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// breachPrefixLength длина префикса SHA-1, по которому запрашивается диапазон (как в Pwned Passwords)
+const breachPrefixLength = 5
+
+// HashRangeSource возвращает суффиксы SHA-1 утекших паролей и число их появлений для префикса.
+// Источник видит только префикс хеша, поэтому его можно заменить внешним сервисом без раскрытия паролей.
+type HashRangeSource interface {
+	Range(prefix string) (map[string]int, error)
+}
+
+// BreachChecker проверяет пароли по базе утекших паролей с k-анонимностью
+type BreachChecker struct {
+	source HashRangeSource
+}
+
+func NewBreachChecker(source HashRangeSource) *BreachChecker {
+	return &BreachChecker{source: source}
+}
+
+// Count возвращает, сколько раз пароль встречался в утечках
+func (b *BreachChecker) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := b.source.Range(hash[:breachPrefixLength])
+	if err != nil {
+		return 0, err
+	}
+
+	return suffixes[hash[breachPrefixLength:]], nil
+}
+
+type fileHashRange struct {
+	path string
+}
+
+// NewFileHashRange создает источник на основе локального файла в формате Pwned Passwords:
+// строки "SHA1:COUNT" в верхнем регистре, отсортированные по хешу. Файл не загружается
+// в память целиком: нужный диапазон находится двоичным поиском.
+func NewFileHashRange(path string) HashRangeSource {
+	return &fileHashRange{path: path}
+}
+
+func (f *fileHashRange) Range(prefix string) (map[string]int, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open breach dataset: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("could not stat breach dataset: %w", err)
+	}
+
+	// Ищем наименьшее смещение, с которого первая целая строка не меньше префикса
+	lo, hi := int64(0), info.Size()
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		line, _, err := lineAfter(file, mid)
+		if err != nil {
+			return nil, err
+		}
+
+		if line != "" && line < prefix {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	_, start, err := lineAfter(file, lo)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	suffixes := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, prefix) {
+			break
+		}
+
+		hash, count, _ := strings.Cut(line, ":")
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			n = 1
+		}
+		suffixes[hash[len(prefix):]] = n
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read breach dataset: %w", err)
+	}
+
+	return suffixes, nil
+}
+
+// lineAfter возвращает первую строку, начинающуюся не раньше offset, и ее смещение; пустую строку в конце файла
+func lineAfter(file *os.File, offset int64) (string, int64, error) {
+	start := offset
+	if offset > 0 {
+		// Строка начинается с offset, только если перед ним стоит перевод строки
+		start = offset - 1
+	}
+
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+
+	reader := bufio.NewReader(file)
+	if offset > 0 {
+		skipped, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return "", start + int64(len(skipped)), nil
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		start += int64(len(skipped))
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", 0, err
+	}
+
+	return strings.TrimSpace(line), start, nil
+}
@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFileHashRange(t *testing.T) {
+	source := NewFileHashRange("testdata/breach.txt")
+
+	tests := []struct {
+		name   string
+		prefix string
+		want   map[string]int
+	}{
+		{"first prefix", "00000", map[string]int{"11111111111111111111111111111111111": 2, "FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF": 7}},
+		{"middle prefix", "7C4A8", map[string]int{"D09CA3762AF61E59520943DC26494F8941B": 37359195}},
+		{"last prefix", "FFFFF", map[string]int{"00000000000000000000000000000000000": 5, "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA": 9}},
+		{"missing prefix", "ABCDE", map[string]int{}},
+		{"missing prefix between lines", "5BAA5", map[string]int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := source.Range(tt.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Range(%q) = %v, want %v", tt.prefix, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreachCheckerCount(t *testing.T) {
+	checker := NewBreachChecker(NewFileHashRange("testdata/breach.txt"))
+
+	tests := []struct {
+		password string
+		want     int
+	}{
+		{"password", 3730471},
+		{"123456", 37359195},
+		{"qwerty", 10556095},
+		{"correct horse battery staple", 0},
+	}
+
+	for _, tt := range tests {
+		got, err := checker.Count(tt.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Count(%q) = %d, want %d", tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestFileHashRangeMissingFile(t *testing.T) {
+	if _, err := NewFileHashRange("testdata/missing.txt").Range("00000"); err == nil {
+		t.Error("Range() on a missing file returned no error")
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -18,16 +19,89 @@ type AppConfig struct {
 	AppPort    string `env:"APP_PORT"`
 	AppEnv     string `env:"APP_ENV"`
 
+	AdminEmails      string `env:"ADMIN_EMAILS"`
+	AllowedRedirects string `env:"ALLOWED_REDIRECTS"`
+	CorsOrigins      string `env:"CORS_ORIGINS"`
+	TrustedProxies   string `env:"TRUSTED_PROXIES"`
+	ProxyHeader      string `env:"PROXY_HEADER"`
+
+	AuthMode       string `env:"AUTH_MODE"`
+	CookieDomain   string `env:"COOKIE_DOMAIN"`
+	CookieSameSite string `env:"COOKIE_SAMESITE"`
+	CookieInsecure bool   `env:"COOKIE_INSECURE"`
+
 	SessionSecret      string        `env:"SESSION_SECRET"`
 	SessionExpire      time.Duration `env:"SESSION_EXPIRE"`
 	RefreshExpire      time.Duration `env:"REFRESH_EXPIRE"`
 	VerificationExpire time.Duration `env:"VERIFICATION_EXPIRE"`
+	MagicLinkExpire    time.Duration `env:"MAGIC_LINK_EXPIRE"`
+	TwoFactorExpire    time.Duration `env:"TWO_FACTOR_EXPIRE"`
+	EmailCancelExpire  time.Duration `env:"EMAIL_CANCEL_EXPIRE"`
+	PhoneCodeExpire    time.Duration `env:"PHONE_CODE_EXPIRE"`
+	ImpersonateExpire  time.Duration `env:"IMPERSONATE_EXPIRE"`
+	ReservationExpire  time.Duration `env:"RESERVATION_EXPIRE"`
+	IdempotencyExpire  time.Duration `env:"IDEMPOTENCY_EXPIRE"`
+
+	PasswordMinLength     int    `env:"PASSWORD_MIN_LENGTH"`
+	PasswordRequireUpper  bool   `env:"PASSWORD_REQUIRE_UPPER"`
+	PasswordRequireLower  bool   `env:"PASSWORD_REQUIRE_LOWER"`
+	PasswordRequireDigit  bool   `env:"PASSWORD_REQUIRE_DIGIT"`
+	PasswordRequireSymbol bool   `env:"PASSWORD_REQUIRE_SYMBOL"`
+	PasswordHistory       int    `env:"PASSWORD_HISTORY"`
+	PasswordBreachFile    string `env:"PASSWORD_BREACH_FILE"`
+
+	LoginThrottleStore string        `env:"LOGIN_THROTTLE_STORE"`
+	LoginMaxAttempts   int           `env:"LOGIN_MAX_ATTEMPTS"`
+	LoginIPMaxAttempts int           `env:"LOGIN_IP_MAX_ATTEMPTS"`
+	LoginBackoff       time.Duration `env:"LOGIN_BACKOFF"`
+	LoginLockout       time.Duration `env:"LOGIN_LOCKOUT"`
+
+	JwtAlgorithm   string        `env:"JWT_ALGORITHM"`
+	JwtKeysDir     string        `env:"JWT_KEYS_DIR"`
+	JwtKeyRotation time.Duration `env:"JWT_KEY_ROTATION"`
+	JwtLegacyUntil string        `env:"JWT_LEGACY_UNTIL"`
+
+	OidcProviders   string                        `env:"OIDC_PROVIDERS"`
+	OidcCallbackURL string                        `env:"OIDC_CALLBACK_URL"`
+	OidcStateExpire time.Duration                 `env:"OIDC_STATE_EXPIRE"`
+	Oidc            map[string]OidcProviderConfig `mapstructure:"-"`
 
 	SmtpHost     string `env:"SMTP_HOST"`
 	SmtpPort     int    `env:"SMTP_PORT"`
 	SmtpUser     string `env:"SMTP_USER"`
 	SmtpPassword string `env:"SMTP_PASSWORD"`
 	SmtpSender   string `env:"SMTP_SENDER"`
+
+	SmsProvider string `env:"SMS_PROVIDER"`
+	SmsFile     string `env:"SMS_FILE"`
+
+	Currency         string  `env:"CURRENCY"`
+	TaxRate          float64 `env:"TAX_RATE"`
+	ShippingFee      float64 `env:"SHIPPING_FEE"`
+	FreeShippingFrom float64 `env:"FREE_SHIPPING_FROM"`
+
+	PaymentProvider      string `env:"PAYMENT_PROVIDER"`
+	PaymentWebhookSecret string `env:"PAYMENT_WEBHOOK_SECRET"`
+	PaymentWebhookURL    string `env:"PAYMENT_WEBHOOK_URL"`
+
+	StorageProvider  string `env:"STORAGE_PROVIDER"`
+	StorageDir       string `env:"STORAGE_DIR"`
+	StoragePublicURL string `env:"STORAGE_PUBLIC_URL"`
+	UploadMaxSize    int    `env:"UPLOAD_MAX_SIZE"`
+	UploadPort       string `env:"UPLOAD_PORT"`
+
+	S3Endpoint  string `env:"S3_ENDPOINT"`
+	S3Region    string `env:"S3_REGION"`
+	S3Bucket    string `env:"S3_BUCKET"`
+	S3AccessKey string `env:"S3_ACCESS_KEY"`
+	S3SecretKey string `env:"S3_SECRET_KEY"`
+}
+
+// OidcProviderConfig параметры клиента внешнего провайдера OIDC_<NAME>_*
+type OidcProviderConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
 }
 
 // LoadConfig загружает конфигурацию из .env и парсит длительности
@@ -51,10 +125,52 @@ func (config *AppConfig) LoadConfig() error {
 	viper.BindEnv("AppPort", "APP_PORT")
 	viper.BindEnv("AppEnv", "APP_ENV")
 
+	viper.BindEnv("AdminEmails", "ADMIN_EMAILS")
+	// OIDC_ALLOWED_REDIRECTS прежнее имя настройки, читается, если ALLOWED_REDIRECTS не задана
+	viper.BindEnv("AllowedRedirects", "ALLOWED_REDIRECTS", "OIDC_ALLOWED_REDIRECTS")
+	viper.BindEnv("CorsOrigins", "CORS_ORIGINS")
+	viper.BindEnv("TrustedProxies", "TRUSTED_PROXIES")
+	viper.BindEnv("ProxyHeader", "PROXY_HEADER")
+
+	viper.BindEnv("AuthMode", "AUTH_MODE")
+	viper.BindEnv("CookieDomain", "COOKIE_DOMAIN")
+	viper.BindEnv("CookieSameSite", "COOKIE_SAMESITE")
+	viper.BindEnv("CookieInsecure", "COOKIE_INSECURE")
+
 	viper.BindEnv("SessionSecret", "SESSION_SECRET")
 	viper.BindEnv("SessionExpire", "SESSION_EXPIRE")
 	viper.BindEnv("RefreshExpire", "REFRESH_EXPIRE")
 	viper.BindEnv("VerificationExpire", "VERIFICATION_EXPIRE")
+	viper.BindEnv("MagicLinkExpire", "MAGIC_LINK_EXPIRE")
+	viper.BindEnv("TwoFactorExpire", "TWO_FACTOR_EXPIRE")
+	viper.BindEnv("EmailCancelExpire", "EMAIL_CANCEL_EXPIRE")
+	viper.BindEnv("PhoneCodeExpire", "PHONE_CODE_EXPIRE")
+	viper.BindEnv("ImpersonateExpire", "IMPERSONATE_EXPIRE")
+	viper.BindEnv("ReservationExpire", "RESERVATION_EXPIRE")
+	viper.BindEnv("IdempotencyExpire", "IDEMPOTENCY_EXPIRE")
+
+	viper.BindEnv("PasswordMinLength", "PASSWORD_MIN_LENGTH")
+	viper.BindEnv("PasswordRequireUpper", "PASSWORD_REQUIRE_UPPER")
+	viper.BindEnv("PasswordRequireLower", "PASSWORD_REQUIRE_LOWER")
+	viper.BindEnv("PasswordRequireDigit", "PASSWORD_REQUIRE_DIGIT")
+	viper.BindEnv("PasswordRequireSymbol", "PASSWORD_REQUIRE_SYMBOL")
+	viper.BindEnv("PasswordHistory", "PASSWORD_HISTORY")
+	viper.BindEnv("PasswordBreachFile", "PASSWORD_BREACH_FILE")
+
+	viper.BindEnv("LoginThrottleStore", "LOGIN_THROTTLE_STORE")
+	viper.BindEnv("LoginMaxAttempts", "LOGIN_MAX_ATTEMPTS")
+	viper.BindEnv("LoginIPMaxAttempts", "LOGIN_IP_MAX_ATTEMPTS")
+	viper.BindEnv("LoginBackoff", "LOGIN_BACKOFF")
+	viper.BindEnv("LoginLockout", "LOGIN_LOCKOUT")
+
+	viper.BindEnv("JwtAlgorithm", "JWT_ALGORITHM")
+	viper.BindEnv("JwtKeysDir", "JWT_KEYS_DIR")
+	viper.BindEnv("JwtKeyRotation", "JWT_KEY_ROTATION")
+	viper.BindEnv("JwtLegacyUntil", "JWT_LEGACY_UNTIL")
+
+	viper.BindEnv("OidcProviders", "OIDC_PROVIDERS")
+	viper.BindEnv("OidcCallbackURL", "OIDC_CALLBACK_URL")
+	viper.BindEnv("OidcStateExpire", "OIDC_STATE_EXPIRE")
 
 	viper.BindEnv("SmtpHost", "SMTP_HOST")
 	viper.BindEnv("SmtpPort", "SMTP_PORT")
@@ -62,9 +178,55 @@ func (config *AppConfig) LoadConfig() error {
 	viper.BindEnv("SmtpPassword", "SMTP_PASSWORD")
 	viper.BindEnv("SmtpSender", "SMTP_SENDER")
 
+	viper.BindEnv("SmsProvider", "SMS_PROVIDER")
+	viper.BindEnv("SmsFile", "SMS_FILE")
+
+	viper.BindEnv("Currency", "CURRENCY")
+	viper.BindEnv("TaxRate", "TAX_RATE")
+	viper.BindEnv("ShippingFee", "SHIPPING_FEE")
+	viper.BindEnv("FreeShippingFrom", "FREE_SHIPPING_FROM")
+
+	viper.BindEnv("PaymentProvider", "PAYMENT_PROVIDER")
+	viper.BindEnv("PaymentWebhookSecret", "PAYMENT_WEBHOOK_SECRET")
+	viper.BindEnv("PaymentWebhookURL", "PAYMENT_WEBHOOK_URL")
+
+	viper.BindEnv("StorageProvider", "STORAGE_PROVIDER")
+	viper.BindEnv("StorageDir", "STORAGE_DIR")
+	viper.BindEnv("StoragePublicURL", "STORAGE_PUBLIC_URL")
+	viper.BindEnv("UploadMaxSize", "UPLOAD_MAX_SIZE")
+	viper.BindEnv("UploadPort", "UPLOAD_PORT")
+
+	viper.BindEnv("S3Endpoint", "S3_ENDPOINT")
+	viper.BindEnv("S3Region", "S3_REGION")
+	viper.BindEnv("S3Bucket", "S3_BUCKET")
+	viper.BindEnv("S3AccessKey", "S3_ACCESS_KEY")
+	viper.BindEnv("S3SecretKey", "S3_SECRET_KEY")
+
 	if err := viper.Unmarshal(config); err != nil {
 		return fmt.Errorf("unable to decode into struct: %w", err)
 	}
 
+	config.Oidc = make(map[string]OidcProviderConfig)
+	for _, name := range SplitList(config.OidcProviders) {
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		config.Oidc[name] = OidcProviderConfig{
+			Issuer:       viper.GetString(prefix + "ISSUER"),
+			ClientID:     viper.GetString(prefix + "CLIENT_ID"),
+			ClientSecret: viper.GetString(prefix + "CLIENT_SECRET"),
+		}
+	}
+
 	return nil
 }
+
+// SplitList разбирает список значений, перечисленных через запятую
+func SplitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
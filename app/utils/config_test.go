@@ -0,0 +1,31 @@
+package utils
+
+import "testing"
+
+func TestLoadConfigAllowedRedirects(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		legacy  string
+		want    string
+	}{
+		{"current name", "https://shop.example.com/", "", "https://shop.example.com/"},
+		{"legacy name", "", "https://legacy.example.com/", "https://legacy.example.com/"},
+		{"current name wins", "https://shop.example.com/", "https://legacy.example.com/", "https://shop.example.com/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("ALLOWED_REDIRECTS", tt.current)
+			t.Setenv("OIDC_ALLOWED_REDIRECTS", tt.legacy)
+
+			var config AppConfig
+			if err := config.LoadConfig(); err != nil {
+				t.Fatal(err)
+			}
+			if config.AllowedRedirects != tt.want {
+				t.Errorf("AllowedRedirects = %q, want %q", config.AllowedRedirects, tt.want)
+			}
+		})
+	}
+}
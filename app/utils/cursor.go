@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor позиция в списке, отсортированном по значению Value и ID.
+// Sort фиксирует сортировку, для которой выдан курсор.
+type Cursor struct {
+	Sort  string    `json:"s"`
+	Value string    `json:"v"`
+	ID    uuid.UUID `json:"id"`
+}
+
+// Encode упаковывает курсор в непрозрачную строку для параметра cursor
+func (cursor Cursor) Encode() string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor разбирает строку, полученную из Encode
+func DecodeCursor(value string) (Cursor, error) {
+	var cursor Cursor
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, ErrInvalidCursor
+	}
+
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == uuid.Nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
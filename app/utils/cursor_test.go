@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursor := Cursor{Sort: "-created", Value: "2024-05-01T10:00:00.123456789Z", ID: uuid.New()}
+
+	decoded, err := DecodeCursor(cursor.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded != cursor {
+		t.Errorf("DecodeCursor(Encode()) = %+v, want %+v", decoded, cursor)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	encode := func(value string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"not base64", "!!!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"s":"price","v":"1","id":"` + uuid.NewString() + `"}`))},
+		{"not json", encode("cursor")},
+		{"missing id", encode(`{"s":"price","v":"1"}`)},
+		{"nil id", encode(`{"s":"price","v":"1","id":"` + uuid.Nil.String() + `"}`)},
+		{"invalid id", encode(`{"s":"price","v":"1","id":"42"}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if cursor, err := DecodeCursor(tt.value); err != ErrInvalidCursor {
+				t.Errorf("DecodeCursor(%q) = %+v, %v, want ErrInvalidCursor", tt.value, cursor, err)
+			}
+		})
+	}
+}
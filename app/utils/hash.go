@@ -1,6 +1,11 @@
 package utils
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"golang.org/x/crypto/bcrypt"
+)
 
 func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
@@ -11,3 +16,32 @@ func CheckPasswordHash(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+// HashToken возвращает SHA-256 токена в hex; подходит для случайных секретов, которые не нужно хранить в открытом виде
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ApiKeyPrefix общий префикс API-ключей, по которому их легко найти в утекших логах и коде
+const ApiKeyPrefix = "fsn_"
+
+// GenerateApiKey создает случайный API-ключ
+func GenerateApiKey() (string, error) {
+	token, err := GenerateRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	return ApiKeyPrefix + token, nil
+}
+
+// GenerateRandomToken создает случайный токен из 32 байт в hex
+func GenerateRandomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(raw), nil
+}
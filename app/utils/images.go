@@ -0,0 +1,224 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"path"
+)
+
+// ImageSize размер, в котором сохраняется изображение; MaxSide ограничивает большую сторону,
+// 0 — исходные размеры
+type ImageSize struct {
+	Name    string
+	MaxSide int
+}
+
+var (
+	// ProductImageSizes размеры изображений галереи товара
+	ProductImageSizes = []ImageSize{
+		{Name: "original", MaxSide: 0},
+		{Name: "large", MaxSide: 1200},
+		{Name: "medium", MaxSide: 600},
+		{Name: "thumb", MaxSide: 200},
+	}
+
+	// AvatarSizes размеры аватара пользователя
+	AvatarSizes = []ImageSize{
+		{Name: "original", MaxSide: 0},
+		{Name: "medium", MaxSide: 256},
+		{Name: "thumb", MaxSide: 64},
+	}
+)
+
+// MaxUploadFiles сколько файлов можно загрузить одним запросом
+const MaxUploadFiles = 10
+
+// maxImagePixels защищает от изображений, которые занимают мало места, но огромны после декодирования
+const maxImagePixels = 24_000_000
+
+// imageSlots ограничивает число изображений, декодируемых одновременно всеми запросами:
+// каждое занимает в памяти до нескольких сотен мегабайт
+var imageSlots = make(chan struct{}, 2)
+
+var (
+	ErrUnsupportedImage = errors.New("unsupported image type")
+	ErrImageTooLarge    = errors.New("image dimensions are too large")
+)
+
+// EncodedImage изображение одного размера, готовое к сохранению
+type EncodedImage struct {
+	Size        string
+	ContentType string
+	Ext         string
+	Data        []byte
+}
+
+// ProcessImage определяет тип по содержимому, а не по заголовкам клиента, и перекодирует изображение
+// во все размеры. Перекодирование удаляет метаданные (EXIF с геолокацией и т.п.). JPEG сохраняется
+// в JPEG, PNG и GIF (первый кадр) — в PNG. Изображения не увеличиваются.
+func ProcessImage(data []byte, sizes []ImageSize) ([]EncodedImage, error) {
+	var decode func([]byte) (image.Image, error)
+	contentType, ext := "image/png", ".png"
+
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		decode = func(data []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(data)) }
+		contentType, ext = "image/jpeg", ".jpg"
+	case "image/png":
+		decode = func(data []byte) (image.Image, error) { return png.Decode(bytes.NewReader(data)) }
+	case "image/gif":
+		decode = func(data []byte) (image.Image, error) { return gif.Decode(bytes.NewReader(data)) }
+	default:
+		return nil, ErrUnsupportedImage
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, ErrUnsupportedImage
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, ErrImageTooLarge
+	}
+
+	imageSlots <- struct{}{}
+	defer func() { <-imageSlots }()
+
+	decoded, err := decode(data)
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+
+	source := image.NewRGBA(image.Rect(0, 0, decoded.Bounds().Dx(), decoded.Bounds().Dy()))
+	draw.Draw(source, source.Bounds(), decoded, decoded.Bounds().Min, draw.Src)
+
+	images := make([]EncodedImage, 0, len(sizes))
+	for _, size := range sizes {
+		var buffer bytes.Buffer
+		resized := resizeImage(source, size.MaxSide)
+
+		if contentType == "image/jpeg" {
+			err = jpeg.Encode(&buffer, resized, &jpeg.Options{Quality: 90})
+		} else {
+			err = png.Encode(&buffer, resized)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not encode image: %w", err)
+		}
+
+		images = append(images, EncodedImage{
+			Size:        size.Name,
+			ContentType: contentType,
+			Ext:         ext,
+			Data:        buffer.Bytes(),
+		})
+	}
+
+	return images, nil
+}
+
+// resizeImage уменьшает изображение так, чтобы большая сторона не превышала maxSide.
+// Каждый пиксель результата — среднее покрываемого им прямоугольника исходного изображения.
+func resizeImage(source *image.RGBA, maxSide int) *image.RGBA {
+	width, height := source.Bounds().Dx(), source.Bounds().Dy()
+	if maxSide <= 0 || width <= maxSide && height <= maxSide {
+		return source
+	}
+
+	targetWidth, targetHeight := maxSide, maxSide
+	if width >= height {
+		targetHeight = max(1, height*maxSide/width)
+	} else {
+		targetWidth = max(1, width*maxSide/height)
+	}
+
+	target := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	for y := 0; y < targetHeight; y++ {
+		top, bottom := y*height/targetHeight, max((y+1)*height/targetHeight, y*height/targetHeight+1)
+
+		for x := 0; x < targetWidth; x++ {
+			left, right := x*width/targetWidth, max((x+1)*width/targetWidth, x*width/targetWidth+1)
+
+			var r, g, b, a, count int
+			for sy := top; sy < bottom; sy++ {
+				row := source.Pix[sy*source.Stride:]
+				for sx := left; sx < right; sx++ {
+					pixel := row[sx*4 : sx*4+4]
+					r += int(pixel[0])
+					g += int(pixel[1])
+					b += int(pixel[2])
+					a += int(pixel[3])
+					count++
+				}
+			}
+
+			offset := y*target.Stride + x*4
+			target.Pix[offset] = uint8(r / count)
+			target.Pix[offset+1] = uint8(g / count)
+			target.Pix[offset+2] = uint8(b / count)
+			target.Pix[offset+3] = uint8(a / count)
+		}
+	}
+
+	return target
+}
+
+// StoreImage обрабатывает изображение и сохраняет все размеры под prefix. Возвращает ключ оригинала,
+// из которого строятся ключи остальных размеров. При ошибке уже сохраненные файлы удаляются.
+func StoreImage(ctx context.Context, storage BlobStorage, prefix string, data []byte, sizes []ImageSize) (string, error) {
+	images, err := ProcessImage(data, sizes)
+	if err != nil {
+		return "", err
+	}
+
+	var stored []string
+	for _, encoded := range images {
+		key := prefix + "/" + encoded.Size + encoded.Ext
+		if err := storage.Put(ctx, key, encoded.ContentType, encoded.Data); err != nil {
+			for _, key := range stored {
+				storage.Delete(ctx, key)
+			}
+			return "", err
+		}
+		stored = append(stored, key)
+	}
+
+	return stored[0], nil
+}
+
+// DeleteImage удаляет все размеры изображения
+func DeleteImage(ctx context.Context, storage BlobStorage, key string, sizes []ImageSize) error {
+	var errs []error
+	for _, size := range sizes {
+		if err := storage.Delete(ctx, ImageKey(key, size.Name)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ImageKey возвращает ключ изображения нужного размера по ключу любого другого размера
+func ImageKey(key, size string) string {
+	return path.Dir(key) + "/" + size + path.Ext(key)
+}
+
+// ImageURLs возвращает адреса изображения во всех размерах
+func ImageURLs(storage BlobStorage, key string, sizes []ImageSize) map[string]string {
+	urls := make(map[string]string, len(sizes))
+	for _, size := range sizes {
+		urls[size.Name] = storage.URL(ImageKey(key, size.Name))
+	}
+
+	return urls
+}
@@ -6,43 +6,66 @@ import (
 )
 
 type JWTService interface {
-	GenerateAccessToken(expirationTime time.Duration, userId string) (string, error)
+	GenerateAccessToken(expirationTime time.Duration, userId string, sessionId string) (string, error)
 	GenerateRefreshToken(expirationTime time.Duration, userId string, jti string) (string, error)
+	GenerateImpersonationToken(expirationTime time.Duration, userId string, sessionId string, actorId string) (string, error)
 	ValidateToken(token string) (*jwt.Token, error)
+	PublicKeys() JWKSet
 }
 
 type JwtCustomClaim struct {
-	UserID string `json:"user_id"`
+	UserID    string       `json:"user_id"`
+	SessionID string       `json:"sid,omitempty"`
+	Actor     *ActorClaims `json:"act,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// ActorClaims пользователь, который действует от имени UserID (RFC 8693)
+type ActorClaims struct {
+	UserID string `json:"sub"`
+}
+
 type jwtService struct {
 	secret string
+	keys   *keyRing
+	// legacyUntil до этого момента асимметричный сервис принимает HS256-токены, подписанные secret
+	legacyUntil time.Time
 }
 
+// NewJWTService создает сервис, подписывающий токены HS256 общим секретом
 func NewJWTService(secret string) JWTService {
 	return &jwtService{
 		secret: secret,
 	}
 }
 
-func (s *jwtService) GenerateAccessToken(expirationTime time.Duration, userId string) (string, error) {
+// NewAsymmetricJWTService создает сервис, подписывающий токены RS256 или EdDSA ключами из keysDir.
+// HS256-токены, выпущенные до перехода, принимаются только до legacyUntil; нулевое время отключает их сразу.
+func NewAsymmetricJWTService(secret, algorithm, keysDir string, rotation, retention time.Duration, legacyUntil time.Time) (JWTService, error) {
+	keys, err := newKeyRing(algorithm, keysDir, rotation, retention)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jwtService{
+		secret:      secret,
+		keys:        keys,
+		legacyUntil: legacyUntil,
+	}, nil
+}
+
+func (s *jwtService) GenerateAccessToken(expirationTime time.Duration, userId string, sessionId string) (string, error) {
 	expiration := time.Now().Add(expirationTime)
 	claims := &JwtCustomClaim{
 		RegisteredClaims: jwt.RegisteredClaims{
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			ExpiresAt: jwt.NewNumericDate(expiration),
 		},
-		UserID: userId,
+		UserID:    userId,
+		SessionID: sessionId,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(s.secret))
-	if err != nil {
-		return "", err
-	}
-
-	return tokenString, nil
+	return s.sign(claims)
 }
 
 func (s *jwtService) GenerateRefreshToken(expirationTime time.Duration, userId string, jti string) (string, error) {
@@ -56,22 +79,73 @@ func (s *jwtService) GenerateRefreshToken(expirationTime time.Duration, userId s
 		UserID: userId,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(s.secret))
+	return s.sign(claims)
+}
+
+// GenerateImpersonationToken выпускает access-токен пользователя userId для администратора actorId.
+// Токен привязан к сессии администратора sessionId и отзывается вместе с ней.
+func (s *jwtService) GenerateImpersonationToken(expirationTime time.Duration, userId string, sessionId string, actorId string) (string, error) {
+	expiration := time.Now().Add(expirationTime)
+	claims := &JwtCustomClaim{
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiration),
+		},
+		UserID:    userId,
+		SessionID: sessionId,
+		Actor:     &ActorClaims{UserID: actorId},
+	}
+
+	return s.sign(claims)
+}
+
+// sign подписывает claims текущим ключом
+func (s *jwtService) sign(claims jwt.Claims) (string, error) {
+	if s.keys == nil {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		return token.SignedString([]byte(s.secret))
+	}
+
+	key, err := s.keys.current()
 	if err != nil {
 		return "", err
 	}
 
-	return tokenString, nil
+	token := jwt.NewWithClaims(s.keys.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
 }
 
 func (s *jwtService) ValidateToken(tokenStr string) (*jwt.Token, error) {
 	claims := &JwtCustomClaim{}
 	return jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+			if s.secret == "" || s.keys != nil && !time.Now().Before(s.legacyUntil) {
+				return nil, jwt.ErrSignatureInvalid
+			}
+
+			return []byte(s.secret), nil
+		}
+
+		if s.keys == nil || token.Method.Alg() != s.keys.method.Alg() {
+			return nil, jwt.ErrSignatureInvalid
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		key, ok := s.keys.lookup(kid)
+		if !ok {
 			return nil, jwt.ErrSignatureInvalid
 		}
 
-		return []byte(s.secret), nil
+		return key.private.Public(), nil
 	})
 }
+
+// PublicKeys возвращает публичные ключи для проверки токенов сторонними сервисами
+func (s *jwtService) PublicKeys() JWKSet {
+	if s.keys == nil {
+		return JWKSet{Keys: []JWK{}}
+	}
+
+	return s.keys.publicKeys()
+}
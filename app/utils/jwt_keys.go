@@ -0,0 +1,359 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyReloadInterval = 10 * time.Second
+
+// kidTimeLayout время выпуска в начале kid; по нему определяется возраст ключа, а не по времени
+// изменения файла, которое сбрасывается при копировании и монтировании каталога
+const kidTimeLayout = "20060102T150405Z"
+
+// JWK публичный ключ в формате RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet набор публичных ключей для /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+type signingKey struct {
+	kid       string
+	createdAt time.Time
+	private   crypto.Signer
+}
+
+// keyRing хранит ключи подписи в каталоге dir (по одному PEM-файлу <kid>.pem).
+// Новый ключ выпускается, когда текущий старше rotation; прежние ключи остаются
+// пригодными для проверки еще retention, пока не истекут подписанные ими токены.
+type keyRing struct {
+	mu         sync.RWMutex
+	method     jwt.SigningMethod
+	dir        string
+	rotation   time.Duration
+	retention  time.Duration
+	keys       []*signingKey
+	lastReload time.Time
+}
+
+func newKeyRing(algorithm, dir string, rotation, retention time.Duration) (*keyRing, error) {
+	var method jwt.SigningMethod
+	switch algorithm {
+	case "RS256":
+		method = jwt.SigningMethodRS256
+	case "EdDSA":
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported jwt algorithm %q", algorithm)
+	}
+
+	ring := &keyRing{
+		method:    method,
+		dir:       dir,
+		rotation:  rotation,
+		retention: retention,
+	}
+
+	if err := ring.reload(); err != nil {
+		return nil, err
+	}
+
+	if _, err := ring.current(); err != nil {
+		return nil, err
+	}
+
+	return ring, nil
+}
+
+// current возвращает ключ для подписи, при необходимости выпуская новый
+func (r *keyRing) current() (*signingKey, error) {
+	r.mu.RLock()
+	key := r.newest()
+	r.mu.RUnlock()
+
+	if key != nil && !r.due(key) {
+		return key, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key = r.newest()
+	if key != nil && !r.due(key) {
+		return key, nil
+	}
+
+	key, err := r.generate()
+	if err != nil {
+		return nil, err
+	}
+
+	r.keys = append(r.keys, key)
+	r.prune()
+
+	return key, nil
+}
+
+// lookup ищет ключ по kid, перечитывая каталог, если ключ выпущен другим экземпляром
+func (r *keyRing) lookup(kid string) (*signingKey, bool) {
+	r.mu.RLock()
+	key := r.find(kid)
+	stale := time.Since(r.lastReload) > keyReloadInterval
+	r.mu.RUnlock()
+
+	if key == nil && stale {
+		if err := r.reload(); err == nil {
+			r.mu.RLock()
+			key = r.find(kid)
+			r.mu.RUnlock()
+		}
+	}
+
+	if key == nil || r.expired(key) {
+		return nil, false
+	}
+
+	return key, true
+}
+
+// publicKeys возвращает все действующие ключи в формате JWKS, перечитывая каталог, чтобы в наборе
+// были ключи, выпущенные другими экземплярами
+func (r *keyRing) publicKeys() JWKSet {
+	r.mu.RLock()
+	stale := time.Since(r.lastReload) > keyReloadInterval
+	r.mu.RUnlock()
+
+	if stale {
+		r.reload()
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range r.keys {
+		if r.expired(key) {
+			continue
+		}
+
+		jwk := JWK{Kid: key.kid, Use: "sig", Alg: r.method.Alg()}
+		switch public := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+func (r *keyRing) due(key *signingKey) bool {
+	return r.rotation > 0 && time.Since(key.createdAt) > r.rotation
+}
+
+func (r *keyRing) expired(key *signingKey) bool {
+	return r.rotation > 0 && time.Since(key.createdAt) > r.rotation+r.retention
+}
+
+func (r *keyRing) newest() *signingKey {
+	if len(r.keys) == 0 {
+		return nil
+	}
+
+	return r.keys[len(r.keys)-1]
+}
+
+func (r *keyRing) find(kid string) *signingKey {
+	for _, key := range r.keys {
+		if key.kid == kid {
+			return key
+		}
+	}
+
+	return nil
+}
+
+// prune удаляет ключи, которыми больше не может быть подписан ни один живой токен
+func (r *keyRing) prune() {
+	active := r.keys[:0]
+	for _, key := range r.keys {
+		if r.expired(key) {
+			os.Remove(filepath.Join(r.dir, key.kid+".pem"))
+			continue
+		}
+		active = append(active, key)
+	}
+	r.keys = active
+}
+
+// reload перечитывает ключи из каталога
+func (r *keyRing) reload() error {
+	if err := os.MkdirAll(r.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create keys directory: %w", err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(r.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	var keys []*signingKey
+	for _, path := range paths {
+		key, err := r.load(path)
+		if err != nil {
+			return err
+		}
+
+		if key != nil {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].createdAt.Before(keys[j].createdAt)
+	})
+
+	r.mu.Lock()
+	r.keys = keys
+	r.lastReload = time.Now()
+	r.mu.Unlock()
+
+	return nil
+}
+
+// load читает PEM-файл ключа; ключи другого алгоритма пропускаются
+func (r *keyRing) load(path string) (*signingKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %w", path, err)
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode key %s", path)
+	}
+
+	var parsed interface{}
+	if block.Type == "RSA PRIVATE KEY" {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key %s: %w", path, err)
+	}
+
+	var signer crypto.Signer
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		if r.method != jwt.SigningMethodRS256 {
+			return nil, nil
+		}
+		signer = private
+	case ed25519.PrivateKey:
+		if r.method != jwt.SigningMethodEdDSA {
+			return nil, nil
+		}
+		signer = private
+	default:
+		return nil, fmt.Errorf("unsupported key type in %s", path)
+	}
+
+	kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+	createdAt, err := keyCreatedAt(kid, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signingKey{
+		kid:       kid,
+		createdAt: createdAt,
+		private:   signer,
+	}, nil
+}
+
+// keyCreatedAt возвращает время выпуска ключа из kid; для ключей, положенных в каталог вручную
+// под другим именем, используется время изменения файла
+func keyCreatedAt(kid, path string) (time.Time, error) {
+	if prefix, _, ok := strings.Cut(kid, "-"); ok {
+		if createdAt, err := time.Parse(kidTimeLayout, prefix); err == nil {
+			return createdAt, nil
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return info.ModTime(), nil
+}
+
+// generate создает новый ключ и сохраняет его в каталог
+func (r *keyRing) generate() (*signingKey, error) {
+	var signer crypto.Signer
+	var err error
+	if r.method == jwt.SigningMethodRS256 {
+		signer, err = rsa.GenerateKey(rand.Reader, 2048)
+	} else {
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	kid := now.Format(kidTimeLayout) + "-" + hex.EncodeToString(suffix)
+	content := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(r.dir, kid+".pem"), content, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to save key: %w", err)
+	}
+
+	return &signingKey{kid: kid, createdAt: now, private: signer}, nil
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAsymmetricJWTServiceLegacyTokens(t *testing.T) {
+	legacy, err := NewJWTService("secret").GenerateAccessToken(time.Hour, "user", "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		legacyUntil time.Time
+		valid       bool
+	}{
+		{"disabled", time.Time{}, false},
+		{"before cutoff", time.Now().Add(time.Hour), true},
+		{"after cutoff", time.Now().Add(-time.Hour), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, err := NewAsymmetricJWTService("secret", "EdDSA", t.TempDir(), time.Hour, time.Hour, tt.legacyUntil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, err := service.ValidateToken(legacy)
+			if valid := err == nil && token.Valid; valid != tt.valid {
+				t.Errorf("legacy token valid = %v, want %v (err %v)", valid, tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestKeyCreatedAtFromKid(t *testing.T) {
+	dir := t.TempDir()
+	service, err := NewAsymmetricJWTService("", "EdDSA", dir, time.Hour, time.Hour, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := service.PublicKeys().Keys
+	if len(keys) != 1 {
+		t.Fatalf("got %d keys, want 1", len(keys))
+	}
+
+	// Копирование каталога меняет время изменения файла, но не возраст ключа
+	path := filepath.Join(dir, keys[0].Kid+".pem")
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	createdAt, err := keyCreatedAt(keys[0].Kid, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(createdAt) > time.Minute {
+		t.Errorf("createdAt = %v, want time from kid", createdAt)
+	}
+}
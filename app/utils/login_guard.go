@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Attempts состояние счетчика неудачных попыток входа
+type Attempts struct {
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+// AttemptStore хранит счетчики неудачных попыток входа по произвольному ключу
+type AttemptStore interface {
+	Get(key string) (Attempts, error)
+	// Increment увеличивает счетчик; если последняя неудача была раньше чем window назад, счет начинается заново
+	Increment(key string, window time.Duration) (Attempts, error)
+	Lock(key string, until time.Time) error
+	Reset(key string) error
+}
+
+// LoginPolicy параметры ограничения попыток входа
+type LoginPolicy struct {
+	MaxAccountAttempts int
+	MaxIPAttempts      int
+	Backoff            time.Duration
+	Lockout            time.Duration
+}
+
+// LoginGuard ограничивает подбор паролей: после каждой неудачи следующая попытка
+// откладывается экспоненциально, а после MaxAttempts ключ блокируется на Lockout
+type LoginGuard struct {
+	store  AttemptStore
+	policy LoginPolicy
+}
+
+func NewLoginGuard(store AttemptStore, policy LoginPolicy) *LoginGuard {
+	return &LoginGuard{
+		store:  store,
+		policy: policy,
+	}
+}
+
+// AccountKey ключ счетчика для учетной записи
+func AccountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// IPKey ключ счетчика для адреса клиента
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Wait возвращает, сколько нужно подождать до следующей попытки по любому из ключей
+func (g *LoginGuard) Wait(keys ...string) (time.Duration, error) {
+	now := time.Now()
+
+	var wait time.Duration
+	for _, key := range keys {
+		attempts, err := g.store.Get(key)
+		if err != nil {
+			return 0, err
+		}
+
+		next := attempts.LockedUntil
+		if attempts.Failures > 0 {
+			if backoffUntil := attempts.LastFailureAt.Add(g.backoff(attempts.Failures)); backoffUntil.After(next) {
+				next = backoffUntil
+			}
+		}
+
+		if remaining := next.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+
+	return wait, nil
+}
+
+// FailAccount учитывает неудачную попытку входа в учетную запись и сообщает, была ли она только что заблокирована
+func (g *LoginGuard) FailAccount(email string) (bool, time.Time, error) {
+	return g.fail(AccountKey(email), g.policy.MaxAccountAttempts)
+}
+
+// FailIP учитывает неудачную попытку входа с адреса клиента
+func (g *LoginGuard) FailIP(ip string) error {
+	_, _, err := g.fail(IPKey(ip), g.policy.MaxIPAttempts)
+	return err
+}
+
+// Record учитывает попытку по произвольному ключу, например запрос письма со ссылкой для входа,
+// чтобы такие запросы ограничивались отдельно от попыток входа по паролю
+func (g *LoginGuard) Record(key string, maxAttempts int) error {
+	_, _, err := g.fail(key, maxAttempts)
+	return err
+}
+
+// Reset сбрасывает счетчик, например после успешного входа или ручной разблокировки
+func (g *LoginGuard) Reset(key string) error {
+	return g.store.Reset(key)
+}
+
+func (g *LoginGuard) fail(key string, maxAttempts int) (bool, time.Time, error) {
+	attempts, err := g.store.Increment(key, g.policy.Lockout)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	if maxAttempts <= 0 || attempts.Failures != maxAttempts {
+		return false, time.Time{}, nil
+	}
+
+	until := time.Now().Add(g.policy.Lockout)
+	if err := g.store.Lock(key, until); err != nil {
+		return false, time.Time{}, err
+	}
+
+	return true, until, nil
+}
+
+// backoff задержка после failures неудач: Backoff, 2*Backoff, 4*Backoff... но не больше Lockout
+func (g *LoginGuard) backoff(failures int) time.Duration {
+	if g.policy.Backoff <= 0 {
+		return 0
+	}
+
+	delay := g.policy.Backoff
+	for i := 1; i < failures; i++ {
+		delay *= 2
+		if g.policy.Lockout > 0 && delay >= g.policy.Lockout {
+			return g.policy.Lockout
+		}
+	}
+
+	return delay
+}
+
+type memoryAttemptStore struct {
+	mu         sync.Mutex
+	attempts   map[string]Attempts
+	increments int
+}
+
+// NewMemoryAttemptStore создает хранилище счетчиков в памяти процесса
+func NewMemoryAttemptStore() AttemptStore {
+	return &memoryAttemptStore{
+		attempts: make(map[string]Attempts),
+	}
+}
+
+func (s *memoryAttemptStore) Get(key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.attempts[key], nil
+}
+
+func (s *memoryAttemptStore) Increment(key string, window time.Duration) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.increments++
+	if s.increments%1000 == 0 {
+		s.sweep(now, window)
+	}
+
+	attempts := s.attempts[key]
+	if now.Sub(attempts.LastFailureAt) > window {
+		attempts.Failures = 0
+	}
+
+	attempts.Failures++
+	attempts.LastFailureAt = now
+	s.attempts[key] = attempts
+
+	return attempts, nil
+}
+
+func (s *memoryAttemptStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts := s.attempts[key]
+	attempts.LockedUntil = until
+	s.attempts[key] = attempts
+
+	return nil
+}
+
+func (s *memoryAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+// sweep удаляет устаревшие счетчики, чтобы карта не росла бесконечно
+func (s *memoryAttemptStore) sweep(now time.Time, window time.Duration) {
+	for key, attempts := range s.attempts {
+		if now.Sub(attempts.LastFailureAt) > window && now.After(attempts.LockedUntil) {
+			delete(s.attempts, key)
+		}
+	}
+}
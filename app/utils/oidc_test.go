@@ -0,0 +1,261 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIssuer минимальный провайдер OpenID Connect: discovery, ключи и обмен кода с проверкой PKCE
+type mockIssuer struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+	secret   string
+
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T, clientID, secret string) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &mockIssuer{key: key, clientID: clientID, secret: secret, codes: make(map[string]mockAuthorization)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                issuer.server.URL,
+			"authorization_endpoint":                issuer.server.URL + "/authorize",
+			"token_endpoint":                        issuer.server.URL + "/token",
+			"jwks_uri":                              issuer.server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", issuer.token)
+
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+// authorize выдает код для адреса авторизации, как после входа пользователя у провайдера
+func (m *mockIssuer) authorize(authURL string, claims jwt.MapClaims) string {
+	query, _ := url.Parse(authURL)
+	code := "code-" + query.Query().Get("state")
+
+	m.mu.Lock()
+	m.codes[code] = mockAuthorization{
+		challenge: query.Query().Get("code_challenge"),
+		nonce:     query.Query().Get("nonce"),
+		claims:    claims,
+	}
+	m.mu.Unlock()
+
+	return code
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, _ := r.BasicAuth()
+	if clientID != m.clientID || secret != m.secret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	m.mu.Lock()
+	authorization, ok := m.codes[r.PostFormValue("code")]
+	delete(m.codes, r.PostFormValue("code"))
+	m.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != authorization.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   m.server.URL,
+		"aud":   m.clientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": authorization.nonce,
+	}
+	for name, value := range authorization.claims {
+		claims[name] = value
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = "test"
+	signed, err := idToken.SignedString(m.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     signed,
+	})
+}
+
+func TestOIDCProviderAuthCodeURL(t *testing.T) {
+	issuer := newMockIssuer(t, "client", "secret")
+	provider := NewOIDCProvider(issuer.server.URL, "client", "secret", "http://localhost/callback", []string{"email"})
+
+	verifier := GenerateOAuthVerifier()
+	authURL, err := provider.AuthCodeURL(context.Background(), "state-1", "nonce-1", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parsed.Scheme + "://" + parsed.Host + parsed.Path; got != issuer.server.URL+"/authorize" {
+		t.Errorf("authorization endpoint = %s", got)
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	want := map[string]string{
+		"state":                 "state-1",
+		"nonce":                 "nonce-1",
+		"client_id":             "client",
+		"response_type":         "code",
+		"redirect_uri":          "http://localhost/callback",
+		"code_challenge_method": "S256",
+		"code_challenge":        base64.RawURLEncoding.EncodeToString(challenge[:]),
+	}
+	for name, value := range want {
+		if got := parsed.Query().Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+	if scope := parsed.Query().Get("scope"); !strings.Contains(scope, "openid") || !strings.Contains(scope, "email") {
+		t.Errorf("scope = %q, want openid and email", scope)
+	}
+}
+
+func TestOIDCProviderExchange(t *testing.T) {
+	issuer := newMockIssuer(t, "client", "secret")
+	provider := NewOIDCProvider(issuer.server.URL, "client", "secret", "http://localhost/callback", []string{"email"})
+
+	tests := []struct {
+		name        string
+		verifier    string
+		nonce       string
+		claims      jwt.MapClaims
+		want        *ExternalIdentity
+		wantErr     bool
+		unknownCode bool
+	}{
+		{
+			name:   "verified email",
+			claims: jwt.MapClaims{"sub": "subject-1", "email": "user@example.com", "email_verified": true, "name": "User"},
+			want:   &ExternalIdentity{Subject: "subject-1", Email: "user@example.com", EmailVerified: true, Name: "User"},
+		},
+		{
+			name:   "unverified email",
+			claims: jwt.MapClaims{"sub": "subject-2", "email": "user@example.com"},
+			want:   &ExternalIdentity{Subject: "subject-2", Email: "user@example.com"},
+		},
+		{
+			name:     "wrong code verifier",
+			verifier: GenerateOAuthVerifier(),
+			claims:   jwt.MapClaims{"sub": "subject-3"},
+			wantErr:  true,
+		},
+		{
+			name:    "nonce mismatch",
+			nonce:   "other-nonce",
+			claims:  jwt.MapClaims{"sub": "subject-4"},
+			wantErr: true,
+		},
+		{
+			name:        "unknown code",
+			claims:      jwt.MapClaims{"sub": "subject-5"},
+			wantErr:     true,
+			unknownCode: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := fmt.Sprintf("state-%d", i)
+			nonce := "nonce-" + state
+			verifier := GenerateOAuthVerifier()
+
+			authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, verifier)
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := issuer.authorize(authURL, tt.claims)
+			if tt.unknownCode {
+				code = "unknown"
+			}
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+
+			identity, err := provider.Exchange(context.Background(), code, nonce, verifier)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Exchange() = %+v, want error", identity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *identity != *tt.want {
+				t.Errorf("Exchange() = %+v, want %+v", identity, tt.want)
+			}
+		})
+	}
+}
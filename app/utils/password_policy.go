@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// bcrypt учитывает только первые 72 байта пароля
+const passwordMaxBytes = 72
+
+// PasswordViolation нарушение политики паролей; Code стабилен и подходит для перевода на клиенте
+type PasswordViolation struct {
+	Code    string
+	Message string
+}
+
+// PasswordPolicy требования к новым паролям
+type PasswordPolicy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// History сколько последних паролей пользователя нельзя использовать повторно
+	History int
+	// Breaches проверка по базе утекших паролей; nil отключает проверку
+	Breaches *BreachChecker
+}
+
+// Check возвращает все нарушения политики; история паролей проверяется отдельно, так как требует базы данных
+func (p PasswordPolicy) Check(password string) ([]PasswordViolation, error) {
+	var violations []PasswordViolation
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, PasswordViolation{"too_short", "password is too short"})
+	}
+
+	if len(password) > passwordMaxBytes {
+		violations = append(violations, PasswordViolation{"too_long", "password is too long"})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, PasswordViolation{"missing_upper", "password must contain an uppercase letter"})
+	}
+
+	if p.RequireLower && !hasLower {
+		violations = append(violations, PasswordViolation{"missing_lower", "password must contain a lowercase letter"})
+	}
+
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, PasswordViolation{"missing_digit", "password must contain a digit"})
+	}
+
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, PasswordViolation{"missing_symbol", "password must contain a symbol"})
+	}
+
+	if p.Breaches != nil {
+		count, err := p.Breaches.Count(password)
+		if err != nil {
+			return nil, err
+		}
+
+		if count > 0 {
+			violations = append(violations, PasswordViolation{"breached", "password has appeared in a data breach"})
+		}
+	}
+
+	return violations, nil
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	strict := PasswordPolicy{MinLength: 8, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		want     []string
+	}{
+		{"meets all requirements", strict, "Str0ng!pass", nil},
+		{"too short", strict, "S0!a", []string{"too_short"}},
+		{"length counts runes", PasswordPolicy{MinLength: 8}, "пароль12", nil},
+		{"too long for bcrypt", PasswordPolicy{MinLength: 8}, strings.Repeat("a", 73), []string{"too_long"}},
+		{"missing classes", strict, "alllowercase", []string{"missing_upper", "missing_digit", "missing_symbol"}},
+		{"space counts as symbol", PasswordPolicy{RequireSymbol: true}, "with space", nil},
+		{"no requirements", PasswordPolicy{}, "", nil},
+		{"breached", PasswordPolicy{MinLength: 8, Breaches: NewBreachChecker(NewFileHashRange("testdata/breach.txt"))}, "password", []string{"breached"}},
+		{"not breached", PasswordPolicy{MinLength: 8, Breaches: NewBreachChecker(NewFileHashRange("testdata/breach.txt"))}, "unbreached", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := tt.policy.Check(tt.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var codes []string
+			for _, violation := range violations {
+				codes = append(codes, violation.Code)
+			}
+			if !reflect.DeepEqual(codes, tt.want) {
+				t.Errorf("Check(%q) = %v, want %v", tt.password, codes, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyCheckBreachError(t *testing.T) {
+	policy := PasswordPolicy{Breaches: NewBreachChecker(NewFileHashRange("testdata/missing.txt"))}
+	if _, err := policy.Check("password"); err == nil {
+		t.Error("Check() with an unavailable breach dataset returned no error")
+	}
+}
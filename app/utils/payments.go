@@ -0,0 +1,238 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// События платежного провайдера, которые обрабатывает приложение
+const (
+	PaymentEventCaptured = "payment.captured"
+	PaymentEventFailed   = "payment.failed"
+	PaymentEventRefunded = "payment.refunded"
+)
+
+var (
+	ErrPaymentNotFound  = errors.New("payment not found")
+	ErrPaymentState     = errors.New("payment cannot be processed in its current state")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// PaymentIntent намерение оплаты у провайдера. ClientSecret передается клиенту для подтверждения оплаты.
+type PaymentIntent struct {
+	Reference    string
+	ClientSecret string
+}
+
+// PaymentEvent событие из вебхука провайдера; Amount — сумма операции
+type PaymentEvent struct {
+	ID        string  `json:"id"`
+	Type      string  `json:"type"`
+	Reference string  `json:"reference"`
+	Amount    float64 `json:"amount"`
+	Currency  string  `json:"currency"`
+}
+
+// PaymentProvider платежный шлюз. Capture и Refund только запрашивают операцию: ее результат
+// приходит вебхуком, подпись которого проверяет VerifyWebhook.
+type PaymentProvider interface {
+	Name() string
+	CreateIntent(ctx context.Context, amount float64, currency, description string) (PaymentIntent, error)
+	Capture(ctx context.Context, reference string) error
+	Refund(ctx context.Context, reference string, amount float64) error
+	VerifyWebhook(payload []byte, headers http.Header) (PaymentEvent, error)
+}
+
+// FakePaymentSignatureHeader заголовок подписи вебхуков локального провайдера: "t=<unix>,v1=<hmac>"
+const FakePaymentSignatureHeader = "Payment-Signature"
+
+// fakeWebhookTolerance допустимое расхождение времени подписи, защищает от повтора старых вебхуков
+const fakeWebhookTolerance = 5 * time.Minute
+
+type fakeIntent struct {
+	amount   float64
+	currency string
+	captured bool
+	refunded int64
+}
+
+// FakePaymentProvider провайдер для локальной разработки и тестов: хранит платежи в памяти
+// и отправляет подписанные вебхуки на webhookURL, как это делает настоящий шлюз
+type FakePaymentProvider struct {
+	mu         sync.Mutex
+	secret     []byte
+	webhookURL string
+	client     *http.Client
+	intents    map[string]*fakeIntent
+}
+
+// NewFakePaymentProvider создает локальный провайдер; без webhookURL вебхуки не отправляются
+func NewFakePaymentProvider(secret, webhookURL string) *FakePaymentProvider {
+	return &FakePaymentProvider{
+		secret:     []byte(secret),
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		intents:    make(map[string]*fakeIntent),
+	}
+}
+
+// Name возвращает имя провайдера
+func (p *FakePaymentProvider) Name() string {
+	return "fake"
+}
+
+// CreateIntent регистрирует платеж на сумму amount
+func (p *FakePaymentProvider) CreateIntent(_ context.Context, amount float64, currency, _ string) (PaymentIntent, error) {
+	secret, err := GenerateRandomToken()
+	if err != nil {
+		return PaymentIntent{}, err
+	}
+
+	reference := "fake_pi_" + uuid.NewString()
+
+	p.mu.Lock()
+	p.intents[reference] = &fakeIntent{amount: amount, currency: currency}
+	p.mu.Unlock()
+
+	return PaymentIntent{Reference: reference, ClientSecret: reference + "_secret_" + secret}, nil
+}
+
+// Capture списывает платеж и отправляет вебхук payment.captured
+func (p *FakePaymentProvider) Capture(_ context.Context, reference string) error {
+	p.mu.Lock()
+	intent, ok := p.intents[reference]
+	if !ok {
+		p.mu.Unlock()
+		return ErrPaymentNotFound
+	}
+	if intent.captured {
+		p.mu.Unlock()
+		return ErrPaymentState
+	}
+	intent.captured = true
+	event := p.event(PaymentEventCaptured, reference, intent.amount, intent.currency)
+	p.mu.Unlock()
+
+	p.deliver(event)
+	return nil
+}
+
+// Refund возвращает часть списанного платежа и отправляет вебхук payment.refunded
+func (p *FakePaymentProvider) Refund(_ context.Context, reference string, amount float64) error {
+	p.mu.Lock()
+	intent, ok := p.intents[reference]
+	if !ok {
+		p.mu.Unlock()
+		return ErrPaymentNotFound
+	}
+	if !intent.captured || amount <= 0 || intent.refunded+ToCents(amount) > ToCents(intent.amount) {
+		p.mu.Unlock()
+		return ErrPaymentState
+	}
+	intent.refunded += ToCents(amount)
+	event := p.event(PaymentEventRefunded, reference, amount, intent.currency)
+	p.mu.Unlock()
+
+	p.deliver(event)
+	return nil
+}
+
+// VerifyWebhook проверяет подпись и время вебхука и разбирает событие
+func (p *FakePaymentProvider) VerifyWebhook(payload []byte, headers http.Header) (PaymentEvent, error) {
+	var event PaymentEvent
+
+	var timestamp, signature string
+	for _, part := range strings.Split(headers.Get(FakePaymentSignatureHeader), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return event, ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > fakeWebhookTolerance || age < -fakeWebhookTolerance {
+		return event, ErrInvalidSignature
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(expected, p.mac(timestamp, payload)) {
+		return event, ErrInvalidSignature
+	}
+
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return event, fmt.Errorf("invalid webhook payload: %w", err)
+	}
+
+	return event, nil
+}
+
+// Sign возвращает значение заголовка подписи для тела вебхука
+func (p *FakePaymentProvider) Sign(payload []byte, at time.Time) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(p.mac(timestamp, payload))
+}
+
+func (p *FakePaymentProvider) mac(timestamp string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (p *FakePaymentProvider) event(kind, reference string, amount float64, currency string) PaymentEvent {
+	return PaymentEvent{ID: "fake_evt_" + uuid.NewString(), Type: kind, Reference: reference, Amount: amount, Currency: currency}
+}
+
+// deliver отправляет вебхук в фоне, чтобы результат операции, как у настоящего шлюза, приходил отдельно от запроса
+func (p *FakePaymentProvider) deliver(event PaymentEvent) {
+	if p.webhookURL == "" {
+		return
+	}
+
+	go func() {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("could not encode payment webhook: %v", err)
+			return
+		}
+
+		request, err := http.NewRequest(http.MethodPost, p.webhookURL, bytes.NewReader(payload))
+		if err != nil {
+			log.Printf("could not create payment webhook: %v", err)
+			return
+		}
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set(FakePaymentSignatureHeader, p.Sign(payload, time.Now()))
+
+		response, err := p.client.Do(request)
+		if err != nil {
+			log.Printf("could not deliver payment webhook %s: %v", event.ID, err)
+			return
+		}
+		response.Body.Close()
+
+		if response.StatusCode >= 300 {
+			log.Printf("payment webhook %s rejected with status %d", event.ID, response.StatusCode)
+		}
+	}()
+}
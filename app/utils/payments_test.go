@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFakePaymentProviderVerifyWebhook(t *testing.T) {
+	provider := NewFakePaymentProvider("secret", "")
+	payload := []byte(`{"id":"evt_1","type":"payment.captured","reference":"fake_pi_1","amount":12.5,"currency":"RUB"}`)
+
+	// Подпись, к которой приписано другое время: время входит в подписываемые данные
+	now := time.Now()
+	swapped := strings.Replace(provider.Sign(payload, now), "t="+strconv.FormatInt(now.Unix(), 10), "t="+strconv.FormatInt(now.Unix()+1, 10), 1)
+
+	tests := []struct {
+		name      string
+		signature string
+		payload   []byte
+		wantErr   bool
+	}{
+		{"valid", provider.Sign(payload, time.Now()), payload, false},
+		{"within tolerance", provider.Sign(payload, time.Now().Add(-4*time.Minute)), payload, false},
+		{"stale timestamp", provider.Sign(payload, time.Now().Add(-6*time.Minute)), payload, true},
+		{"future timestamp", provider.Sign(payload, time.Now().Add(6*time.Minute)), payload, true},
+		{"other secret", NewFakePaymentProvider("other", "").Sign(payload, time.Now()), payload, true},
+		{"modified payload", provider.Sign(payload, time.Now()), []byte(`{"id":"evt_1","type":"payment.captured","reference":"fake_pi_1","amount":1250,"currency":"RUB"}`), true},
+		{"timestamp swapped", swapped, payload, true},
+		{"not hex", "t=" + strconv.FormatInt(time.Now().Unix(), 10) + ",v1=zz", payload, true},
+		{"missing signature", "", payload, true},
+		{"invalid json", provider.Sign([]byte("{"), time.Now()), []byte("{"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := http.Header{}
+			headers.Set(FakePaymentSignatureHeader, tt.signature)
+
+			event, err := provider.VerifyWebhook(tt.payload, headers)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("VerifyWebhook() = %+v, want error", event)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := PaymentEvent{ID: "evt_1", Type: PaymentEventCaptured, Reference: "fake_pi_1", Amount: 12.5, Currency: "RUB"}
+			if event != want {
+				t.Errorf("VerifyWebhook() = %+v, want %+v", event, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package utils
+
+import "math"
+
+// PricingPolicy правила расчета итогов заказа. Суммы считаются в копейках и округляются до копейки,
+// чтобы итоги заказа сходились с суммой строк.
+type PricingPolicy struct {
+	Currency string
+	// TaxRate налог в процентах, начисляемый сверх цены после скидки; 0 — цены уже включают налог
+	TaxRate float64
+	// ShippingFee стоимость доставки; заказ от FreeShippingFrom доставляется бесплатно, если порог задан
+	ShippingFee      float64
+	FreeShippingFrom float64
+}
+
+// OrderTotals итоги заказа
+type OrderTotals struct {
+	Subtotal   float64
+	Discount   float64
+	Tax        float64
+	Shipping   float64
+	GrandTotal float64
+}
+
+// Totals рассчитывает итоги заказа по сумме строк и скидке. Скидка не больше суммы строк;
+// сейчас заказы оформляются без скидок, см. priceOrder.
+func (p PricingPolicy) Totals(subtotal, discount float64) OrderTotals {
+	subtotalCents := ToCents(subtotal)
+	discountCents := min(ToCents(discount), subtotalCents)
+	taxable := subtotalCents - discountCents
+
+	taxCents := int64(math.Round(float64(taxable) * p.TaxRate / 100))
+
+	shippingCents := ToCents(p.ShippingFee)
+	if p.FreeShippingFrom > 0 && taxable >= ToCents(p.FreeShippingFrom) {
+		shippingCents = 0
+	}
+
+	return OrderTotals{
+		Subtotal:   FromCents(subtotalCents),
+		Discount:   FromCents(discountCents),
+		Tax:        FromCents(taxCents),
+		Shipping:   FromCents(shippingCents),
+		GrandTotal: FromCents(taxable + taxCents + shippingCents),
+	}
+}
+
+// LineTotal возвращает стоимость строки заказа, округленную до копейки
+func LineTotal(unitPrice float64, quantity int) float64 {
+	return FromCents(ToCents(unitPrice) * int64(quantity))
+}
+
+// ToCents переводит сумму в копейки
+func ToCents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// FromCents переводит копейки в сумму
+func FromCents(cents int64) float64 {
+	return float64(cents) / 100
+}
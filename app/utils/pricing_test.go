@@ -0,0 +1,96 @@
+package utils
+
+import "testing"
+
+func TestPricingPolicyTotals(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   PricingPolicy
+		subtotal float64
+		discount float64
+		want     OrderTotals
+	}{
+		{
+			name:     "no tax or shipping",
+			subtotal: 100,
+			want:     OrderTotals{Subtotal: 100, GrandTotal: 100},
+		},
+		{
+			name:     "tax rounds half up to a cent",
+			policy:   PricingPolicy{TaxRate: 20},
+			subtotal: 0.05,
+			want:     OrderTotals{Subtotal: 0.05, Tax: 0.01, GrandTotal: 0.06},
+		},
+		{
+			name:     "tax on fractional subtotal",
+			policy:   PricingPolicy{TaxRate: 7.5},
+			subtotal: 19.99,
+			want:     OrderTotals{Subtotal: 19.99, Tax: 1.5, GrandTotal: 21.49},
+		},
+		{
+			name:     "subtotal rounded to cents",
+			policy:   PricingPolicy{TaxRate: 10},
+			subtotal: 0.1 + 0.2,
+			want:     OrderTotals{Subtotal: 0.3, Tax: 0.03, GrandTotal: 0.33},
+		},
+		{
+			name:     "tax after discount",
+			policy:   PricingPolicy{TaxRate: 20},
+			subtotal: 100,
+			discount: 25.5,
+			want:     OrderTotals{Subtotal: 100, Discount: 25.5, Tax: 14.9, GrandTotal: 89.4},
+		},
+		{
+			name:     "discount capped at subtotal",
+			policy:   PricingPolicy{TaxRate: 20, ShippingFee: 5},
+			subtotal: 10,
+			discount: 15,
+			want:     OrderTotals{Subtotal: 10, Discount: 10, Shipping: 5, GrandTotal: 5},
+		},
+		{
+			name:     "shipping below threshold",
+			policy:   PricingPolicy{ShippingFee: 4.99, FreeShippingFrom: 50},
+			subtotal: 49.99,
+			want:     OrderTotals{Subtotal: 49.99, Shipping: 4.99, GrandTotal: 54.98},
+		},
+		{
+			name:     "free shipping from threshold",
+			policy:   PricingPolicy{ShippingFee: 4.99, FreeShippingFrom: 50},
+			subtotal: 50,
+			want:     OrderTotals{Subtotal: 50, GrandTotal: 50},
+		},
+		{
+			name:     "threshold applies after discount",
+			policy:   PricingPolicy{ShippingFee: 4.99, FreeShippingFrom: 50},
+			subtotal: 55,
+			discount: 10,
+			want:     OrderTotals{Subtotal: 55, Discount: 10, Shipping: 4.99, GrandTotal: 49.99},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Totals(tt.subtotal, tt.discount); got != tt.want {
+				t.Errorf("Totals(%v, %v) = %+v, want %+v", tt.subtotal, tt.discount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLineTotal(t *testing.T) {
+	tests := []struct {
+		unitPrice float64
+		quantity  int
+		want      float64
+	}{
+		{19.99, 3, 59.97},
+		{0.1, 3, 0.3},
+		{10, 0, 0},
+	}
+
+	for _, tt := range tests {
+		if got := LineTotal(tt.unitPrice, tt.quantity); got != tt.want {
+			t.Errorf("LineTotal(%v, %d) = %v, want %v", tt.unitPrice, tt.quantity, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config параметры S3-совместимого хранилища (AWS S3, MinIO, Yandex Object Storage и т.п.)
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// PublicURL адрес, по которому объекты доступны клиентам, например CDN; по умолчанию Endpoint/Bucket
+	PublicURL string
+}
+
+type s3Storage struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3Storage создает хранилище, которое обращается к бакету по path-style адресам
+// и подписывает запросы AWS Signature Version 4
+func NewS3Storage(config S3Config) (BlobStorage, error) {
+	endpoint, err := url.Parse(strings.TrimRight(config.Endpoint, "/"))
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", config.Endpoint)
+	}
+
+	if config.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is required")
+	}
+
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+
+	if config.PublicURL == "" {
+		config.PublicURL = endpoint.String() + "/" + config.Bucket
+	}
+	config.PublicURL = strings.TrimRight(config.PublicURL, "/")
+
+	return &s3Storage{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Put загружает объект запросом PutObject
+func (s *s3Storage) Put(ctx context.Context, key, contentType string, data []byte) error {
+	headers := map[string]string{"content-type": contentType}
+	return s.do(ctx, http.MethodPut, key, headers, data, http.StatusOK)
+}
+
+// Delete удаляет объект запросом DeleteObject
+func (s *s3Storage) Delete(ctx context.Context, key string) error {
+	return s.do(ctx, http.MethodDelete, key, nil, nil, http.StatusNoContent, http.StatusOK)
+}
+
+// URL возвращает публичный адрес объекта
+func (s *s3Storage) URL(key string) string {
+	return s.config.PublicURL + "/" + key
+}
+
+func (s *s3Storage) do(ctx context.Context, method, key string, headers map[string]string, body []byte, expected ...int) error {
+	segments := strings.Split(s.config.Bucket+"/"+key, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+
+	target := *s.endpoint
+	target.RawPath = target.Path + "/" + strings.Join(segments, "/")
+	target.Path, _ = url.PathUnescape(target.RawPath)
+
+	request, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not create s3 request: %w", err)
+	}
+
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	s.sign(request, target.EscapedPath(), body, time.Now().UTC())
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("s3 request failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	for _, status := range expected {
+		if response.StatusCode == status {
+			return nil
+		}
+	}
+
+	message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	return fmt.Errorf("s3 %s %s: %s: %s", method, key, response.Status, strings.TrimSpace(string(message)))
+}
+
+// sign добавляет заголовки подписи AWS Signature Version 4
+func (s *s3Storage) sign(request *http.Request, escapedPath string, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	request.Header.Set("x-amz-date", amzDate)
+	request.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signed := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		signed[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		escapedPath,
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, signedHeaders, signature,
+	))
+}
+
+// s3Escape кодирует сегмент пути по правилам Signature Version 4: без изменений остаются только
+// буквы, цифры и символы "-._~". url.PathEscape оставляет, например, "$" и "=", и подпись ключа
+// с такими символами не совпала бы с подписью, которую вычисляет S3
+func s3Escape(segment string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+
+	return escaped.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type SMSService interface {
+	SendSMS(to, message string) error
+}
+
+type consoleSMSService struct{}
+
+// NewConsoleSMSService создает сервис, который печатает сообщения в stdout; для локальной разработки
+func NewConsoleSMSService() SMSService {
+	return consoleSMSService{}
+}
+
+// SendSMS печатает сообщение вместо отправки
+func (consoleSMSService) SendSMS(to, message string) error {
+	fmt.Printf("SMS to %s: %s\n", to, message)
+	return nil
+}
+
+type fileSMSService struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileSMSService создает сервис, который дописывает сообщения в файл; для локальной разработки и тестов
+func NewFileSMSService(path string) SMSService {
+	return &fileSMSService{path: path}
+}
+
+// SendSMS дописывает сообщение в файл
+func (s *fileSMSService) SendSMS(to, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not open sms file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := fmt.Fprintf(file, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), to, message); err != nil {
+		return fmt.Errorf("could not write sms: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// BlobStorage хранилище загруженных файлов. Ключ — относительный путь вида "products/<id>/original.jpg",
+// URL возвращает публичный адрес файла.
+type BlobStorage interface {
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
+
+type localStorage struct {
+	dir     string
+	baseURL string
+}
+
+// NewLocalStorage создает хранилище в каталоге dir; файлы раздаются приложением по адресу baseURL
+func NewLocalStorage(dir, baseURL string) BlobStorage {
+	return &localStorage{dir: dir, baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// Put записывает файл, создавая недостающие каталоги
+func (s *localStorage) Put(_ context.Context, key, _ string, data []byte) error {
+	filename, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return fmt.Errorf("could not create storage directory: %w", err)
+	}
+
+	if err := os.WriteFile(filename, data, 0o644); err != nil {
+		return fmt.Errorf("could not write file: %w", err)
+	}
+
+	return nil
+}
+
+// Delete удаляет файл; отсутствующий файл не считается ошибкой
+func (s *localStorage) Delete(_ context.Context, key string) error {
+	filename, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not delete file: %w", err)
+	}
+
+	return nil
+}
+
+// URL возвращает адрес файла относительно baseURL
+func (s *localStorage) URL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+// path переводит ключ в путь внутри каталога хранилища, не позволяя выйти за его пределы
+func (s *localStorage) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || cleaned != "/"+key {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+
+	return filepath.Join(s.dir, filepath.FromSlash(cleaned)), nil
+}